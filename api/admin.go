@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// adminRoutes registers the back office routes under /admin. Every route
+// requires authentication and the given permission.
+func (s *APISERVER) adminRoutes(router *mux.Router) {
+
+	admin := router.PathPrefix("/admin").Subrouter()
+
+	protect := func(f apiFunc, perm t.Permission) http.HandlerFunc {
+		return util.WithAuth(util.RequirePermission(makeHttpHandleFunc(f), perm), s.store)
+	}
+
+	admin.HandleFunc("/accounts", protect(s.handleGetAccount, t.PermViewAccounts)).Methods("GET")
+	admin.HandleFunc("/accounts/{id}", protect(s.handleGetAccountByID, t.PermViewAccounts)).Methods("GET")
+	admin.HandleFunc("/accounts/{id}/role", protect(s.handleSetRole, t.PermManageRoles)).Methods("PUT")
+	admin.HandleFunc("/accounts/{id}/status", protect(s.handleSetAccountStatus, t.PermManageAccounts)).Methods("PUT")
+	admin.HandleFunc("/accounts/{id}/logins", protect(s.handleGetLogins, t.PermViewAccounts)).Methods("GET")
+	admin.HandleFunc("/accounts/{id}/unlock", protect(s.handleUnlockAccount, t.PermManageAccounts)).Methods("POST")
+	admin.HandleFunc("/transactions", protect(s.handleGetAllTransactions, t.PermViewTransactions)).Methods("GET")
+}
+
+func (s *APISERVER) handleSetRole(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
+
+	var req t.SetRoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	if !t.ValidRole(req.Role) {
+		return t.Validation("invalid_role", "unknown role %q", req.Role)
+	}
+
+	// an admin demoting themselves could leave the bank without any admin
+	caller, _ := util.AccountFromContext(r.Context())
+	if caller.ID == id && req.Role != t.RoleAdmin {
+		return t.Forbidden("forbidden", "admins cannot change their own role")
+	}
+
+	if err := s.store.SetAccountRole(id, req.Role); err != nil {
+		return err
+	}
+
+	account, err := s.store.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, account)
+}
+
+// handleSetAccountStatus freezes, unfreezes, reactivates or closes an
+// account.
+func (s *APISERVER) handleSetAccountStatus(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
+
+	var req t.AccountStatusRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	// staff cannot lock themselves out
+	caller, _ := util.AccountFromContext(r.Context())
+	if caller.ID == id {
+		return t.Forbidden("forbidden", "staff cannot change the status of their own account")
+	}
+
+	now := time.Now().UTC()
+
+	var account *t.Account
+	if req.Status == t.AccountClosed {
+		account, err = s.store.CloseAccount(id, &t.CloseAccountRequest{Reason: req.Reason, PayoutAccount: req.PayoutAccount}, now)
+	} else {
+		account, err = s.store.SetAccountStatus(id, req.Status, req.Reason, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, account)
+}
+
+func (s *APISERVER) handleGetAllTransactions(w http.ResponseWriter, r *http.Request) error {
+
+	history, err := s.store.GetTransactions()
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, history)
+}
@@ -1,19 +1,20 @@
 package api
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mrkhay/gobank/mailer"
 	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
 	"github.com/mrkhay/gobank/utility"
 )
 
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
-type ApiError struct {
-	Error string `json:"error"`
-}
 type ApiSuccess struct {
 	Success string `json:"success"`
 }
@@ -22,40 +23,89 @@ func makeHttpHandleFunc(f apiFunc) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			utility.WriteJson(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			utility.WriteError(w, r, err)
 		}
 
 	}
 }
 
 type APISERVER struct {
-	listenAddr string
-	store      storage.Storage
+	listenAddr     string
+	store          storage.Storage
+	idempotencyTTL time.Duration
+	mailer         mailer.Mailer
+
+	// stepUpThresholds holds, per currency, the amount above which a
+	// payment needs an authenticator code
+	stepUpThresholds map[string]t.Money
 }
 
-func NewApiServer(listenAdr string, store storage.Storage) *APISERVER {
-	return &APISERVER{
-		listenAddr: listenAdr,
-		store:      store,
+// NewApiServer fails if the configuration in the environment is invalid.
+func NewApiServer(listenAdr string, store storage.Storage) (*APISERVER, error) {
+
+	thresholds, err := stepUpThresholds()
+	if err != nil {
+		return nil, err
 	}
+
+	return &APISERVER{
+		listenAddr:     listenAdr,
+		store:          store,
+		idempotencyTTL: utility.GetEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		mailer:         mailer.LogMailer{},
+
+		stepUpThresholds: thresholds,
+	}, nil
 }
 
 func (s *APISERVER) Run() {
+
+	log.Println("JSON API SERVER running on port: ", s.listenAddr)
+	http.ListenAndServe(s.listenAddr, s.Handler())
+
+}
+
+// Handler returns the router with every API route registered.
+func (s *APISERVER) Handler() http.Handler {
 	router := mux.NewRouter()
 
 	// account
-	router.HandleFunc("/test", makeHttpHandleFunc(s.testDB))
-	router.HandleFunc("/topup", makeHttpHandleFunc(s.handleTopUp))
+	router.HandleFunc("/topup", utility.WithAuth(utility.RequirePermission(s.withIdempotency(makeHttpHandleFunc(s.handleTopUp)), t.PermTopUp), s.store))
+	router.HandleFunc("/account", utility.WithAuth(utility.RequirePermission(makeHttpHandleFunc(s.handleGetAccount), t.PermViewAccounts), s.store)).Methods("GET")
 	router.HandleFunc("/account", makeHttpHandleFunc(s.handleAccount))
 	router.HandleFunc("/login", makeHttpHandleFunc(s.handleLogin))
+	router.HandleFunc("/token/refresh", makeHttpHandleFunc(s.handleRefreshToken))
+	router.HandleFunc("/logout", utility.WithAuth(makeHttpHandleFunc(s.handleLogout), s.store))
 	router.HandleFunc("/account/{id}", utility.WithJWTAuth(makeHttpHandleFunc(s.handleAccountWithID), s.store))
+	router.HandleFunc("/account/{id}/statement", utility.WithJWTAuth(makeHttpHandleFunc(s.handleStatement), s.store)).Methods("GET")
 
 	// transactions
-	router.HandleFunc("/transfer", makeHttpHandleFunc(s.handleTransfer))
-	router.HandleFunc("/transactions", makeHttpHandleFunc(s.handleGetTransactions))
-	router.HandleFunc("/transactions/{id}", makeHttpHandleFunc(s.handleGetUserTransactions))
+	router.HandleFunc("/transfer", utility.WithAuth(s.withIdempotency(makeHttpHandleFunc(s.handleTransfer)), s.store))
+	router.HandleFunc("/transactions", utility.WithAuth(makeHttpHandleFunc(s.handleGetTransactions), s.store))
+	router.HandleFunc("/transactions/{id}", utility.WithAuth(makeHttpHandleFunc(s.handleGetUserTransactions), s.store))
+	router.HandleFunc("/transactions/{id}/reverse", utility.WithAuth(utility.RequirePermission(s.withIdempotency(makeHttpHandleFunc(s.handleReverseTransaction)), t.PermReverse), s.store)).Methods("POST")
+	router.HandleFunc("/transactions/{id}/refund", utility.WithAuth(s.withIdempotency(makeHttpHandleFunc(s.handleRefundTransaction)), s.store)).Methods("POST")
 
-	log.Println("JSON API SERVER running on port: ", s.listenAddr)
-	http.ListenAndServe(s.listenAddr, router)
+	s.mfaRoutes(router)
+	s.loginRoutes(router)
+	s.passwordRoutes(router)
+	s.standingOrderRoutes(router)
+	s.webhookRoutes(router)
+	s.fxRoutes(router)
+	s.holdRoutes(router)
+	s.adminRoutes(router)
+
+	return utility.WithCorrelationID(router)
+}
+
+// decodeJSON reads the request body into v and checks it against the
+// validate tags of its fields, reporting malformed input as a validation
+// error.
+func decodeJSON(r *http.Request, v any) error {
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return t.Validation("invalid_body", "invalid request body: %v", err)
+	}
 
+	return t.Validate(v)
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// issueTokens starts a new refresh token family for acc and returns it
+// together with a fresh access token.
+func (s *APISERVER) issueTokens(acc *t.Account) (*t.TokenResponse, error) {
+
+	plain, rt, err := util.NewRefreshToken(acc.AccountNumber)
+	if err != nil {
+		return nil, err
+	}
+	rt.FamilyId = rt.Id
+
+	if err := s.store.CreateRefreshToken(rt); err != nil {
+		return nil, err
+	}
+
+	token, err := util.CreateJWT(acc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t.TokenResponse{
+		Account:      acc,
+		Token:        token,
+		RefreshToken: plain,
+		ExpiresIn:    int(util.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+func (s *APISERVER) handleRefreshToken(w http.ResponseWriter, r *http.Request) error {
+
+	if r.Method != "POST" {
+		return t.MethodNotAllowed(r.Method)
+	}
+
+	var req t.RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	plain, next, err := util.NewRefreshToken(0)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.RotateRefreshToken(util.HashToken(req.RefreshToken), next); err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByAccountNumber(next.AccountNumber)
+	if err != nil {
+		return storage.ErrRefreshTokenInvalid
+	}
+
+	token, err := util.CreateJWT(acc)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, t.TokenResponse{
+		Token:        token,
+		RefreshToken: plain,
+		ExpiresIn:    int(util.AccessTokenTTL().Seconds()),
+	})
+}
+
+// handleLogout revokes the refresh token family of the session and the
+// access token used to call it.
+func (s *APISERVER) handleLogout(w http.ResponseWriter, r *http.Request) error {
+
+	if r.Method != "POST" {
+		return t.MethodNotAllowed(r.Method)
+	}
+
+	var req t.RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	claims, _ := util.ClaimsFromContext(r.Context())
+
+	err := s.store.RevokeRefreshTokenFamily(util.HashToken(req.RefreshToken), caller.AccountNumber)
+	if err != nil && !errors.Is(err, storage.ErrRefreshTokenInvalid) {
+		return err
+	}
+
+	if err := s.store.RevokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0).UTC()); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: "logged out"})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// fxRoutes registers the exchange rate routes. Anyone logged in can read
+// the rates; changing them needs PermManageFXRates.
+func (s *APISERVER) fxRoutes(router *mux.Router) {
+
+	manage := func(f apiFunc) http.HandlerFunc {
+		return util.WithAuth(util.RequirePermission(makeHttpHandleFunc(f), t.PermManageFXRates), s.store)
+	}
+
+	router.HandleFunc("/fx-rates", util.WithAuth(makeHttpHandleFunc(s.handleGetFXRates), s.store)).Methods("GET")
+	router.HandleFunc("/admin/fx-rates", manage(s.handleGetFXRates)).Methods("GET")
+	router.HandleFunc("/admin/fx-rates/{base}/{quote}", manage(s.handleSetFXRate)).Methods("PUT")
+	router.HandleFunc("/admin/fx-rates/{base}/{quote}", manage(s.handleDeleteFXRate)).Methods("DELETE")
+}
+
+func (s *APISERVER) handleGetFXRates(w http.ResponseWriter, r *http.Request) error {
+
+	rates, err := s.store.GetFXRates()
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, rates)
+}
+
+func (s *APISERVER) handleSetFXRate(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.SetFXRateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	base, quote := currencyPair(r)
+	rate, err := t.NewFXRate(base, quote, &req, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.SetFXRate(rate); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, rate)
+}
+
+func (s *APISERVER) handleDeleteFXRate(w http.ResponseWriter, r *http.Request) error {
+
+	base, quote := currencyPair(r)
+	if err := s.store.DeleteFXRate(base, quote); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func currencyPair(r *http.Request) (string, string) {
+	vars := mux.Vars(r)
+	return strings.ToUpper(vars["base"]), strings.ToUpper(vars["quote"])
+}
@@ -1,24 +1,26 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mrkhay/gobank/storage"
 	t "github.com/mrkhay/gobank/type"
 	util "github.com/mrkhay/gobank/utility"
 )
 
 func (s *APISERVER) handleAccount(w http.ResponseWriter, r *http.Request) error {
 
-	if r.Method == "GET" {
-		return s.handleGetAccount(w, r)
-	}
 	if r.Method == "POST" {
 		return s.handleCreateAccount(w, r)
 	}
 
-	return fmt.Errorf("method not allowed %v", r.Method)
+	return t.MethodNotAllowed(r.Method)
 }
 
 func (s *APISERVER) handleAccountWithID(w http.ResponseWriter, r *http.Request) error {
@@ -28,11 +30,15 @@ func (s *APISERVER) handleAccountWithID(w http.ResponseWriter, r *http.Request)
 		return s.handleGetAccountByID(w, r)
 	}
 
+	if r.Method == "PATCH" {
+		return s.handleUpdateAccount(w, r)
+	}
+
 	if r.Method == "DELETE" {
 		return s.handleDeleteAccount(w, r)
 	}
 
-	return fmt.Errorf("invalid %v method", r.Method)
+	return t.MethodNotAllowed(r.Method)
 
 }
 
@@ -52,22 +58,73 @@ func (s *APISERVER) handleGetAccountByID(w http.ResponseWriter, r *http.Request)
 	return util.WriteJson(w, http.StatusOK, account)
 
 }
-func (s *APISERVER) testDB(w http.ResponseWriter, r *http.Request) error {
+func (s *APISERVER) handleUpdateAccount(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
 
-	res, err := s.store.TranscationTest()
+	var req t.UpdateAccountRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
 
+	account, err := s.store.GetAccountByID(id)
 	if err != nil {
 		return err
 	}
 
-	return util.WriteJson(w, http.StatusOK, res)
+	if req.FirstName != nil {
+		account.FirstName = strings.TrimSpace(*req.FirstName)
+	}
+
+	if req.LastName != nil {
+		account.LastName = strings.TrimSpace(*req.LastName)
+	}
+
+	if req.Email != nil {
+
+		// changing only the case keeps the caller's own email; a request
+		// racing for the same email is turned away by the storage. Any
+		// other change needs the password, or a stolen session could move
+		// the account's password resets to another inbox.
+		email := strings.TrimSpace(*req.Email)
+		if !strings.EqualFold(email, account.Email) {
+			if req.Password == nil {
+				return t.Validation("password_required", "changing the email needs the password of the account")
+			}
+
+			if err := s.checkOwnPassword(w, r, account, *req.Password, t.Validation("invalid_password", "password is wrong")); err != nil {
+				return err
+			}
+
+			isInUse, err := s.store.CheckIfEmailExists(email)
+			if err != nil {
+				return err
+			}
+
+			if isInUse {
+				return storage.ErrEmailInUse
+			}
+		}
+
+		account.Email = email
+	}
+
+	account.Version = req.Version
+	if err := s.store.UpdateAccount(account); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, account)
 
 }
 
 func (s *APISERVER) handleTopUp(w http.ResponseWriter, r *http.Request) error {
 
 	var req t.TopUpRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSON(r, &req); err != nil {
 		return err
 	}
 
@@ -76,7 +133,7 @@ func (s *APISERVER) handleTopUp(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: fmt.Sprintf("account(%d) funded with $%s ", req.Account, req.Amount)})
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: fmt.Sprintf("account(%d) funded with %s %s", req.Account, req.Amount, req.Amount.Currency)})
 
 }
 
@@ -96,32 +153,51 @@ func (s *APISERVER) handleGetAccount(w http.ResponseWriter, r *http.Request) err
 func (s *APISERVER) handleLogin(w http.ResponseWriter, r *http.Request) error {
 
 	if r.Method != "POST" {
-		return fmt.Errorf("invalid %v method", r.Method)
+		return t.MethodNotAllowed(r.Method)
 	}
 
 	var req t.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	attempt := newLoginAttempt(r, req.Email)
+	if err := s.reserveLoginAttempt(w, attempt); err != nil {
 		return err
 	}
 
 	acc, err := s.store.GetAccountByPasswordAndEmail(&req)
+	if err == nil && acc.Status == t.AccountClosed {
+		err = storage.ErrInvalidCredentials
+	}
 
 	if err != nil {
-		return err
+		if isLoginFailure(err) {
+			return s.loginFailed(attempt, err)
+		}
+		return s.loginAborted(attempt, err)
+	}
+
+	challenge, err := s.startMFAChallenge(acc)
+	if err != nil {
+		return s.loginAborted(attempt, err)
+	}
+
+	if challenge != nil {
+		if err := s.loginSucceeded(attempt, t.LoginMFARequired); err != nil {
+			return err
+		}
+		return util.WriteJson(w, http.StatusOK, challenge)
 	}
 
-	tokenString, err := util.CreateJWT(acc)
+	responce, err := s.issueTokens(acc)
 
 	if err != nil {
-		return err
+		return s.loginAborted(attempt, err)
 	}
 
-	responce := struct {
-		Account *t.Account `json:"account"`
-		Token   *string    `json:"token"`
-	}{
-		Account: acc,
-		Token:   &tokenString,
+	if err := s.loginSucceeded(attempt, t.LoginSucceeded); err != nil {
+		return err
 	}
 
 	return util.WriteJson(w, http.StatusOK, responce)
@@ -131,8 +207,10 @@ func (s *APISERVER) handleLogin(w http.ResponseWriter, r *http.Request) error {
 // AccountResponse represents the response for the CreateAccount endpoint.
 // swagger:response AccountResponse
 type CreateAccountResonce struct {
-	Account *t.Account `json:"account"`
-	Token   *string    `json:"token"`
+	Account      *t.Account `json:"account"`
+	Token        string     `json:"token"`
+	RefreshToken string     `json:"refresh_token"`
+	ExpiresIn    int        `json:"expires_in"`
 }
 
 // CreateAccount returns account with token.
@@ -142,7 +220,7 @@ type CreateAccountResonce struct {
 // Returns account with token..
 //
 // Responses:
-//   200: AccountResponse
+//   201: AccountResponse
 // 500:
 //300
 
@@ -150,48 +228,55 @@ func (s *APISERVER) handleCreateAccount(w http.ResponseWriter, r *http.Request)
 
 	req := new(t.CreateAccountRequest)
 
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	if err := decodeJSON(r, req); err != nil {
 		return err
 	}
 
-	account, err := t.NewAccount(req.FirstName, req.LastName, req.Email, req.Password)
+	isInUse, err := s.store.CheckIfEmailExists(req.Email)
 
 	if err != nil {
 		return err
 	}
 
-	if req.Email == "" || req.FirstName == "" || req.LastName == "" || req.Password == "" {
-		return fmt.Errorf("1 or more credentials are missing")
+	if isInUse {
+		return storage.ErrEmailInUse
 	}
 
-	isInUse, err := s.store.CheckIfEmailExists(req.Email)
+	account, err := t.NewAccount(req.FirstName, req.LastName, req.Email, req.Password)
 
 	if err != nil {
 		return err
 	}
 
-	if isInUse {
-		return fmt.Errorf("email address already in use")
+	if req.Currency != "" {
+		account.Balance = t.NewMoney(0, req.Currency)
 	}
 
 	if err := s.store.CreateAccount(account); err != nil {
 		return err
 	}
 
-	tokenString, err := util.CreateJWT(account)
+	// the account exists now; without the mail the customer can still ask
+	// for another at /email/verification
+	if err := s.sendEmailVerification(account); err != nil {
+		log.Printf("verification email for account %d: %v", account.AccountNumber, err)
+	}
+
+	tokens, err := s.issueTokens(account)
 
 	if err != nil {
 		return err
 	}
 
-	responce := CreateAccountResonce{
-		Account: account,
-		Token:   &tokenString,
-	}
+	responce := CreateAccountResonce(*tokens)
 
-	return util.WriteJson(w, http.StatusOK, responce)
+	return util.WriteJson(w, http.StatusCreated, responce)
 
 }
+
+// handleDeleteAccount closes the caller's account. Accounts are never
+// removed, so their history stays; the body is optional and names where to
+// pay out anything left on the account.
 func (s *APISERVER) handleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
 
 	id, err := util.GetId(r)
@@ -200,17 +285,52 @@ func (s *APISERVER) handleDeleteAccount(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	if err := s.store.DeleteAccount(id); err != nil {
+	var req t.CloseAccountRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			return err
+		}
+	}
+
+	if req.Reason == "" {
+		req.Reason = "closed by account holder"
+	}
+
+	// a payout moves everything left on the account, so it needs a code
+	// if any of the balances would as a transfer; the code is used once
+	if req.PayoutAccount != 0 {
+		caller, _ := util.AccountFromContext(r.Context())
+		for _, balance := range caller.Balances {
+			if s.needsStepUp(balance) {
+				if err := s.checkStepUp(r, caller, balance); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	account, err := s.store.CloseAccount(id, &req, time.Now().UTC())
+	if err != nil {
 		return err
 	}
 
-	return util.WriteJson(w, http.StatusOK, map[string]int{"deleted": id})
+	return util.WriteJson(w, http.StatusOK, account)
 }
 func (s *APISERVER) handleTransfer(w http.ResponseWriter, r *http.Request) error {
 
 	if r.Method == "POST" {
 		var req t.TransferRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := decodeJSON(r, &req); err != nil {
+			return err
+		}
+
+		caller, _ := util.AccountFromContext(r.Context())
+		if int64(req.FromAccount) != caller.AccountNumber {
+			return t.Forbidden("forbidden", "you can only transfer from your own account")
+		}
+
+		if err := s.checkStepUp(r, caller, req.Amount); err != nil {
 			return err
 		}
 
@@ -222,23 +342,33 @@ func (s *APISERVER) handleTransfer(w http.ResponseWriter, r *http.Request) error
 		return util.WriteJson(w, http.StatusOK, res)
 	}
 
-	return fmt.Errorf("invalid %v method", r.Method)
+	return t.MethodNotAllowed(r.Method)
 
 }
 func (s *APISERVER) handleGetTransactions(w http.ResponseWriter, r *http.Request) error {
 
 	if r.Method == "GET" {
 
-		t, err := s.store.GetTransactions()
+		// staff see every transaction, customers only their own
+		caller, _ := util.AccountFromContext(r.Context())
+		if !t.HasPermission(caller.Role, t.PermViewTransactions) {
+			history, err := s.store.GetUserTransactions(int(caller.AccountNumber))
+			if err != nil {
+				return err
+			}
+			return util.WriteJson(w, http.StatusOK, history)
+		}
+
+		history, err := s.store.GetTransactions()
 
 		if err != nil {
 			return err
 		}
-		return util.WriteJson(w, http.StatusOK, t)
+		return util.WriteJson(w, http.StatusOK, history)
 
 	}
 
-	return fmt.Errorf("invalid %v method", r.Method)
+	return t.MethodNotAllowed(r.Method)
 
 }
 
@@ -249,11 +379,100 @@ func (s *APISERVER) handleGetUserTransactions(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	t, err := s.store.GetUserTransactions(id)
+	caller, _ := util.AccountFromContext(r.Context())
+	if int64(id) != caller.AccountNumber && !t.HasPermission(caller.Role, t.PermViewTransactions) {
+		return t.Forbidden("forbidden", "you can only view your own transactions")
+	}
 
+	account, err := s.store.GetAccountByAccountNumber(int64(id))
 	if err != nil {
 		return err
 	}
 
-	return util.WriteJson(w, http.StatusOK, &t)
+	filter, err := transactionFilter(r.URL.Query(), account.Balance.Currency)
+	if err != nil {
+		return err
+	}
+
+	page, err := s.store.ListUserTransactions(id, filter)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, page)
+}
+
+// transactionFilter reads the history filters from the query string.
+// Amounts are in the account's currency unless another one is given;
+// dates are RFC 3339 timestamps or plain dates, and to is exclusive.
+func transactionFilter(q url.Values, currency string) (*t.TransactionFilter, error) {
+
+	filter := &t.TransactionFilter{
+		Direction: q.Get("direction"),
+		Status:    q.Get("status"),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > t.MaxPageSize {
+			return nil, t.Validation("invalid_limit", "limit must be between 1 and %d", t.MaxPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := t.DecodeTransactionCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = cursor
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			date, err := parseDate(v)
+			if err != nil {
+				return nil, t.Validation("invalid_"+name, "%s must be a date such as 2006-01-02 or an RFC 3339 timestamp", name)
+			}
+			*dst = date
+		}
+	}
+
+	if v := q.Get("currency"); v != "" {
+		currency = v
+	}
+
+	for name, dst := range map[string]**t.Money{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if v := q.Get(name); v != "" {
+			amount, err := t.ParseMoney(v, currency)
+			if err != nil {
+				return nil, t.Validation("invalid_"+name, "%s: %v", name, err)
+			}
+			*dst = &amount
+		}
+	}
+
+	if filter.Direction != "" && filter.Direction != t.DirectionSent && filter.Direction != t.DirectionReceived {
+		return nil, t.Validation("invalid_direction", "direction must be %q or %q", t.DirectionSent, t.DirectionReceived)
+	}
+
+	if v := q.Get("counterparty"); v != "" {
+		number, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || !t.ValidAccountNumber(number) {
+			return nil, t.Validation("invalid_counterparty", "counterparty must be an account number")
+		}
+		filter.Counterparty = number
+	}
+
+	return filter, nil
+}
+
+func parseDate(v string) (time.Time, error) {
+
+	if date, err := time.Parse("2006-01-02", v); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse(time.RFC3339, v)
+	return date.UTC(), err
 }
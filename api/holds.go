@@ -0,0 +1,170 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// HoldResponse is a captured hold together with the transfer that
+// captured it.
+type HoldResponse struct {
+	Hold        *t.Hold        `json:"hold"`
+	Transaction *t.Transcation `json:"transaction"`
+}
+
+// holdRoutes registers the hold routes. A customer places holds on their
+// own account in favour of another; only that beneficiary, or staff, can
+// capture or release them.
+func (s *APISERVER) holdRoutes(router *mux.Router) {
+
+	router.HandleFunc("/holds", util.WithAuth(s.withIdempotency(makeHttpHandleFunc(s.handleCreateHold)), s.store)).Methods("POST")
+	router.HandleFunc("/holds/{id}", util.WithAuth(makeHttpHandleFunc(s.handleGetHold), s.store)).Methods("GET")
+	router.HandleFunc("/holds/{id}/capture", util.WithAuth(s.withIdempotency(makeHttpHandleFunc(s.handleCaptureHold)), s.store)).Methods("POST")
+	router.HandleFunc("/holds/{id}/release", util.WithAuth(makeHttpHandleFunc(s.handleReleaseHold), s.store)).Methods("POST")
+	router.HandleFunc("/account/{id}/holds", util.WithJWTAuth(makeHttpHandleFunc(s.handleGetHolds), s.store)).Methods("GET")
+}
+
+func (s *APISERVER) handleCreateHold(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.CreateHoldRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if req.Account != caller.AccountNumber && !t.HasPermission(caller.Role, t.PermManageHolds) {
+		return t.Forbidden("forbidden", "you can only place holds on your own account")
+	}
+
+	// the payer authorises the money here; capturing it later is up to
+	// the payee
+	if req.Account == caller.AccountNumber {
+		if err := s.checkStepUp(r, caller, req.Amount); err != nil {
+			return err
+		}
+	}
+
+	hold, err := t.NewHold(&req, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.CreateHold(hold); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusCreated, hold)
+}
+
+func (s *APISERVER) handleGetHold(w http.ResponseWriter, r *http.Request) error {
+
+	hold, err := s.visibleHold(r)
+	if err != nil {
+		return err
+	}
+
+	hold.Expire(time.Now().UTC())
+	return util.WriteJson(w, http.StatusOK, hold)
+}
+
+func (s *APISERVER) handleGetHolds(w http.ResponseWriter, r *http.Request) error {
+
+	caller, _ := util.AccountFromContext(r.Context())
+
+	holds, err := s.store.GetHolds(caller.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, hold := range holds {
+		hold.Expire(now)
+	}
+
+	return util.WriteJson(w, http.StatusOK, holds)
+}
+
+// handleCaptureHold pays the beneficiary out of a hold. The body is
+// optional; without an amount the whole hold is captured.
+func (s *APISERVER) handleCaptureHold(w http.ResponseWriter, r *http.Request) error {
+
+	hold, err := s.beneficiaryHold(r)
+	if err != nil {
+		return err
+	}
+
+	var req t.CaptureHoldRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			return err
+		}
+	}
+
+	hold, tran, err := s.store.CaptureHold(hold.Id, req.Amount, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, HoldResponse{Hold: hold, Transaction: tran})
+}
+
+func (s *APISERVER) handleReleaseHold(w http.ResponseWriter, r *http.Request) error {
+
+	hold, err := s.beneficiaryHold(r)
+	if err != nil {
+		return err
+	}
+
+	hold, err = s.store.ReleaseHold(hold.Id, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, hold)
+}
+
+// visibleHold loads the {id} hold of the request, which the caller must be
+// a party to unless they manage holds. Other holds are reported as not
+// found.
+func (s *APISERVER) visibleHold(r *http.Request) (*t.Hold, error) {
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, t.Validation("invalid_id", "invalid hold id")
+	}
+
+	hold, err := s.store.GetHold(id)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if hold.Account != caller.AccountNumber && hold.ToAccount != caller.AccountNumber &&
+		!t.HasPermission(caller.Role, t.PermManageHolds) {
+		return nil, t.NotFound("hold_not_found", "hold %s not found", id)
+	}
+
+	return hold, nil
+}
+
+// beneficiaryHold is visibleHold for capturing and releasing, which the
+// account holder cannot do: the funds are promised to the beneficiary.
+func (s *APISERVER) beneficiaryHold(r *http.Request) (*t.Hold, error) {
+
+	hold, err := s.visibleHold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if hold.ToAccount != caller.AccountNumber && !t.HasPermission(caller.Role, t.PermManageHolds) {
+		return nil, t.Forbidden("forbidden", "only the beneficiary can capture or release a hold")
+	}
+
+	return hold, nil
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of the status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// withIdempotency makes a money moving endpoint safe to retry. The first
+// request with a given Idempotency-Key runs normally and, if it succeeds,
+// its response is stored; a retry with the same key and body gets the
+// stored response replayed, while a retry with a different body is
+// rejected. A request that fails has not changed anything, so its key is
+// released for a retry, say with the step-up code it was missing.
+// Requests without the header run as before.
+func (s *APISERVER) withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			util.WriteError(w, r, t.Validation("invalid_idempotency_key", "Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			util.WriteError(w, r, t.Validation("invalid_body", "could not read request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys are scoped to the caller so one client cannot replay or
+		// block another client's requests by guessing its keys
+		scope := r.Method + " " + r.URL.Path
+		if caller, ok := util.AccountFromContext(r.Context()); ok {
+			scope = fmt.Sprintf("%d:%s", caller.AccountNumber, scope)
+		}
+
+		sum := sha256.Sum256(append([]byte(scope+"\n"), body...))
+		now := time.Now().UTC()
+		rec := &t.IdempotencyRecord{
+			Key:         key,
+			Scope:       scope,
+			Fingerprint: hex.EncodeToString(sum[:]),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.idempotencyTTL),
+		}
+
+		existing, err := s.store.ReserveIdempotencyKey(rec)
+		if err != nil {
+			util.WriteError(w, r, fmt.Errorf("reserve idempotency key: %w", err))
+			return
+		}
+
+		if existing != nil {
+			replayIdempotent(w, r, rec, existing)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		// only completed requests are kept; errors, including ones the
+		// client can fix such as a missing code or a rate limit, are not
+		if recorder.status < 200 || recorder.status > 299 {
+			if err := s.store.ReleaseIdempotencyKey(rec.Key, rec.Scope); err != nil {
+				log.Println("release idempotency key:", err)
+			}
+			return
+		}
+
+		rec.StatusCode = recorder.status
+		rec.Response = recorder.body.Bytes()
+		if err := s.store.CompleteIdempotencyKey(rec); err != nil {
+			log.Println("store idempotent response:", err)
+		}
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, rec, existing *t.IdempotencyRecord) {
+
+	if existing.Fingerprint != rec.Fingerprint {
+		util.WriteError(w, r, t.Conflict("idempotency_key_reused", "Idempotency-Key was already used with a different request"))
+		return
+	}
+
+	if !existing.Completed() {
+		util.WriteError(w, r, t.Conflict("idempotency_key_in_progress", "a request with this Idempotency-Key is still being processed"))
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Idempotent-Replayed", "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.Response)
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// loginHistoryLimit is how many attempts /account/{id}/logins shows.
+const loginHistoryLimit = 50
+
+// loginRoutes registers the login history of the caller's own account.
+// Staff reach it, and the unlock route, under /admin.
+func (s *APISERVER) loginRoutes(router *mux.Router) {
+
+	router.HandleFunc("/account/{id}/logins", util.WithJWTAuth(makeHttpHandleFunc(s.handleGetLogins), s.store)).Methods("GET")
+}
+
+func (s *APISERVER) handleGetLogins(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := s.store.GetLoginAttempts(acc.AccountNumber, loginHistoryLimit)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, attempts)
+}
+
+// handleUnlockAccount lifts a lockout of the account's email before it
+// runs out. Lockouts of an IP address are left to expire.
+func (s *APISERVER) handleUnlockAccount(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.ClearLoginThrottle(t.EmailThrottleKey(normalizeEmail(acc.Email))); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: "account unlocked"})
+}
+
+// newLoginAttempt starts the history entry of a login with email.
+func newLoginAttempt(r *http.Request, email string) *t.LoginAttempt {
+	return &t.LoginAttempt{
+		Id:        uuid.New(),
+		Email:     truncate(strings.TrimSpace(email), 255),
+		IP:        truncate(util.ClientIP(r), 64),
+		UserAgent: truncate(r.UserAgent(), 512),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+type loginThrottle struct {
+	key    string
+	policy t.ThrottlePolicy
+}
+
+// loginThrottles are the counters a login attempt is held back by and
+// counted against.
+func loginThrottles(a *t.LoginAttempt) []loginThrottle {
+	return []loginThrottle{
+		{t.EmailThrottleKey(normalizeEmail(a.Email)), t.EmailLoginPolicy},
+		{t.IPThrottleKey(a.IP), t.IPLoginPolicy},
+	}
+}
+
+// reserveLoginAttempt counts the attempt as failed against its email and
+// address before the credentials are checked, so a burst of concurrent
+// guesses cannot all get in under the limit; loginSucceeded takes it back.
+// If either has failed too often lately the attempt is turned away and the
+// client told how long to wait. Unknown emails are counted the same as
+// real ones, so this gives nothing away.
+func (s *APISERVER) reserveLoginAttempt(w http.ResponseWriter, a *t.LoginAttempt) error {
+
+	wait, locked, err := s.reserveThrottles(loginThrottles(a), a.CreatedAt)
+	if err != nil || wait == 0 {
+		return err
+	}
+
+	a.Result = t.LoginThrottled
+	if err := s.store.AddLoginAttempt(a); err != nil {
+		return err
+	}
+
+	seconds := retryAfter(w, wait)
+
+	if locked {
+		return t.TooManyRequests("login_locked", "too many failed logins, try again in %d minutes", int(math.Ceil(wait.Minutes())))
+	}
+
+	return t.TooManyRequests("login_throttled", "too many failed logins, try again in %d seconds", seconds)
+}
+
+// resetThrottles are the counters a password reset request is held back
+// by. They are kept apart from the login ones.
+func resetThrottles(a *t.LoginAttempt) []loginThrottle {
+	return []loginThrottle{
+		{t.ResetThrottleKey(normalizeEmail(a.Email)), t.EmailResetPolicy},
+		{t.ResetIPThrottleKey(a.IP), t.IPResetPolicy},
+	}
+}
+
+// reserveThrottles reserves an attempt at now against each of throttles.
+// If any of them has to wait, nothing is reserved and the longest wait is
+// returned, with whether it is a lockout.
+func (s *APISERVER) reserveThrottles(throttles []loginThrottle, now time.Time) (time.Duration, bool, error) {
+
+	var wait time.Duration
+	locked := false
+	reserved := []loginThrottle{}
+
+	for _, th := range throttles {
+		counter, ok, err := s.store.ReserveLoginAttempt(th.key, th.policy, now)
+		if err != nil {
+			s.refundLoginAttempt(reserved)
+			return 0, false, err
+		}
+
+		if ok {
+			reserved = append(reserved, th)
+			continue
+		}
+
+		if d := counter.RetryAfter(now, th.policy); d > wait {
+			wait = d
+		}
+		locked = locked || counter.Locked(now)
+	}
+
+	if wait > 0 {
+		if err := s.refundLoginAttempt(reserved); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return wait, locked, nil
+}
+
+// retryAfter tells the client to wait, in whole seconds which it returns.
+func retryAfter(w http.ResponseWriter, wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	return seconds
+}
+
+// loginFailed records a failed attempt, which reserveLoginAttempt already
+// counted, and returns err for the client.
+func (s *APISERVER) loginFailed(a *t.LoginAttempt, err error) error {
+
+	a.Result = t.LoginFailed
+	if err := s.store.AddLoginAttempt(a); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// checkOwnPassword verifies the password of acc for a caller who is
+// already signed in but about to do something sensitive. Wrong guesses
+// count against the login limits like failed logins, and get wrong back.
+func (s *APISERVER) checkOwnPassword(w http.ResponseWriter, r *http.Request, acc *t.Account, password string, wrong error) error {
+
+	attempt := newLoginAttempt(r, acc.Email)
+	if err := s.reserveLoginAttempt(w, attempt); err != nil {
+		return err
+	}
+
+	_, err := s.store.GetAccountByPasswordAndEmail(&t.LoginRequest{Email: acc.Email, Pasword: password})
+	if err == nil {
+		return s.refundLoginAttempt(loginThrottles(attempt))
+	}
+
+	if isLoginFailure(err) {
+		return s.loginFailed(attempt, wrong)
+	}
+
+	return s.loginAborted(attempt, err)
+}
+
+// loginAborted takes back the reservation of an attempt that could not be
+// checked, and returns err.
+func (s *APISERVER) loginAborted(a *t.LoginAttempt, err error) error {
+	s.refundLoginAttempt(loginThrottles(a))
+	return err
+}
+
+// loginSucceeded records the attempt with result and takes back its
+// reservation. Only a complete login resets the failures of the email; the
+// address keeps its count so one client cannot wipe it by logging in to an
+// account of its own.
+func (s *APISERVER) loginSucceeded(a *t.LoginAttempt, result string) error {
+
+	a.Result = result
+	if err := s.store.AddLoginAttempt(a); err != nil {
+		return err
+	}
+
+	if err := s.refundLoginAttempt(loginThrottles(a)); err != nil {
+		return err
+	}
+
+	if result != t.LoginSucceeded {
+		return nil
+	}
+
+	return s.store.ClearLoginThrottle(t.EmailThrottleKey(normalizeEmail(a.Email)))
+}
+
+func (s *APISERVER) refundLoginAttempt(throttles []loginThrottle) error {
+
+	for _, th := range throttles {
+		if err := s.store.RefundLoginAttempt(th.key, th.policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isLoginFailure reports whether err means the credentials were wrong, as
+// opposed to the request failing for some other reason.
+func isLoginFailure(err error) bool {
+	return errors.Is(err, storage.ErrInvalidCredentials) || errors.Is(err, storage.ErrInvalidMFACode)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
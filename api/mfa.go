@@ -0,0 +1,310 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// StepUpHeader carries the authenticator code that transfers above the
+// step-up threshold need.
+const StepUpHeader = "X-Gobank-OTP"
+
+// mfaRoutes registers the TOTP enrolment routes and the second half of a
+// login to an account with MFA enabled.
+func (s *APISERVER) mfaRoutes(router *mux.Router) {
+
+	router.HandleFunc("/mfa/enroll", util.WithAuth(makeHttpHandleFunc(s.handleEnrollMFA), s.store)).Methods("POST")
+	router.HandleFunc("/mfa/verify", util.WithAuth(makeHttpHandleFunc(s.handleVerifyMFA), s.store)).Methods("POST")
+	router.HandleFunc("/mfa", util.WithAuth(makeHttpHandleFunc(s.handleDisableMFA), s.store)).Methods("DELETE")
+	router.HandleFunc("/login/mfa", makeHttpHandleFunc(s.handleLoginMFA)).Methods("POST")
+}
+
+// stepUpThresholds reads MFA_STEP_UP_THRESHOLD, the amount above which a
+// payment needs an authenticator code, in every supported currency. It
+// fails if the amount cannot be given in all of them, rather than leave
+// payments in some currencies unguarded.
+func stepUpThresholds() (map[string]t.Money, error) {
+
+	v := os.Getenv("MFA_STEP_UP_THRESHOLD")
+	if v == "" {
+		v = "1000"
+	}
+
+	thresholds := map[string]t.Money{}
+	for _, currency := range t.Currencies() {
+		threshold, err := t.ParseMoney(v, currency)
+		if err != nil {
+			return nil, fmt.Errorf("MFA_STEP_UP_THRESHOLD: %v", err)
+		}
+		if threshold.Amount < 0 {
+			return nil, fmt.Errorf("MFA_STEP_UP_THRESHOLD: %q is negative", v)
+		}
+		thresholds[currency] = threshold
+	}
+
+	return thresholds, nil
+}
+
+// handleEnrollMFA provisions a new secret. MFA is not enabled until a code
+// from it is confirmed at /mfa/verify.
+func (s *APISERVER) handleEnrollMFA(w http.ResponseWriter, r *http.Request) error {
+
+	caller, _ := util.AccountFromContext(r.Context())
+
+	secret, err := util.NewTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	err = s.store.SetMFASecret(&t.MFA{
+		AccountNumber: caller.AccountNumber,
+		Secret:        secret,
+		CreatedAt:     time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, t.MFAEnrollResponse{
+		Secret: secret,
+		URI:    util.TOTPURI(secret, caller.Email),
+	})
+}
+
+// handleVerifyMFA confirms a pending enrolment with a code and hands out
+// the recovery codes, which are not shown again.
+func (s *APISERVER) handleVerifyMFA(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.MFACodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	now := time.Now().UTC()
+
+	m, err := s.store.GetMFA(caller.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	if err := s.useTOTPCode(m, req.Code, now); err != nil {
+		return err
+	}
+
+	codes, err := util.NewRecoveryCodes(t.RecoveryCodeCount)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = util.HashToken(code)
+	}
+
+	if err := s.store.EnableMFA(caller.AccountNumber, hashes, now); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, t.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handleDisableMFA turns MFA off, which takes a code or a recovery code so
+// that a stolen access token alone cannot do it.
+func (s *APISERVER) handleDisableMFA(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.MFACodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+
+	if err := s.checkSecondFactor(caller.AccountNumber, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := s.store.DisableMFA(caller.AccountNumber); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: "two factor authentication disabled"})
+}
+
+// handleLoginMFA completes a login started at /login by trading the
+// challenge token and a second factor for tokens.
+func (s *APISERVER) handleLoginMFA(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.MFALoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	hash := util.HashToken(req.MFAToken)
+	now := time.Now().UTC()
+
+	challenge, err := s.store.GetMFAChallenge(hash, now)
+	if err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByAccountNumber(challenge.AccountNumber)
+	if err != nil || acc.Status == t.AccountClosed {
+		return storage.ErrMFAChallengeInvalid
+	}
+
+	// wrong codes count against the same limits as wrong passwords, or a
+	// known password would buy endless guesses at the code
+	attempt := newLoginAttempt(r, acc.Email)
+	if err := s.reserveLoginAttempt(w, attempt); err != nil {
+		return err
+	}
+
+	if err := s.checkSecondFactor(challenge.AccountNumber, req.Code, req.RecoveryCode); err != nil {
+		if !isLoginFailure(err) {
+			return s.loginAborted(attempt, err)
+		}
+
+		if err := s.store.FailMFAChallenge(hash); err != nil {
+			return err
+		}
+		return s.loginFailed(attempt, err)
+	}
+
+	if err := s.store.UseMFAChallenge(hash, now); err != nil {
+		return s.loginAborted(attempt, err)
+	}
+
+	res, err := s.issueTokens(acc)
+	if err != nil {
+		return s.loginAborted(attempt, err)
+	}
+
+	if err := s.loginSucceeded(attempt, t.LoginSucceeded); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, res)
+}
+
+// startMFAChallenge starts the second half of a login with the right
+// password. It returns nil if the account has no MFA enabled.
+func (s *APISERVER) startMFAChallenge(acc *t.Account) (*t.MFAChallengeResponse, error) {
+
+	m, err := s.store.GetMFA(acc.AccountNumber)
+	if t.KindOf(err) == t.KindNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !m.Enabled() {
+		return nil, nil
+	}
+
+	plain, err := util.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := util.GetEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+	now := time.Now().UTC()
+
+	err = s.store.CreateMFAChallenge(&t.MFAChallenge{
+		Id:            uuid.New(),
+		AccountNumber: acc.AccountNumber,
+		TokenHash:     util.HashToken(plain),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &t.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    plain,
+		ExpiresIn:   int(ttl.Seconds()),
+	}, nil
+}
+
+// needsStepUp reports whether a payment of amount needs an authenticator
+// code. A currency without a threshold always does.
+func (s *APISERVER) needsStepUp(amount t.Money) bool {
+	threshold, ok := s.stepUpThresholds[amount.Currency]
+	return !ok || amount.Amount > threshold.Amount
+}
+
+// checkStepUp makes sure a payment of amount by caller that is above the
+// step-up threshold comes with a valid authenticator code. Every way a
+// caller can move money out of their account goes through it: transfers,
+// placing holds, setting up or raising standing orders, and closing the
+// account with a payout.
+func (s *APISERVER) checkStepUp(r *http.Request, caller *t.Account, amount t.Money) error {
+
+	if !s.needsStepUp(amount) {
+		return nil
+	}
+
+	limit := "this payment"
+	if threshold, ok := s.stepUpThresholds[amount.Currency]; ok {
+		limit = fmt.Sprintf("payments above %s %s", threshold, threshold.Currency)
+	}
+
+	m, err := s.store.GetMFA(caller.AccountNumber)
+	if t.KindOf(err) == t.KindNotFound || (err == nil && !m.Enabled()) {
+		return t.Forbidden("mfa_required", "%s need two factor authentication, enable it at /mfa/enroll", limit)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	code := r.Header.Get(StepUpHeader)
+	if code == "" {
+		return t.Forbidden("mfa_required", "%s need a code from your authenticator app in the %s header", limit, StepUpHeader)
+	}
+
+	return s.useTOTPCode(m, code, time.Now().UTC())
+}
+
+// checkSecondFactor verifies a TOTP code, or a recovery code if one is
+// given, for an account with MFA enabled. Either is only accepted once.
+func (s *APISERVER) checkSecondFactor(account int64, code, recoveryCode string) error {
+
+	m, err := s.store.GetMFA(account)
+	if err != nil {
+		return err
+	}
+
+	if !m.Enabled() {
+		return t.Conflict("mfa_not_enabled", "two factor authentication is not enabled, confirm a code at /mfa/verify first")
+	}
+
+	now := time.Now().UTC()
+	if recoveryCode != "" {
+		return s.store.UseRecoveryCode(account, util.HashToken(util.NormalizeRecoveryCode(recoveryCode)), now)
+	}
+
+	return s.useTOTPCode(m, code, now)
+}
+
+func (s *APISERVER) useTOTPCode(m *t.MFA, code string, now time.Time) error {
+
+	step, ok := util.VerifyTOTP(m.Secret, code, now)
+	if !ok {
+		return storage.ErrInvalidMFACode
+	}
+
+	return s.store.UseTOTPStep(m.AccountNumber, step)
+}
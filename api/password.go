@@ -0,0 +1,254 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mrkhay/gobank/mailer"
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// passwordRoutes registers email verification, password reset and
+// password change. They live outside /account so they cannot be taken for
+// /account/{id}.
+func (s *APISERVER) passwordRoutes(router *mux.Router) {
+
+	router.HandleFunc("/email/verify", makeHttpHandleFunc(s.handleVerifyEmail)).Methods("POST")
+	router.HandleFunc("/email/verification", util.WithAuth(makeHttpHandleFunc(s.handleResendVerification), s.store)).Methods("POST")
+	router.HandleFunc("/password/forgot", makeHttpHandleFunc(s.handleForgotPassword)).Methods("POST")
+	router.HandleFunc("/password/reset", makeHttpHandleFunc(s.handleResetPassword)).Methods("POST")
+	router.HandleFunc("/password/change", util.WithAuth(makeHttpHandleFunc(s.handleChangePassword), s.store)).Methods("POST")
+}
+
+// SetMailer replaces the mailer, which logs messages by default.
+func (s *APISERVER) SetMailer(m mailer.Mailer) {
+	s.mailer = m
+}
+
+// appURL is where the links in mails point to, APP_URL.
+func appURL() string {
+	if v := os.Getenv("APP_URL"); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return "http://localhost:3000"
+}
+
+func (s *APISERVER) handleVerifyEmail(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.VerifyEmailRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	tok, err := s.store.UseAccountToken(util.HashToken(req.Token), t.TokenEmailVerification, now)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.MarkEmailVerified(tok.AccountNumber, tok.Email, now); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: "email verified"})
+}
+
+func (s *APISERVER) handleResendVerification(w http.ResponseWriter, r *http.Request) error {
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if caller.EmailVerifiedAt != nil {
+		return t.Conflict("email_verified", "the email of the account is already verified")
+	}
+
+	if err := s.sendEmailVerification(caller); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusAccepted, ApiSuccess{Success: "verification email sent"})
+}
+
+// handleForgotPassword mails a reset link if the email has an account. It
+// answers the same either way so it cannot be used to find accounts.
+// Requests are limited per email and per address, so it cannot be used to
+// flood someone's inbox either; the limits are kept apart from the login
+// ones, so asking for resets never locks the account out.
+func (s *APISERVER) handleForgotPassword(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.ForgotPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	attempt := newLoginAttempt(r, req.Email)
+	wait, _, err := s.reserveThrottles(resetThrottles(attempt), attempt.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if wait > 0 {
+		seconds := retryAfter(w, wait)
+		return t.TooManyRequests("password_reset_throttled", "too many requests, try again in %d seconds", seconds)
+	}
+
+	accepted := ApiSuccess{Success: "if the email has an account, a reset link is on its way"}
+
+	acc, err := s.store.GetAccountByEmail(normalizeEmail(req.Email))
+	if t.KindOf(err) == t.KindNotFound {
+		return util.WriteJson(w, http.StatusAccepted, accepted)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if acc.Status != t.AccountClosed {
+		plain, err := s.newAccountToken(acc, t.TokenPasswordReset, t.PasswordResetTTL)
+		if err != nil {
+			return err
+		}
+
+		link := appURL() + "/reset-password?token=" + url.QueryEscape(plain)
+		s.mail(&mailer.Message{
+			To:      acc.Email,
+			Subject: "Reset your Gobank password",
+			Body: fmt.Sprintf("Hello %s,\n\nUse the link below to choose a new password. It works once and expires in %s.\n\n%s\n\n"+
+				"If you did not ask for this, you can ignore this email; your password stays the same.\n",
+				acc.FirstName, t.PasswordResetTTL, link),
+		})
+	}
+
+	return util.WriteJson(w, http.StatusAccepted, accepted)
+}
+
+// handleResetPassword sets a new password with a token from a reset mail.
+// Every session of the account is signed out and any login lockout lifted.
+func (s *APISERVER) handleResetPassword(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.ResetPasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	tok, err := s.store.UseAccountToken(util.HashToken(req.Token), t.TokenPasswordReset, now)
+	if err != nil {
+		return err
+	}
+
+	// the link only stands for the inbox it was sent to
+	acc, err := s.store.GetAccountByAccountNumber(tok.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(acc.Email, tok.Email) {
+		return storage.ErrEmailChanged
+	}
+
+	if err := s.setPassword(tok.AccountNumber, req.NewPassword, now); err != nil {
+		return err
+	}
+
+	if err := s.store.ClearLoginThrottle(t.EmailThrottleKey(normalizeEmail(tok.Email))); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ApiSuccess{Success: "password changed, please log in"})
+}
+
+// handleChangePassword sets a new password for the caller, who has to give
+// the old one too. The other sessions and their access tokens are signed
+// out; the caller gets new tokens so they stay logged in.
+func (s *APISERVER) handleChangePassword(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.ChangePasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+
+	if err := s.checkOwnPassword(w, r, caller, req.OldPassword, t.Validation("invalid_password", "old_password is wrong")); err != nil {
+		return err
+	}
+
+	if err := s.setPassword(caller.AccountNumber, req.NewPassword, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	tokens, err := s.issueTokens(caller)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, tokens)
+}
+
+func (s *APISERVER) setPassword(account int64, password string, now time.Time) error {
+
+	hash, err := t.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.store.SetPassword(account, hash, now)
+}
+
+// sendEmailVerification mails acc a link that proves its email is real.
+func (s *APISERVER) sendEmailVerification(acc *t.Account) error {
+
+	plain, err := s.newAccountToken(acc, t.TokenEmailVerification, t.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/verify-email?token=" + url.QueryEscape(plain)
+	s.mail(&mailer.Message{
+		To:      acc.Email,
+		Subject: "Verify your Gobank email",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm that this is your email by opening the link below within %s.\n\n%s\n",
+			acc.FirstName, t.EmailVerificationTTL, link),
+	})
+
+	return nil
+}
+
+// newAccountToken stores a new token for purpose and returns the plain
+// token for the mail.
+func (s *APISERVER) newAccountToken(acc *t.Account, purpose string, ttl time.Duration) (string, error) {
+
+	plain, err := util.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = s.store.CreateAccountToken(&t.AccountToken{
+		Id:            uuid.New(),
+		AccountNumber: acc.AccountNumber,
+		Purpose:       purpose,
+		Email:         acc.Email,
+		TokenHash:     util.HashToken(plain),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	})
+
+	return plain, err
+}
+
+// mail sends msg. A mail that cannot be sent is logged rather than failing
+// the request, which has already done its work.
+func (s *APISERVER) mail(msg *mailer.Message) {
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("mail to %s failed: %v", msg.To, err)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// ReversalResponse is the compensating transaction together with the
+// original in its new state.
+type ReversalResponse struct {
+	Original    *t.Transcation `json:"original"`
+	Transaction *t.Transcation `json:"transaction"`
+}
+
+func (s *APISERVER) handleReverseTransaction(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := transactionId(r)
+	if err != nil {
+		return err
+	}
+
+	comp, err := s.store.ReverseTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	return s.writeReversal(w, comp)
+}
+
+// handleRefundTransaction lets the recipient of a transfer give all or
+// part of it back. The body is optional; without an amount everything not
+// refunded yet is given back.
+func (s *APISERVER) handleRefundTransaction(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := transactionId(r)
+	if err != nil {
+		return err
+	}
+
+	var req t.RefundRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			return err
+		}
+	}
+
+	tran, err := s.store.GetTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if tran.Rec_acc.AccountNumber != caller.AccountNumber {
+		return t.Forbidden("forbidden", "only the recipient can refund a transaction")
+	}
+
+	comp, err := s.store.RefundTransaction(id, req.Amount)
+	if err != nil {
+		return err
+	}
+
+	return s.writeReversal(w, comp)
+}
+
+func (s *APISERVER) writeReversal(w http.ResponseWriter, comp *t.Transcation) error {
+
+	original, err := s.store.GetTransaction(*comp.OriginalId)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, ReversalResponse{Original: original, Transaction: comp})
+}
+
+func transactionId(r *http.Request) (uuid.UUID, error) {
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, t.Validation("invalid_id", "invalid transaction id")
+	}
+
+	return id, nil
+}
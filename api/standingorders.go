@@ -0,0 +1,207 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+type StandingOrderResponse struct {
+	*t.StandingOrder
+	Runs []*t.StandingOrderRun `json:"runs"`
+}
+
+// standingOrderRoutes registers the standing order routes of the caller's
+// own account.
+func (s *APISERVER) standingOrderRoutes(router *mux.Router) {
+
+	own := func(f apiFunc) http.HandlerFunc {
+		return util.WithJWTAuth(makeHttpHandleFunc(f), s.store)
+	}
+
+	router.HandleFunc("/account/{id}/standing-orders", own(s.handleGetStandingOrders)).Methods("GET")
+	router.HandleFunc("/account/{id}/standing-orders", own(s.handleCreateStandingOrder)).Methods("POST")
+	router.HandleFunc("/account/{id}/standing-orders/{order}", own(s.handleGetStandingOrder)).Methods("GET")
+	router.HandleFunc("/account/{id}/standing-orders/{order}", own(s.handleUpdateStandingOrder)).Methods("PATCH")
+	router.HandleFunc("/account/{id}/standing-orders/{order}", own(s.handleCancelStandingOrder)).Methods("DELETE")
+}
+
+func (s *APISERVER) handleGetStandingOrders(w http.ResponseWriter, r *http.Request) error {
+
+	caller, _ := util.AccountFromContext(r.Context())
+
+	orders, err := s.store.GetStandingOrders(caller.AccountNumber)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, orders)
+}
+
+func (s *APISERVER) handleCreateStandingOrder(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.CreateStandingOrderRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if !caller.HasCurrency(req.Amount.Currency) {
+		return t.Validation("currency_mismatch", "account %d does not hold %s", caller.AccountNumber, req.Amount.Currency)
+	}
+
+	if err := s.checkStepUp(r, caller, req.Amount); err != nil {
+		return err
+	}
+
+	if _, err := s.store.GetAccountByAccountNumber(req.ToAccount); err != nil {
+		return err
+	}
+
+	order, err := t.NewStandingOrder(caller.AccountNumber, &req, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.CreateStandingOrder(order); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusCreated, order)
+}
+
+func (s *APISERVER) handleGetStandingOrder(w http.ResponseWriter, r *http.Request) error {
+
+	order, err := s.ownStandingOrder(r)
+	if err != nil {
+		return err
+	}
+
+	runs, err := s.store.GetStandingOrderRuns(order.Id)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, StandingOrderResponse{StandingOrder: order, Runs: runs})
+}
+
+func (s *APISERVER) handleUpdateStandingOrder(w http.ResponseWriter, r *http.Request) error {
+
+	order, err := s.changeableStandingOrder(r)
+	if err != nil {
+		return err
+	}
+
+	var req t.UpdateStandingOrderRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	if req.Amount != nil {
+		if err := req.Amount.Validate(); err != nil || !req.Amount.IsPositive() || req.Amount.Currency != order.Amount.Currency {
+			return t.Validation("invalid_amount", "amount must be a positive amount in %s", order.Amount.Currency)
+		}
+
+		if *req.Amount != order.Amount {
+			caller, _ := util.AccountFromContext(r.Context())
+			if err := s.checkStepUp(r, caller, *req.Amount); err != nil {
+				return err
+			}
+		}
+		order.Amount = *req.Amount
+	}
+
+	if req.Description != nil {
+		order.Description = *req.Description
+	}
+
+	if req.EndDate != nil {
+		if req.EndDate.Before(order.NextRunAt) {
+			return t.Validation("invalid_end_date", "end_date must not be before the next run")
+		}
+		order.EndDate = req.EndDate
+	}
+
+	if req.Count != nil {
+		if *req.Count != 0 && *req.Count <= order.RunsDone {
+			return t.Validation("invalid_count", "count must be more than the %d runs already made", order.RunsDone)
+		}
+		order.MaxRuns = *req.Count
+	}
+
+	if req.Status != nil {
+		if *req.Status != t.StandingOrderActive && *req.Status != t.StandingOrderPaused {
+			return t.Validation("invalid_status", "status must be %q or %q", t.StandingOrderActive, t.StandingOrderPaused)
+		}
+		order.Status = *req.Status
+	}
+
+	order.UpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateStandingOrder(order); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, order)
+}
+
+func (s *APISERVER) handleCancelStandingOrder(w http.ResponseWriter, r *http.Request) error {
+
+	order, err := s.changeableStandingOrder(r)
+	if err != nil {
+		return err
+	}
+
+	order.Status = t.StandingOrderCancelled
+	order.UpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateStandingOrder(order); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, order)
+}
+
+// ownStandingOrder loads the {order} of the request, which must belong to
+// the caller. Other people's orders are reported as not found.
+func (s *APISERVER) ownStandingOrder(r *http.Request) (*t.StandingOrder, error) {
+
+	id, err := uuid.Parse(mux.Vars(r)["order"])
+	if err != nil {
+		return nil, t.Validation("invalid_id", "invalid standing order id")
+	}
+
+	order, err := s.store.GetStandingOrder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	if order.Account != caller.AccountNumber {
+		return nil, t.NotFound("standing_order_not_found", "standing order %s not found", id)
+	}
+
+	return order, nil
+}
+
+// changeableStandingOrder is ownStandingOrder for orders that are about to
+// be changed, which excludes finished orders and orders being executed.
+func (s *APISERVER) changeableStandingOrder(r *http.Request) (*t.StandingOrder, error) {
+
+	order, err := s.ownStandingOrder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Finished() {
+		return nil, t.Conflict("standing_order_finished", "standing order is %s", order.Status)
+	}
+
+	if order.LockedUntil != nil && order.LockedUntil.After(time.Now().UTC()) {
+		return nil, t.Conflict("standing_order_running", "standing order is being executed, try again shortly")
+	}
+
+	return order, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// handleStatement serves GET /account/{id}/statement?from=&to=&format=&currency=.
+// The period defaults to the current month so far; to is exclusive. The
+// statement covers one sub-balance, the account's own currency by default.
+func (s *APISERVER) handleStatement(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := util.GetId(r)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+
+	if v := q.Get("from"); v != "" {
+		if from, err = parseDate(v); err != nil {
+			return t.Validation("invalid_from", "from must be a date such as 2006-01-02 or an RFC 3339 timestamp")
+		}
+	}
+
+	if v := q.Get("to"); v != "" {
+		if to, err = parseDate(v); err != nil {
+			return t.Validation("invalid_to", "to must be a date such as 2006-01-02 or an RFC 3339 timestamp")
+		}
+	}
+
+	if !from.Before(to) {
+		return t.Validation("invalid_period", "from must be before to")
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	if format != "csv" && format != "pdf" {
+		return t.Validation("invalid_format", "format must be csv or pdf")
+	}
+
+	account, err := s.store.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+
+	history, err := s.store.GetUserTransactions(int(account.AccountNumber))
+	if err != nil {
+		return err
+	}
+
+	currency := account.Balance.Currency
+	if v := q.Get("currency"); v != "" {
+		if _, err := t.CurrencyExponent(v); err != nil {
+			return t.Validation("invalid_currency", "%v", err)
+		}
+		currency = v
+	}
+
+	statement, err := t.NewStatement(account, currency, history, from, to)
+	if err != nil {
+		return err
+	}
+
+	// render fully before writing so a failure can still be reported
+	var buf bytes.Buffer
+	contentType := "text/csv"
+	render := util.WriteStatementCSV
+	if format == "pdf" {
+		contentType = "application/pdf"
+		render = util.WriteStatementPDF
+	}
+
+	if err := render(&buf, statement); err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("statement-%d-%s.%s", account.AccountNumber, from.Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	t "github.com/mrkhay/gobank/type"
+	util "github.com/mrkhay/gobank/utility"
+)
+
+// webhookRoutes registers the webhook routes twice: under /account/{id}
+// for endpoints receiving one customer's events, and under /admin for
+// tenant wide endpoints receiving everyone's.
+func (s *APISERVER) webhookRoutes(router *mux.Router) {
+
+	own := func(f apiFunc) http.HandlerFunc {
+		return util.WithJWTAuth(makeHttpHandleFunc(f), s.store)
+	}
+	admin := func(f apiFunc) http.HandlerFunc {
+		return util.WithAuth(util.RequirePermission(makeHttpHandleFunc(f), t.PermManageWebhooks), s.store)
+	}
+
+	for prefix, protect := range map[string]func(apiFunc) http.HandlerFunc{
+		"/account/{id}/webhooks": own,
+		"/admin/webhooks":        admin,
+	} {
+		router.HandleFunc(prefix, protect(s.handleGetWebhooks)).Methods("GET")
+		router.HandleFunc(prefix, protect(s.handleCreateWebhook)).Methods("POST")
+		router.HandleFunc(prefix+"/dead-letters", protect(s.handleGetDeadLetters)).Methods("GET")
+		router.HandleFunc(prefix+"/deliveries/{delivery}/redeliver", protect(s.handleRedeliver)).Methods("POST")
+		router.HandleFunc(prefix+"/{webhook}", protect(s.handleDeleteWebhook)).Methods("DELETE")
+	}
+}
+
+// webhookOwner is the account whose endpoints a request manages, or 0 for
+// the tenant wide endpoints on the admin routes.
+func webhookOwner(r *http.Request) int64 {
+
+	if _, ok := mux.Vars(r)["id"]; !ok {
+		return 0
+	}
+
+	caller, _ := util.AccountFromContext(r.Context())
+	return caller.AccountNumber
+}
+
+func (s *APISERVER) handleGetWebhooks(w http.ResponseWriter, r *http.Request) error {
+
+	endpoints, err := s.store.GetWebhookEndpoints(webhookOwner(r))
+	if err != nil {
+		return err
+	}
+
+	// the secret is only ever shown when the endpoint is created
+	for _, e := range endpoints {
+		e.Secret = ""
+	}
+
+	return util.WriteJson(w, http.StatusOK, endpoints)
+}
+
+func (s *APISERVER) handleCreateWebhook(w http.ResponseWriter, r *http.Request) error {
+
+	var req t.CreateWebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+
+	secret, err := util.RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := t.NewWebhookEndpoint(webhookOwner(r), &req, "whsec_"+secret, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.CreateWebhookEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusCreated, endpoint)
+}
+
+func (s *APISERVER) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := uuid.Parse(mux.Vars(r)["webhook"])
+	if err != nil {
+		return t.Validation("invalid_id", "invalid webhook id")
+	}
+
+	if err := s.store.DeleteWebhookEndpoint(id, webhookOwner(r)); err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, map[string]string{"deleted": id.String()})
+}
+
+func (s *APISERVER) handleGetDeadLetters(w http.ResponseWriter, r *http.Request) error {
+
+	deliveries, err := s.store.GetWebhookDeliveries(webhookOwner(r), t.DeliveryDead)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusOK, deliveries)
+}
+
+func (s *APISERVER) handleRedeliver(w http.ResponseWriter, r *http.Request) error {
+
+	id, err := uuid.Parse(mux.Vars(r)["delivery"])
+	if err != nil {
+		return t.Validation("invalid_id", "invalid delivery id")
+	}
+
+	delivery, err := s.store.RedeliverWebhook(id, webhookOwner(r), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return util.WriteJson(w, http.StatusAccepted, delivery)
+}
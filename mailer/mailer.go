@@ -0,0 +1,140 @@
+// Package mailer sends email to account holders.
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// FromEnv returns the mailer chosen by MAIL_TRANSPORT: "smtp", "file" or
+// "log", the default.
+func FromEnv() (Mailer, error) {
+
+	switch transport := os.Getenv("MAIL_TRANSPORT"); transport {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		return NewFileMailer(os.Getenv("MAIL_DIR"))
+	case "smtp":
+		return NewSMTPMailer()
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+	}
+}
+
+func from() string {
+	if v := os.Getenv("MAIL_FROM"); v != "" {
+		return v
+	}
+	return "Gobank <no-reply@gobank.local>"
+}
+
+// format renders msg as an RFC 5322 message. Header values are stripped of
+// line breaks so they cannot smuggle in headers of their own.
+func format(sender string, msg *Message) []byte {
+
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(sender))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// LogMailer writes every message to the log instead of sending it.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg *Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes every message to a .eml file in Dir, for local
+// development where nothing should leave the machine.
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+
+	if dir == "" {
+		dir = "mail"
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{Dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg *Message) error {
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(m.Dir, name), format(from(), msg), 0o600)
+}
+
+// SMTPMailer sends messages through an SMTP server, with STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer configures an SMTPMailer from SMTP_HOST, SMTP_PORT (587 by
+// default), SMTP_USERNAME and SMTP_PASSWORD.
+func NewSMTPMailer() (*SMTPMailer, error) {
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail transport")
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	m := &SMTPMailer{Addr: net.JoinHostPort(host, port), From: from()}
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		m.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+
+	sender := m.From
+	if i := strings.LastIndex(sender, "<"); i >= 0 {
+		sender = strings.TrimSuffix(sender[i+1:], ">")
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, sender, []string{msg.To}, format(m.From, msg))
+}
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/mailer"
+	"github.com/mrkhay/gobank/scheduler"
 	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
 )
 
 func main() {
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "bootstrap-admin":
+			runBootstrapAdmin(os.Args[2:])
+			return
+		}
+	}
+
 	port := flag.String("p", "", "specify port number")
+	backend := flag.String("store", defaultStore(), storeUsage)
 	flag.Parse()
 
-	store, err := storage.NewPostgresStorage()
+	store, err := newStorage(*backend)
 	if err != nil {
 		log.Fatal("Failed to connect - ", err)
 	}
@@ -23,12 +41,190 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// numbers from before check digits are only valid if they were issued
+	legacy, err := store.LegacyAccountNumbers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	types.SetLegacyAccountNumbers(legacy)
+
 	if *port == "" {
 		log.Fatal("port address required")
 	}
 
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	notifier := scheduler.MailNotifier{Mailer: mail}
+
+	// standing orders, webhooks, hold expiry and dormancy are processed in
+	// the background of the API process
+	go scheduler.New(store, notifier).Run(context.Background())
+	go scheduler.NewWebhookDispatcher(store, nil).Run(context.Background())
+	go scheduler.NewHoldExpirer(store).Run(context.Background())
+	go scheduler.NewDormancyJob(store, notifier).Run(context.Background())
+
 	// instace of server
-	server := api.NewApiServer(fmt.Sprintf(":%s", *port), store)
+	server, err := api.NewApiServer(fmt.Sprintf(":%s", *port), store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.SetMailer(mail)
 	server.Run()
 
 }
+
+const storeUsage = "storage backend: a database URL (postgres://..., sqlite:path/to/gobank.db or memory:), " +
+	"or postgres to connect to $POSTGRES_URI; defaults to $DATABASE_URL"
+
+// defaultStore is DATABASE_URL, or postgres when it is not set.
+func defaultStore() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	return "postgres"
+}
+
+func newStorage(backend string) (storage.Storage, error) {
+
+	switch backend {
+	case "postgres":
+		return storage.NewPostgresStorage()
+	case "memory":
+		backend = "memory:"
+	}
+
+	store, err := storage.Open(backend)
+	if _, ok := store.(*storage.MemoryStorage); ok {
+		log.Println("using in-memory storage, data will not survive a restart")
+	}
+
+	return store, err
+}
+
+// runMigrate implements `gobank migrate up|down|status`.
+func runMigrate(args []string) {
+
+	cmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	backend := cmd.String("store", defaultStore(), storeUsage)
+	steps := cmd.Int("steps", 1, "number of migrations to roll back with down")
+	cmd.Usage = func() {
+		fmt.Fprintln(cmd.Output(), "usage: gobank migrate [-store url] [-steps n] up|down|status")
+		cmd.PrintDefaults()
+	}
+	cmd.Parse(args)
+
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	s, err := newStorage(*backend)
+	if err != nil {
+		log.Fatal("Failed to connect - ", err)
+	}
+
+	store, ok := s.(storage.Migrator)
+	if !ok {
+		log.Fatal("this storage backend has no schema to migrate")
+	}
+
+	switch cmd.Arg(0) {
+	case "up":
+		err = store.MigrateUp()
+	case "down":
+		err = store.MigrateDown(*steps)
+	case "status":
+		var status []*storage.MigrationStatus
+		status, err = store.MigrationStatus()
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", m.Version, m.Name, applied)
+		}
+	default:
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runBootstrapAdmin implements `gobank bootstrap-admin`, which creates the
+// first admin account, or promotes an existing account with that email.
+// It refuses to run once any admin exists; further admins are appointed
+// through PUT /admin/accounts/{id}/role.
+func runBootstrapAdmin(args []string) {
+
+	cmd := flag.NewFlagSet("bootstrap-admin", flag.ExitOnError)
+	backend := cmd.String("store", defaultStore(), storeUsage)
+	email := cmd.String("email", "", "email of the admin account")
+	password := cmd.String("password", os.Getenv("ADMIN_PASSWORD"), "password for a new admin account (defaults to $ADMIN_PASSWORD)")
+	firstName := cmd.String("firstname", "Admin", "first name for a new admin account")
+	lastName := cmd.String("lastname", "Admin", "last name for a new admin account")
+	cmd.Parse(args)
+
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+
+	store, err := newStorage(*backend)
+	if err != nil {
+		log.Fatal("Failed to connect - ", err)
+	}
+
+	if err := store.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	exists, err := store.HasAccountWithRole(types.RoleAdmin)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if exists {
+		log.Fatal("an admin account already exists")
+	}
+
+	// emails match in any case, as they do at login
+	address := strings.TrimSpace(*email)
+	acc, err := store.GetAccountByEmail(strings.ToLower(address))
+	if err == nil {
+		if err := store.SetAccountRole(acc.ID, types.RoleAdmin); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("promoted account %d (%s) to admin\n", acc.AccountNumber, acc.Email)
+		return
+	}
+
+	if types.KindOf(err) != types.KindNotFound {
+		log.Fatal(err)
+	}
+
+	if *password == "" {
+		log.Fatal("-password or ADMIN_PASSWORD is required to create a new account")
+	}
+
+	// the admin is held to the same rules as anyone signing up
+	req := &types.CreateAccountRequest{FirstName: *firstName, LastName: *lastName, Email: address, Password: *password}
+	if err := types.Validate(req); err != nil {
+		log.Fatal(err)
+	}
+
+	acc, err = types.NewAccount(req.FirstName, req.LastName, req.Email, req.Password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	acc.Role = types.RoleAdmin
+
+	if err := store.CreateAccount(acc); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("created admin account %d (%s)\n", acc.AccountNumber, acc.Email)
+}
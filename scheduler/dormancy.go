@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mrkhay/gobank/storage"
+	"github.com/mrkhay/gobank/utility"
+)
+
+// DormancyJob marks accounts that have gone without transactions for
+// longer than period as dormant, and tells their holders.
+type DormancyJob struct {
+	store    storage.Storage
+	notifier Notifier
+	period   time.Duration
+	interval time.Duration
+}
+
+func NewDormancyJob(store storage.Storage, notifier Notifier) *DormancyJob {
+	return &DormancyJob{
+		store:    store,
+		notifier: notifier,
+		period:   utility.GetEnvDuration("DORMANCY_PERIOD", 365*24*time.Hour),
+		interval: utility.GetEnvDuration("DORMANCY_INTERVAL", time.Hour),
+	}
+}
+
+// Run looks for dormant accounts every interval until ctx is cancelled.
+func (j *DormancyJob) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunDue(time.Now().UTC()); err != nil {
+			log.Println("dormancy:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue marks every account inactive for the whole period up to now.
+func (j *DormancyJob) RunDue(now time.Time) error {
+
+	accounts, err := j.store.MarkDormantAccounts(now.Add(-j.period), now)
+	if err != nil {
+		return err
+	}
+
+	for _, acc := range accounts {
+		message := "your account has had no activity for a long time and is now dormant; contact support to reactivate it"
+		if err := j.notifier.Notify(acc, "Account dormant", message); err != nil {
+			log.Printf("dormancy: notify %d: %v", acc.AccountNumber, err)
+		}
+	}
+
+	return nil
+}
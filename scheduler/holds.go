@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mrkhay/gobank/storage"
+	"github.com/mrkhay/gobank/utility"
+)
+
+// HoldExpirer marks holds that have run out as expired. Expired holds stop
+// counting against the available balance at once; this only brings their
+// status up to date.
+type HoldExpirer struct {
+	store    storage.Storage
+	interval time.Duration
+}
+
+func NewHoldExpirer(store storage.Storage) *HoldExpirer {
+	return &HoldExpirer{
+		store:    store,
+		interval: utility.GetEnvDuration("HOLD_EXPIRY_INTERVAL", time.Minute),
+	}
+}
+
+// Run expires holds every interval until ctx is cancelled.
+func (e *HoldExpirer) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.RunDue(time.Now().UTC()); err != nil {
+			log.Println("holds:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue expires every hold that ran out by now.
+func (e *HoldExpirer) RunDue(now time.Time) error {
+
+	n, err := e.store.ExpireHolds(now)
+	if n > 0 {
+		log.Printf("holds: expired %d", n)
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+// Package scheduler runs background jobs inside the API process.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrkhay/gobank/mailer"
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+)
+
+// Notifier tells an account holder about something that happened on
+// their account.
+type Notifier interface {
+	Notify(acc *t.Account, subject, message string) error
+}
+
+// LogNotifier writes notifications to the log instead of sending them.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(acc *t.Account, subject, message string) error {
+	log.Printf("notify %s (%d): %s: %s", acc.Email, acc.AccountNumber, subject, message)
+	return nil
+}
+
+// MailNotifier emails notifications to the account holder.
+type MailNotifier struct {
+	Mailer mailer.Mailer
+}
+
+func (n MailNotifier) Notify(acc *t.Account, subject, message string) error {
+	return n.Mailer.Send(&mailer.Message{To: acc.Email, Subject: subject, Body: message})
+}
+
+// Scheduler executes due standing orders. Every instance of the API can
+// run one; orders are claimed with a lease so each run happens once.
+type Scheduler struct {
+	store    storage.Storage
+	notifier Notifier
+	interval time.Duration
+	lease    time.Duration
+	batch    int
+}
+
+func New(store storage.Storage, notifier Notifier) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		notifier: notifier,
+		interval: utility.GetEnvDuration("SCHEDULER_INTERVAL", time.Minute),
+		lease:    5 * time.Minute,
+		batch:    100,
+	}
+}
+
+// Run executes due orders every interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunDue(time.Now().UTC()); err != nil {
+			log.Println("standing orders:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue executes every standing order that is due at now.
+func (s *Scheduler) RunDue(now time.Time) error {
+
+	for {
+		orders, err := s.store.ClaimDueStandingOrders(now, s.lease, s.batch)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range orders {
+			s.execute(o, now)
+		}
+
+		if len(orders) < s.batch {
+			return nil
+		}
+	}
+}
+
+func (s *Scheduler) execute(o *t.StandingOrder, now time.Time) {
+
+	run := &t.StandingOrderRun{
+		Id:        uuid.New(),
+		OrderId:   o.Id,
+		Run:       o.RunsDone,
+		Attempt:   o.Attempts + 1,
+		CreatedAt: now,
+	}
+
+	// the paid run is stored with the transfer, so a run whose transfer
+	// went through is not paid again however long saving the order failed
+	_, err := s.store.PayStandingOrderRun(run, &t.TransferRequest{
+		FromAccount: int(o.Account),
+		ToAccount:   int(o.ToAccount),
+		Amount:      o.Amount,
+		Date:        now,
+	})
+
+	if errors.Is(err, storage.ErrStandingOrderRunPaid) {
+		o.Succeeded(now)
+		s.save(o, nil)
+		return
+	}
+
+	if err != nil {
+		s.failed(o, now, err)
+		return
+	}
+
+	o.Succeeded(now)
+	s.save(o, nil)
+
+	s.notify(o, "Standing order paid", fmt.Sprintf("%s %s was sent to account %d.", o.Amount, o.Amount.Currency, o.ToAccount))
+}
+
+func (s *Scheduler) failed(o *t.StandingOrder, now time.Time, err error) {
+
+	// only domain errors are meant for the customer's eyes
+	reason := "internal error"
+	if e, ok := t.AsError(err); ok {
+		reason = e.Message
+	} else {
+		log.Printf("standing order %s: %v", o.Id, err)
+	}
+
+	run := &t.StandingOrderRun{
+		Id:        uuid.New(),
+		OrderId:   o.Id,
+		Run:       o.RunsDone,
+		Attempt:   o.Attempts + 1,
+		Error:     reason,
+		CreatedAt: now,
+	}
+
+	gaveUp := o.Failed(now, reason)
+	s.save(o, run)
+
+	if gaveUp {
+		s.notify(o, "Standing order failed", fmt.Sprintf("%s %s to account %d could not be sent after %d attempts: %s.",
+			o.Amount, o.Amount.Currency, o.ToAccount, t.MaxStandingOrderAttempts, reason))
+	}
+}
+
+// save stores o and releases its lease, and adds run unless it is nil
+// because PayStandingOrderRun stored it already. If the owner changed the
+// order meanwhile, their change is kept and the save refused; a paid run
+// is stored with its transfer, so the order catches up once the lease is
+// over.
+func (s *Scheduler) save(o *t.StandingOrder, run *t.StandingOrderRun) {
+
+	o.LockedUntil = nil
+	if err := s.store.UpdateStandingOrder(o); err != nil {
+		log.Printf("standing order %s: %v", o.Id, err)
+	}
+
+	if run == nil {
+		return
+	}
+
+	if err := s.store.AddStandingOrderRun(run); err != nil {
+		log.Printf("standing order %s: %v", o.Id, err)
+	}
+}
+
+func (s *Scheduler) notify(o *t.StandingOrder, subject, message string) {
+
+	owner, err := s.store.GetAccountByAccountNumber(o.Account)
+	if err != nil {
+		log.Printf("standing order %s: %v", o.Id, err)
+		return
+	}
+
+	if err := s.notifier.Notify(owner, subject, message); err != nil {
+		log.Printf("standing order %s: notify: %v", o.Id, err)
+	}
+}
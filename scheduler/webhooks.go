@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mrkhay/gobank/storage"
+	t "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+)
+
+// WebhookDispatcher turns outbox events into deliveries and sends the
+// deliveries that are due, retrying failed ones with backoff. Customers'
+// endpoints are sent with a client that only reaches public addresses;
+// the bank's own endpoints may be on its internal network.
+type WebhookDispatcher struct {
+	store    storage.Storage
+	client   *http.Client
+	tenant   *http.Client
+	interval time.Duration
+	lease    time.Duration
+	batch    int
+}
+
+// webhookTimeout bounds a single delivery and webhookBatch is how many
+// deliveries are claimed at once. They are sent one after another, so the
+// lease on a batch has to outlast all of them timing out, or another
+// dispatcher would claim and send the rest a second time.
+const (
+	webhookTimeout = 10 * time.Second
+	webhookBatch   = 20
+)
+
+// NewWebhookDispatcher returns a dispatcher for store. A non-nil client is
+// used for every endpoint, which is meant for tests.
+func NewWebhookDispatcher(store storage.Storage, client *http.Client) *WebhookDispatcher {
+
+	tenant := client
+	if client == nil {
+		client = utility.NewWebhookClient(webhookTimeout, false)
+		tenant = utility.NewWebhookClient(webhookTimeout, true)
+	}
+
+	return &WebhookDispatcher{
+		store:    store,
+		client:   client,
+		tenant:   tenant,
+		interval: utility.GetEnvDuration("WEBHOOK_INTERVAL", 10*time.Second),
+		lease:    webhookBatch*webhookTimeout + time.Minute,
+		batch:    webhookBatch,
+	}
+}
+
+// Run dispatches and delivers every interval until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.RunDue(time.Now().UTC()); err != nil {
+			log.Println("webhooks:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue fans out all pending outbox events and sends every delivery that
+// is due at now.
+func (d *WebhookDispatcher) RunDue(now time.Time) error {
+
+	for {
+		n, err := d.store.DispatchOutboxEvents(now, d.batch)
+		if err != nil {
+			return err
+		}
+		if n < d.batch {
+			break
+		}
+	}
+
+	// each batch is leased from when it is claimed, not from when the run
+	// started
+	start := time.Now()
+	for {
+		deliveries, err := d.store.ClaimDueWebhookDeliveries(now.Add(time.Since(start)), d.lease, d.batch)
+		if err != nil {
+			return err
+		}
+
+		for _, delivery := range deliveries {
+			d.deliver(delivery, now)
+		}
+
+		if len(deliveries) < d.batch {
+			return nil
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver(delivery *t.WebhookDelivery, now time.Time) {
+
+	status, err := d.send(delivery)
+	if err != nil {
+		delivery.Failed(now, status, err.Error())
+	} else {
+		delivery.Delivered(now, status)
+	}
+
+	delivery.LockedUntil = nil
+	if err := d.store.UpdateWebhookDelivery(delivery); err != nil {
+		log.Printf("webhook delivery %s: %v", delivery.Id, err)
+	}
+}
+
+// send posts the event to the endpoint. It is signed as it leaves, since
+// receivers reject signatures older than a few minutes and a batch can
+// take a while to get through.
+func (d *WebhookDispatcher) send(delivery *t.WebhookDelivery) (int, error) {
+
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.Endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gobank-Event", delivery.Event.Type)
+	req.Header.Set("X-Gobank-Delivery", delivery.Id.String())
+	req.Header.Set(utility.WebhookSignatureHeader, utility.SignWebhook(delivery.Endpoint.Secret, time.Now().UTC(), body))
+
+	client := d.client
+	if delivery.Endpoint.Account == 0 {
+		client = d.tenant
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) SetAccountStatus(id int, status, reason string, now time.Time) (*t.Account, error) {
+
+	if status == t.AccountClosed {
+		return s.CloseAccount(id, &t.CloseAccountRequest{Reason: reason}, now)
+	}
+
+	var acc *t.Account
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		number, err := accountNumberOf(tx, id)
+		if err != nil {
+			return err
+		}
+
+		accounts, err := lockCustomerAccounts(tx, number)
+		if err != nil {
+			return err
+		}
+		acc = accounts[0]
+
+		if err := acc.SetStatus(status, reason, now); err != nil {
+			return err
+		}
+
+		return saveAccountStatus(tx, acc, t.EventAccountStatus)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetAccountByID(id)
+}
+
+func (s *PostgresStorage) CloseAccount(id int, req *t.CloseAccountRequest, now time.Time) (*t.Account, error) {
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		number, err := accountNumberOf(tx, id)
+		if err != nil {
+			return err
+		}
+
+		numbers := []int{number}
+		if req.PayoutAccount != 0 {
+			numbers = append(numbers, int(req.PayoutAccount))
+		}
+
+		accounts, err := lockCustomerAccounts(tx, numbers...)
+		if err != nil {
+			return err
+		}
+
+		var payout *t.Account
+		if len(accounts) > 1 {
+			payout = accounts[1]
+		}
+
+		acc := accounts[0]
+		transactions, entries, err := closurePayouts(acc, payout)
+		if err != nil {
+			return err
+		}
+
+		for i, tran := range transactions {
+			if err := addTransaction(tx, tran); err != nil {
+				return err
+			}
+
+			if err := postJournalEntry(tx, entries[i]); err != nil {
+				return err
+			}
+
+			event, err := t.NewTransactionEvent(t.EventTransferCompleted, tran)
+			if err != nil {
+				return err
+			}
+
+			if err := addOutboxEvent(tx, event); err != nil {
+				return err
+			}
+		}
+
+		if err := acc.SetStatus(t.AccountClosed, req.Reason, now); err != nil {
+			return err
+		}
+
+		return saveAccountStatus(tx, acc, t.EventAccountClosed)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetAccountByID(id)
+}
+
+// MarkDormantAccounts marks active accounts that were opened before
+// inactiveSince and have had no transactions since then as dormant.
+func (s *PostgresStorage) MarkDormantAccounts(inactiveSince, now time.Time) ([]*t.Account, error) {
+
+	dormant := []*t.Account{}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		rows, err := tx.Query(`UPDATE accounts AS a SET status = $1, status_reason = $2, status_changed_at = $3,
+		version = version + 1
+		WHERE a.kind = $4 AND a.status = $5 AND a.created_at < $6 AND NOT EXISTS (
+			SELECT 1 FROM transactions tr
+			WHERE (tr.sen_acc = a.acc_number OR tr.rec_acc = a.acc_number) AND tr.date >= $6
+		) RETURNING acc_number`,
+			t.AccountDormant, dormancyReason(inactiveSince), now, t.AccountKindCustomer, t.AccountActive, inactiveSince)
+		if err != nil {
+			return err
+		}
+
+		numbers := []int{}
+		for rows.Next() {
+			var number int
+			if err := rows.Scan(&number); err != nil {
+				rows.Close()
+				return err
+			}
+			numbers = append(numbers, number)
+		}
+
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		for _, number := range numbers {
+			acc, err := readCustomerAccount(tx, number)
+			if err != nil {
+				return err
+			}
+
+			event, err := t.NewAccountEvent(t.EventAccountStatus, acc, now)
+			if err != nil {
+				return err
+			}
+
+			if err := addOutboxEvent(tx, event); err != nil {
+				return err
+			}
+
+			dormant = append(dormant, acc)
+		}
+
+		return nil
+	})
+
+	return dormant, err
+}
+
+func accountNumberOf(tx *sql.Tx, id int) (int, error) {
+
+	var number int
+	err := tx.QueryRow(`SELECT acc_number FROM accounts WHERE id = $1 AND kind = $2`, id, t.AccountKindCustomer).Scan(&number)
+	if err == sql.ErrNoRows {
+		return 0, t.NotFound("account_not_found", "account %d not found", id)
+	}
+
+	return number, err
+}
+
+// saveAccountStatus writes the status of acc along with the event
+// announcing it.
+func saveAccountStatus(tx *sql.Tx, acc *t.Account, eventType string) error {
+
+	_, err := tx.Exec(`UPDATE accounts SET status = $1, status_reason = $2, status_changed_at = $3, version = version + 1
+	WHERE id = $4`, acc.Status, acc.StatusReason, acc.StatusChangedAt, acc.ID)
+	if err != nil {
+		return err
+	}
+
+	event, err := t.NewAccountEvent(eventType, acc, *acc.StatusChangedAt)
+	if err != nil {
+		return err
+	}
+
+	return addOutboxEvent(tx, event)
+}
+
+func (s *MemoryStorage) SetAccountStatus(id int, status, reason string, now time.Time) (*t.Account, error) {
+
+	if status == t.AccountClosed {
+		return s.CloseAccount(id, &t.CloseAccountRequest{Reason: reason}, now)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.customerByID(id)
+	if stored == nil {
+		return nil, t.NotFound("account_not_found", "account %d not found", id)
+	}
+
+	acc := s.copyAccount(stored)
+	if err := acc.SetStatus(status, reason, now); err != nil {
+		return nil, err
+	}
+
+	if err := s.saveAccountStatus(stored, acc, t.EventAccountStatus); err != nil {
+		return nil, err
+	}
+
+	return s.copyAccount(stored), nil
+}
+
+func (s *MemoryStorage) CloseAccount(id int, req *t.CloseAccountRequest, now time.Time) (*t.Account, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.customerByID(id)
+	if stored == nil {
+		return nil, t.NotFound("account_not_found", "account %d not found", id)
+	}
+
+	var payout *t.Account
+	if req.PayoutAccount != 0 {
+		to := s.customerByNumber(req.PayoutAccount)
+		if to == nil {
+			return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", req.PayoutAccount)
+		}
+		payout = s.copyAccount(to)
+	}
+
+	acc := s.copyAccount(stored)
+	transactions, entries, err := closurePayouts(acc, payout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := acc.SetStatus(t.AccountClosed, req.Reason, now); err != nil {
+		return nil, err
+	}
+
+	events := []*t.OutboxEvent{}
+	for _, tran := range transactions {
+		event, err := t.NewTransactionEvent(t.EventTransferCompleted, tran)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	for i, tran := range transactions {
+		s.transactions = append(s.transactions, tran)
+		s.post(entries[i])
+		s.addOutboxEvent(events[i])
+	}
+
+	if err := s.saveAccountStatus(stored, acc, t.EventAccountClosed); err != nil {
+		return nil, err
+	}
+
+	return s.copyAccount(stored), nil
+}
+
+func (s *MemoryStorage) MarkDormantAccounts(inactiveSince, now time.Time) ([]*t.Account, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := map[int64]bool{}
+	for _, tran := range s.transactions {
+		if !tran.Date.Before(inactiveSince) {
+			active[tran.Sen_acc.AccountNumber] = true
+			active[tran.Rec_acc.AccountNumber] = true
+		}
+	}
+
+	dormant := []*t.Account{}
+	for _, stored := range s.accounts {
+		if stored.Kind != t.AccountKindCustomer || stored.Status != t.AccountActive ||
+			!stored.CreatedAt.Before(inactiveSince) || active[stored.AccountNumber] {
+			continue
+		}
+
+		acc := s.copyAccount(stored)
+		if err := acc.SetStatus(t.AccountDormant, dormancyReason(inactiveSince), now); err != nil {
+			return nil, err
+		}
+
+		if err := s.saveAccountStatus(stored, acc, t.EventAccountStatus); err != nil {
+			return nil, err
+		}
+
+		dormant = append(dormant, s.copyAccount(stored))
+	}
+
+	sort.Slice(dormant, func(i, j int) bool { return dormant[i].ID < dormant[j].ID })
+	return dormant, nil
+}
+
+// saveAccountStatus copies the status of acc onto stored and adds the
+// event announcing it. It must be called with s.mu held.
+func (s *MemoryStorage) saveAccountStatus(stored, acc *t.Account, eventType string) error {
+
+	event, err := t.NewAccountEvent(eventType, acc, *acc.StatusChangedAt)
+	if err != nil {
+		return err
+	}
+
+	stored.Status = acc.Status
+	stored.StatusReason = acc.StatusReason
+	stored.StatusChangedAt = acc.StatusChangedAt
+	stored.Version++
+	s.addOutboxEvent(event)
+
+	return nil
+}
+
+// closurePayouts checks that acc can be closed and builds the transfers
+// paying whatever is left on it out to payout, one for each currency. An
+// account with funds left cannot be closed without a payout account.
+func closurePayouts(acc, payout *t.Account) ([]*t.Transcation, []*t.JournalEntry, error) {
+
+	if acc.Status == t.AccountClosed {
+		return nil, nil, t.Conflict("account_closed", "account %d is already closed", acc.AccountNumber)
+	}
+
+	for _, held := range acc.Held {
+		if !held.IsZero() {
+			return nil, nil, t.Conflict("active_holds", "account %d has funds on hold, wait for them to be captured or released", acc.AccountNumber)
+		}
+	}
+
+	if payout != nil && payout.AccountNumber == acc.AccountNumber {
+		return nil, nil, t.Validation("invalid_payout_account", "payout_account must be another account")
+	}
+
+	// closing is the holder's own instruction, so it is not held back by
+	// dormancy the way other debits are
+	from := *acc
+	if from.Status == t.AccountDormant {
+		from.Status = t.AccountActive
+	}
+
+	transactions := []*t.Transcation{}
+	entries := []*t.JournalEntry{}
+	for _, balance := range acc.Balances {
+		if balance.IsZero() {
+			continue
+		}
+
+		if payout == nil {
+			return nil, nil, t.Conflict("balance_not_zero", "account %d still holds %s %s, give a payout_account to close it",
+				acc.AccountNumber, balance, balance.Currency)
+		}
+
+		req := &t.TransferRequest{
+			FromAccount: int(acc.AccountNumber),
+			ToAccount:   int(payout.AccountNumber),
+			Amount:      balance,
+			ToCurrency:  balance.Currency,
+		}
+
+		// payouts keep their currency, so no rate is ever looked up
+		tran, entry, err := newTransfer(req, t.DescriptionClosure, &from, payout, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transactions = append(transactions, tran)
+		entries = append(entries, entry)
+	}
+
+	return transactions, entries, nil
+}
+
+func dormancyReason(inactiveSince time.Time) string {
+	return fmt.Sprintf("no activity since %s", inactiveSince.Format("2006-01-02"))
+}
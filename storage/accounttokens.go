@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) CreateAccountToken(tok *t.AccountToken) error {
+
+	return s.inTx(func(tx *sql.Tx) error {
+
+		if _, err := tx.Exec(`UPDATE account_tokens SET used_at = $1
+		WHERE acc_number = $2 AND purpose = $3 AND used_at IS NULL`, tok.CreatedAt, tok.AccountNumber, tok.Purpose); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`INSERT INTO account_tokens (id, acc_number, purpose, email, token_hash, created_at, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+			tok.Id, tok.AccountNumber, tok.Purpose, tok.Email, tok.TokenHash, tok.CreatedAt, tok.ExpiresAt)
+		return err
+	})
+}
+
+func (s *PostgresStorage) UseAccountToken(hash, purpose string, now time.Time) (*t.AccountToken, error) {
+
+	tok := &t.AccountToken{}
+	err := s.db.QueryRow(`UPDATE account_tokens SET used_at = $1
+	WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+	RETURNING id, acc_number, purpose, email, token_hash, created_at, expires_at, used_at`, now, hash, purpose).Scan(
+		&tok.Id, &tok.AccountNumber, &tok.Purpose, &tok.Email, &tok.TokenHash, &tok.CreatedAt, &tok.ExpiresAt, &tok.UsedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountTokenInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+func (s *PostgresStorage) MarkEmailVerified(account int64, email string, now time.Time) error {
+
+	res, err := s.db.Exec(`UPDATE accounts SET email_verified_at = $1, version = version + 1
+	WHERE acc_number = $2 AND email = $3 AND kind = $4`, now, account, email, t.AccountKindCustomer)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrEmailChanged
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) SetPassword(account int64, encryptedPassword string, now time.Time) error {
+
+	return s.inTx(func(tx *sql.Tx) error {
+
+		res, err := tx.Exec(`UPDATE accounts SET password = $1, password_changed_at = $2, version = version + 1
+	WHERE acc_number = $3 AND kind = $4`, encryptedPassword, now, account, t.AccountKindCustomer)
+		if err != nil {
+			return err
+		}
+
+		if n, _ := res.RowsAffected(); n < 1 {
+			return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", account)
+		}
+
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = $1 WHERE acc_number = $2 AND revoked_at IS NULL`, now, account)
+		return err
+	})
+}
+
+func (s *MemoryStorage) CreateAccountToken(tok *t.AccountToken) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, old := range s.accountTokens {
+		if old.AccountNumber == tok.AccountNumber && old.Purpose == tok.Purpose && old.UsedAt == nil {
+			usedAt := tok.CreatedAt
+			old.UsedAt = &usedAt
+		}
+	}
+
+	c := *tok
+	s.accountTokens[tok.TokenHash] = &c
+	return nil
+}
+
+func (s *MemoryStorage) UseAccountToken(hash, purpose string, now time.Time) (*t.AccountToken, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tok, ok := s.accountTokens[hash]
+	if !ok || !tok.Valid(purpose, now) {
+		return nil, ErrAccountTokenInvalid
+	}
+
+	usedAt := now
+	tok.UsedAt = &usedAt
+
+	c := *tok
+	return &c, nil
+}
+
+func (s *MemoryStorage) MarkEmailVerified(account int64, email string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.customerByNumber(account)
+	if acc == nil || acc.Email != email {
+		return ErrEmailChanged
+	}
+
+	verifiedAt := now
+	acc.EmailVerifiedAt = &verifiedAt
+	acc.Version++
+	return nil
+}
+
+func (s *MemoryStorage) SetPassword(account int64, encryptedPassword string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.customerByNumber(account)
+	if acc == nil {
+		return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", account)
+	}
+
+	changedAt := now
+	acc.EncryptedPassword = encryptedPassword
+	acc.PasswordChangedAt = &changedAt
+	acc.Version++
+
+	for _, rt := range s.refreshTokens {
+		if rt.AccountNumber == account && rt.RevokedAt == nil {
+			revokedAt := now
+			rt.RevokedAt = &revokedAt
+		}
+	}
+
+	return nil
+}
+
+// ErrEmailChanged turns away a token sent to an email the account no
+// longer has.
+var ErrEmailChanged = t.Conflict("email_changed", "the email of the account has changed since the token was sent, request a new one")
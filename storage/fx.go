@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) SetFXRate(rate *t.FXRate) error {
+
+	_, err := s.db.Exec(`INSERT INTO fx_rates (base, quote, rate, spread_bps, updated_at) VALUES ($1,$2,$3,$4,$5)
+	ON CONFLICT (base, quote) DO UPDATE SET rate = EXCLUDED.rate, spread_bps = EXCLUDED.spread_bps, updated_at = EXCLUDED.updated_at`,
+		rate.Base, rate.Quote, rate.Rate, rate.Spread, rate.UpdatedAt)
+
+	return err
+}
+
+func (s *PostgresStorage) GetFXRates() ([]*t.FXRate, error) {
+
+	rows, err := s.db.Query(`SELECT base, quote, rate, spread_bps, updated_at FROM fx_rates ORDER BY base, quote`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*t.FXRate{}
+	for rows.Next() {
+		rate, err := scanFXRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (s *PostgresStorage) GetFXRate(base, quote string) (*t.FXRate, error) {
+	return readFXRate(s.db, base, quote)
+}
+
+func (s *PostgresStorage) DeleteFXRate(base, quote string) error {
+
+	res, err := s.db.Exec(`DELETE FROM fx_rates WHERE base = $1 AND quote = $2`, base, quote)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return fxRateNotFound(base, quote)
+	}
+
+	return nil
+}
+
+func readFXRate(db execer, base, quote string) (*t.FXRate, error) {
+
+	rows, err := db.Query(`SELECT base, quote, rate, spread_bps, updated_at FROM fx_rates WHERE base = $1 AND quote = $2`,
+		base, quote)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanFXRate(rows)
+	}
+
+	return nil, fxRateNotFound(base, quote)
+}
+
+func scanFXRate(rows *sql.Rows) (*t.FXRate, error) {
+
+	rate := new(t.FXRate)
+	if err := rows.Scan(&rate.Base, &rate.Quote, &rate.Rate, &rate.Spread, &rate.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	rate.Rate = trimDecimal(rate.Rate)
+	return rate, nil
+}
+
+// loadBalances fills in the sub-balances of acc from its postings, and its
+// available balance from its holds.
+func loadBalances(db execer, acc *t.Account) error {
+
+	rows, err := db.Query(`SELECT currency, sum(amount)::bigint FROM postings WHERE acc_number = $1 GROUP BY currency`,
+		acc.AccountNumber)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sums := map[string]int64{}
+	for rows.Next() {
+		var currency string
+		var sum int64
+		if err := rows.Scan(&currency, &sum); err != nil {
+			return err
+		}
+		sums[currency] = sum
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	acc.Balances = subBalances(acc.Balance.Currency, sums)
+	return loadHeld(db, acc)
+}
+
+// trimDecimal drops the trailing zeros Postgres pads numeric columns with.
+func trimDecimal(v string) string {
+
+	if !strings.Contains(v, ".") {
+		return v
+	}
+
+	return strings.TrimSuffix(strings.TrimRight(v, "0"), ".")
+}
+
+func (s *MemoryStorage) SetFXRate(rate *t.FXRate) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *rate
+	s.fxRates[rate.Base+"/"+rate.Quote] = &c
+	return nil
+}
+
+func (s *MemoryStorage) GetFXRates() ([]*t.FXRate, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rates := []*t.FXRate{}
+	for _, rate := range s.fxRates {
+		c := *rate
+		rates = append(rates, &c)
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Base+rates[i].Quote < rates[j].Base+rates[j].Quote
+	})
+
+	return rates, nil
+}
+
+func (s *MemoryStorage) GetFXRate(base, quote string) (*t.FXRate, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.fxRate(base, quote)
+}
+
+func (s *MemoryStorage) DeleteFXRate(base, quote string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.fxRates[base+"/"+quote]; !ok {
+		return fxRateNotFound(base, quote)
+	}
+
+	delete(s.fxRates, base+"/"+quote)
+	return nil
+}
+
+// fxRate must be called with s.mu held.
+func (s *MemoryStorage) fxRate(base, quote string) (*t.FXRate, error) {
+
+	rate, ok := s.fxRates[base+"/"+quote]
+	if !ok {
+		return nil, fxRateNotFound(base, quote)
+	}
+
+	c := *rate
+	return &c, nil
+}
+
+func fxRateNotFound(base, quote string) error {
+	return t.NotFound("fx_rate_not_found", "no exchange rate from %s to %s", base, quote)
+}
+
+// subBalances lists a balance for every currency in sums, the account's
+// own currency first and the others in alphabetical order.
+func subBalances(own string, sums map[string]int64) []t.Money {
+
+	balances := []t.Money{t.NewMoney(sums[own], own)}
+
+	others := []string{}
+	for currency := range sums {
+		if currency != own {
+			others = append(others, currency)
+		}
+	}
+	sort.Strings(others)
+
+	for _, currency := range others {
+		balances = append(balances, t.NewMoney(sums[currency], currency))
+	}
+
+	return balances
+}
+
+// newTransfer checks req against the current state of both accounts and
+// builds the transaction and its journal entry. rate looks up the exchange
+// rate when the receiver is credited in another currency.
+func newTransfer(req *t.TransferRequest, description string, from, to *t.Account, rate func(base, quote string) (*t.FXRate, error)) (*t.Transcation, *t.JournalEntry, error) {
+
+	if err := from.CanDebit(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := to.CanCredit(); err != nil {
+		return nil, nil, err
+	}
+
+	credit := req.ToCurrency
+	if credit == "" {
+		credit = to.Balance.Currency
+	}
+
+	if _, err := t.CurrencyExponent(credit); err != nil {
+		return nil, nil, t.Validation("invalid_currency", "%v", err)
+	}
+
+	// moving money to the same account only makes sense as a conversion
+	// between two of its sub-balances
+	if from.AccountNumber == to.AccountNumber && credit == req.Amount.Currency {
+		return nil, nil, t.Validation("self_transfer", "cannot transfer to the same account without converting to another currency")
+	}
+
+	if from.AvailableIn(req.Amount.Currency).Amount < req.Amount.Amount {
+		return nil, nil, t.InsufficientFunds("insufficient funds")
+	}
+
+	transaction, err := t.NewTransaction(&req.FromAccount, &req.ToAccount, req.Amount, "Credit", description)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if credit == req.Amount.Currency {
+		entry, err := t.NewTransferEntry(t.EntryKindTransfer, transaction.Description, &transaction.Id,
+			from.AccountNumber, to.AccountNumber, req.Amount)
+		return transaction, entry, err
+	}
+
+	fx, err := rate(req.Amount.Currency, credit)
+	if t.KindOf(err) == t.KindNotFound {
+		return nil, nil, t.Validation("unsupported_conversion", "%s cannot be converted to %s", req.Amount.Currency, credit)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	converted, err := fx.Convert(req.Amount, transaction.Date)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied, err := fx.AppliedRate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transaction.Converted = &converted
+	transaction.FXRate = applied
+
+	entry, err := t.NewConversionEntry(t.EntryKindTransfer, transaction.Description, &transaction.Id,
+		from.AccountNumber, to.AccountNumber, req.Amount, converted)
+	return transaction, entry, err
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+const holdColumns = `id, acc_number, to_acc_number, amount, currency, captured_amount, transaction_id,
+	description, status, expires_at, created_at, updated_at`
+
+func (s *PostgresStorage) CreateHold(h *t.Hold) error {
+
+	return s.inTx(func(tx *sql.Tx) error {
+
+		// the account stays locked until the hold is written, so a
+		// concurrent transfer or hold cannot spend the same funds
+		accounts, err := lockCustomerAccounts(tx, int(h.Account), int(h.ToAccount))
+		if err != nil {
+			return err
+		}
+
+		if err := checkHold(accounts[0], accounts[1], h); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO holds (`+holdColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`,
+			h.Id, h.Account, h.ToAccount, h.Amount.Amount, h.Amount.Currency, nil, nil,
+			h.Description, h.Status, h.ExpiresAt, h.CreatedAt, h.UpdatedAt)
+		return err
+	})
+}
+
+func (s *PostgresStorage) GetHold(id uuid.UUID) (*t.Hold, error) {
+	return readHold(s.db, id, false)
+}
+
+func (s *PostgresStorage) GetHolds(account int64) ([]*t.Hold, error) {
+
+	rows, err := s.db.Query(`SELECT `+holdColumns+` FROM holds WHERE acc_number = $1 OR to_acc_number = $1
+	ORDER BY created_at`, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanHolds(rows)
+}
+
+func (s *PostgresStorage) CaptureHold(id uuid.UUID, amount *t.Money, now time.Time) (*t.Hold, *t.Transcation, error) {
+
+	var hold *t.Hold
+	var transaction *t.Transcation
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		var err error
+		hold, err = readHold(tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		captured, err := hold.Capture(amount, now)
+		if err != nil {
+			return err
+		}
+
+		// the hold is updated before the accounts are read, so the funds
+		// it held count as available to the transfer that captures them
+		if err := updateHold(tx, hold); err != nil {
+			return err
+		}
+
+		accounts, err := lockCustomerAccounts(tx, int(hold.Account), int(hold.ToAccount))
+		if err != nil {
+			return err
+		}
+
+		req := holdTransfer(hold, captured)
+
+		var entry *t.JournalEntry
+		transaction, entry, err = newTransfer(req, hold.Description, accounts[0], accounts[1], func(base, quote string) (*t.FXRate, error) {
+			return readFXRate(tx, base, quote)
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := addTransaction(tx, transaction); err != nil {
+			return err
+		}
+
+		hold.TransactionId = &transaction.Id
+		if err := updateHold(tx, hold); err != nil {
+			return err
+		}
+
+		if err := postJournalEntry(tx, entry); err != nil {
+			return err
+		}
+
+		event, err := t.NewTransactionEvent(t.EventTransferCompleted, transaction)
+		if err != nil {
+			return err
+		}
+
+		return addOutboxEvent(tx, event)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transaction, err = readTransaction(s.db, transaction.Id)
+	return hold, transaction, err
+}
+
+func (s *PostgresStorage) ReleaseHold(id uuid.UUID, now time.Time) (*t.Hold, error) {
+
+	var hold *t.Hold
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		var err error
+		hold, err = readHold(tx, id, true)
+		if err != nil {
+			return err
+		}
+
+		if err := hold.Release(now); err != nil {
+			return err
+		}
+
+		return updateHold(tx, hold)
+	})
+
+	return hold, err
+}
+
+func (s *PostgresStorage) ExpireHolds(now time.Time) (int, error) {
+
+	res, err := s.db.Exec(`UPDATE holds SET status = $1, updated_at = $2 WHERE status = $3 AND expires_at <= $2`,
+		t.HoldExpired, now, t.HoldActive)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func readHold(db execer, id uuid.UUID, lock bool) (*t.Hold, error) {
+
+	query := `SELECT ` + holdColumns + ` FROM holds WHERE id = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := scanHolds(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holds) == 0 {
+		return nil, holdNotFound(id)
+	}
+
+	return holds[0], nil
+}
+
+func updateHold(db execer, h *t.Hold) error {
+
+	var captured sql.NullInt64
+	if h.Captured != nil {
+		captured = sql.NullInt64{Int64: h.Captured.Amount, Valid: true}
+	}
+
+	_, err := db.Exec(`UPDATE holds SET status = $1, captured_amount = $2, transaction_id = $3, updated_at = $4
+	WHERE id = $5`, h.Status, captured, h.TransactionId, h.UpdatedAt, h.Id)
+
+	return err
+}
+
+func scanHolds(rows *sql.Rows) ([]*t.Hold, error) {
+
+	defer rows.Close()
+
+	holds := []*t.Hold{}
+	for rows.Next() {
+		h := &t.Hold{}
+		var captured sql.NullInt64
+		err := rows.Scan(
+			&h.Id, &h.Account, &h.ToAccount, &h.Amount.Amount, &h.Amount.Currency, &captured, &h.TransactionId,
+			&h.Description, &h.Status, &h.ExpiresAt, &h.CreatedAt, &h.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if captured.Valid {
+			c := t.NewMoney(captured.Int64, h.Amount.Currency)
+			h.Captured = &c
+		}
+		holds = append(holds, h)
+	}
+
+	return holds, rows.Err()
+}
+
+// loadHeld fills in the funds held on acc by its active holds.
+func loadHeld(db execer, acc *t.Account) error {
+
+	rows, err := db.Query(`SELECT currency, sum(amount)::bigint FROM holds
+	WHERE acc_number = $1 AND status = $2 AND expires_at > $3 GROUP BY currency`,
+		acc.AccountNumber, t.HoldActive, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	held := map[string]int64{}
+	for rows.Next() {
+		var currency string
+		var sum int64
+		if err := rows.Scan(&currency, &sum); err != nil {
+			return err
+		}
+		held[currency] = sum
+	}
+
+	setHeld(acc, held)
+	return rows.Err()
+}
+
+func (s *MemoryStorage) CreateHold(h *t.Hold) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.customerByNumber(h.Account)
+	if acc == nil {
+		return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", h.Account)
+	}
+
+	to := s.customerByNumber(h.ToAccount)
+	if to == nil {
+		return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", h.ToAccount)
+	}
+
+	if err := checkHold(s.copyAccount(acc), to, h); err != nil {
+		return err
+	}
+
+	c := *h
+	s.holds[h.Id] = &c
+	return nil
+}
+
+func (s *MemoryStorage) GetHold(id uuid.UUID) (*t.Hold, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.holds[id]
+	if !ok {
+		return nil, holdNotFound(id)
+	}
+
+	c := *h
+	return &c, nil
+}
+
+func (s *MemoryStorage) GetHolds(account int64) ([]*t.Hold, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	holds := []*t.Hold{}
+	for _, h := range s.holds {
+		if h.Account == account || h.ToAccount == account {
+			c := *h
+			holds = append(holds, &c)
+		}
+	}
+
+	sort.Slice(holds, func(i, j int) bool { return holds[i].CreatedAt.Before(holds[j].CreatedAt) })
+	return holds, nil
+}
+
+func (s *MemoryStorage) CaptureHold(id uuid.UUID, amount *t.Money, now time.Time) (*t.Hold, *t.Transcation, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.holds[id]
+	if !ok {
+		return nil, nil, holdNotFound(id)
+	}
+
+	hold := *stored
+	captured, err := hold.Capture(amount, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from := s.customerByNumber(hold.Account)
+	to := s.customerByNumber(hold.ToAccount)
+	if from == nil || to == nil {
+		return nil, nil, t.NotFound("account_not_found", "an account of hold %s no longer exists", id)
+	}
+
+	// swap in the captured hold before reading the accounts, so the funds
+	// it held count as available to the transfer that captures them
+	s.holds[id] = &hold
+	transaction, entry, err := newTransfer(holdTransfer(&hold, captured), hold.Description, s.copyAccount(from), s.copyAccount(to), s.fxRate)
+	if err != nil {
+		s.holds[id] = stored
+		return nil, nil, err
+	}
+
+	event, err := t.NewTransactionEvent(t.EventTransferCompleted, transaction)
+	if err != nil {
+		s.holds[id] = stored
+		return nil, nil, err
+	}
+
+	hold.TransactionId = &transaction.Id
+	s.transactions = append(s.transactions, transaction)
+	s.post(entry)
+	s.addOutboxEvent(event)
+
+	c := hold
+	return &c, s.viewTransaction(transaction), nil
+}
+
+func (s *MemoryStorage) ReleaseHold(id uuid.UUID, now time.Time) (*t.Hold, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.holds[id]
+	if !ok {
+		return nil, holdNotFound(id)
+	}
+
+	if err := h.Release(now); err != nil {
+		return nil, err
+	}
+
+	c := *h
+	return &c, nil
+}
+
+func (s *MemoryStorage) ExpireHolds(now time.Time) (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, h := range s.holds {
+		if h.Expire(now) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// held sums the active holds on an account by currency. It must be called
+// with s.mu held.
+func (s *MemoryStorage) held(number int64, now time.Time) map[string]int64 {
+
+	held := map[string]int64{}
+	for _, h := range s.holds {
+		if h.Account == number && h.Active(now) {
+			held[h.Amount.Currency] += h.Amount.Amount
+		}
+	}
+
+	return held
+}
+
+func holdNotFound(id uuid.UUID) error {
+	return t.NotFound("hold_not_found", "hold %s not found", id)
+}
+
+// setHeld records the funds held on acc and works out its available
+// balance from them.
+func setHeld(acc *t.Account, held map[string]int64) {
+	acc.Held = subBalances(acc.Balance.Currency, held)
+	available := acc.AvailableIn(acc.Balance.Currency)
+	acc.AvailableBalance = &available
+}
+
+// checkHold makes sure acc can spare the amount of a new hold in favour
+// of to.
+func checkHold(acc, to *t.Account, h *t.Hold) error {
+
+	if err := acc.CanDebit(); err != nil {
+		return err
+	}
+
+	if err := to.CanCredit(); err != nil {
+		return err
+	}
+
+	if acc.AvailableIn(h.Amount.Currency).Amount < h.Amount.Amount {
+		return t.InsufficientFunds("insufficient funds")
+	}
+
+	return nil
+}
+
+// holdTransfer is the transfer that captures amount of h.
+func holdTransfer(h *t.Hold, amount t.Money) *t.TransferRequest {
+	return &t.TransferRequest{
+		FromAccount: int(h.Account),
+		ToAccount:   int(h.ToAccount),
+		Amount:      amount,
+	}
+}
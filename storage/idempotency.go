@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) ReserveIdempotencyKey(rec *t.IdempotencyRecord) (*t.IdempotencyRecord, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE expires_at < $1`, rec.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO idempotency_keys (key, scope, fingerprint, created_at, expires_at)
+	VALUES ($1,$2,$3,$4,$5) ON CONFLICT (key, scope) DO NOTHING`,
+		rec.Key, rec.Scope, rec.Fingerprint, rec.CreatedAt, rec.ExpiresAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, tx.Commit()
+	}
+
+	existing := &t.IdempotencyRecord{Key: rec.Key, Scope: rec.Scope}
+	var status sql.NullInt64
+
+	err = tx.QueryRow(`SELECT fingerprint, status_code, response, created_at, expires_at
+	FROM idempotency_keys WHERE key = $1 AND scope = $2`, rec.Key, rec.Scope).Scan(
+		&existing.Fingerprint,
+		&status,
+		&existing.Response,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	existing.StatusCode = int(status.Int64)
+
+	return existing, tx.Commit()
+}
+
+func (s *PostgresStorage) CompleteIdempotencyKey(rec *t.IdempotencyRecord) error {
+
+	res, err := s.db.Exec(`UPDATE idempotency_keys SET status_code = $1, response = $2 WHERE key = $3 AND scope = $4`,
+		rec.StatusCode, rec.Response, rec.Key, rec.Scope)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return fmt.Errorf("idempotency key %s not found", rec.Key)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) ReleaseIdempotencyKey(key, scope string) error {
+
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND scope = $2 AND status_code IS NULL`, key, scope)
+	return err
+}
+
+func (s *MemoryStorage) ReserveIdempotencyKey(rec *t.IdempotencyRecord) (*t.IdempotencyRecord, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, existing := range s.idempotency {
+		if existing.ExpiresAt.Before(rec.CreatedAt) {
+			delete(s.idempotency, id)
+		}
+	}
+
+	id := idempotencyID(rec.Key, rec.Scope)
+	if existing, ok := s.idempotency[id]; ok {
+		c := *existing
+		return &c, nil
+	}
+
+	c := *rec
+	s.idempotency[id] = &c
+
+	return nil, nil
+}
+
+func (s *MemoryStorage) CompleteIdempotencyKey(rec *t.IdempotencyRecord) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.idempotency[idempotencyID(rec.Key, rec.Scope)]
+	if !ok {
+		return fmt.Errorf("idempotency key %s not found", rec.Key)
+	}
+
+	existing.StatusCode = rec.StatusCode
+	existing.Response = append([]byte(nil), rec.Response...)
+
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseIdempotencyKey(key, scope string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyID(key, scope)
+	if existing, ok := s.idempotency[id]; ok && !existing.Completed() {
+		delete(s.idempotency, id)
+	}
+
+	return nil
+}
+
+func idempotencyID(key, scope string) string {
+	return scope + "\x00" + key
+}
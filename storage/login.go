@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) AddLoginAttempt(a *t.LoginAttempt) error {
+
+	// the account is looked up here rather than by the caller, which only
+	// learns whether the email and password matched
+	return s.db.QueryRow(`INSERT INTO login_attempts (id, acc_number, email, ip, user_agent, result, created_at)
+	VALUES ($1, (SELECT acc_number FROM accounts WHERE lower(email) = lower($2) AND kind = $3), $2, $4, $5, $6, $7)
+	RETURNING acc_number`, a.Id, a.Email, t.AccountKindCustomer, a.IP, a.UserAgent, a.Result, a.CreatedAt).Scan(&a.AccountNumber)
+}
+
+func (s *PostgresStorage) GetLoginAttempts(account int64, limit int) ([]*t.LoginAttempt, error) {
+
+	rows, err := s.db.Query(`SELECT id, acc_number, email, ip, user_agent, result, created_at FROM login_attempts
+	WHERE acc_number = $1 ORDER BY created_at DESC LIMIT $2`, account, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*t.LoginAttempt{}
+	for rows.Next() {
+		a := &t.LoginAttempt{}
+		if err := rows.Scan(&a.Id, &a.AccountNumber, &a.Email, &a.IP, &a.UserAgent, &a.Result, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+func (s *PostgresStorage) GetLoginThrottle(key string) (*t.LoginThrottle, error) {
+	return readLoginThrottle(s.db, key, false)
+}
+
+func (s *PostgresStorage) RecordLoginFailure(key string, p t.ThrottlePolicy, now time.Time) (*t.LoginThrottle, error) {
+
+	throttle, _, err := s.changeLoginThrottle(key, func(l *t.LoginThrottle) bool {
+		l.Fail(now, p)
+		return true
+	})
+
+	return throttle, err
+}
+
+func (s *PostgresStorage) ReserveLoginAttempt(key string, p t.ThrottlePolicy, now time.Time) (*t.LoginThrottle, bool, error) {
+	return s.changeLoginThrottle(key, func(l *t.LoginThrottle) bool { return reserveLoginAttempt(l, p, now) })
+}
+
+func (s *PostgresStorage) RefundLoginAttempt(key string, p t.ThrottlePolicy) error {
+
+	_, _, err := s.changeLoginThrottle(key, func(l *t.LoginThrottle) bool {
+		l.Refund(p)
+		return true
+	})
+
+	return err
+}
+
+// changeLoginThrottle runs change on the counter of key with its row
+// locked, and saves it if change returns true.
+func (s *PostgresStorage) changeLoginThrottle(key string, change func(l *t.LoginThrottle) bool) (*t.LoginThrottle, bool, error) {
+
+	var throttle *t.LoginThrottle
+	var changed bool
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		if _, err := tx.Exec(`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 0, $2)
+		ON CONFLICT (key) DO NOTHING`, key, time.Time{}); err != nil {
+			return err
+		}
+
+		var err error
+		throttle, err = readLoginThrottle(tx, key, true)
+		if err != nil {
+			return err
+		}
+
+		if changed = change(throttle); !changed {
+			return nil
+		}
+
+		_, err = tx.Exec(`UPDATE login_throttles SET failures = $1, last_failure_at = $2, locked_until = $3 WHERE key = $4`,
+			throttle.Failures, throttle.LastFailureAt, throttle.LockedUntil, key)
+		return err
+	})
+
+	return throttle, changed, err
+}
+
+// reserveLoginAttempt counts an attempt on l unless it has to wait at now.
+func reserveLoginAttempt(l *t.LoginThrottle, p t.ThrottlePolicy, now time.Time) bool {
+
+	if l.RetryAfter(now, p) > 0 {
+		return false
+	}
+
+	l.Fail(now, p)
+	return true
+}
+
+func (s *PostgresStorage) ClearLoginThrottle(key string) error {
+
+	_, err := s.db.Exec(`DELETE FROM login_throttles WHERE key = $1`, key)
+	return err
+}
+
+func readLoginThrottle(db execer, key string, lock bool) (*t.LoginThrottle, error) {
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	l := &t.LoginThrottle{}
+	err := db.QueryRow(query, key).Scan(&l.Key, &l.Failures, &l.LastFailureAt, &l.LockedUntil)
+	if err == sql.ErrNoRows {
+		return &t.LoginThrottle{Key: key}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (s *MemoryStorage) AddLoginAttempt(a *t.LoginAttempt) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.AccountNumber = nil
+	if acc := s.accountByEmail(a.Email); acc != nil {
+		number := acc.AccountNumber
+		a.AccountNumber = &number
+	}
+
+	c := *a
+	s.loginAttempts = append(s.loginAttempts, &c)
+	return nil
+}
+
+func (s *MemoryStorage) GetLoginAttempts(account int64, limit int) ([]*t.LoginAttempt, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attempts := []*t.LoginAttempt{}
+	for _, a := range s.loginAttempts {
+		if a.AccountNumber != nil && *a.AccountNumber == account {
+			c := *a
+			attempts = append(attempts, &c)
+		}
+	}
+
+	sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].CreatedAt.After(attempts[j].CreatedAt) })
+	if len(attempts) > limit {
+		attempts = attempts[:limit]
+	}
+
+	return attempts, nil
+}
+
+func (s *MemoryStorage) GetLoginThrottle(key string) (*t.LoginThrottle, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.loginThrottles[key]
+	if !ok {
+		return &t.LoginThrottle{Key: key}, nil
+	}
+
+	c := *l
+	return &c, nil
+}
+
+func (s *MemoryStorage) RecordLoginFailure(key string, p t.ThrottlePolicy, now time.Time) (*t.LoginThrottle, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.loginThrottle(key)
+	l.Fail(now, p)
+
+	c := *l
+	return &c, nil
+}
+
+func (s *MemoryStorage) ReserveLoginAttempt(key string, p t.ThrottlePolicy, now time.Time) (*t.LoginThrottle, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.loginThrottle(key)
+	ok := reserveLoginAttempt(l, p, now)
+
+	c := *l
+	return &c, ok, nil
+}
+
+func (s *MemoryStorage) RefundLoginAttempt(key string, p t.ThrottlePolicy) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loginThrottle(key).Refund(p)
+	return nil
+}
+
+// loginThrottle returns the counter of key, adding it if there is none.
+// It must be called with s.mu held.
+func (s *MemoryStorage) loginThrottle(key string) *t.LoginThrottle {
+
+	l, ok := s.loginThrottles[key]
+	if !ok {
+		l = &t.LoginThrottle{Key: key}
+		s.loginThrottles[key] = l
+	}
+
+	return l
+}
+
+func (s *MemoryStorage) ClearLoginThrottle(key string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginThrottles, key)
+	return nil
+}
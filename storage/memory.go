@@ -0,0 +1,627 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+// MemoryStorage is a Storage kept entirely in process memory. It is meant
+// for tests and local development where no Postgres instance is available;
+// everything is lost when the process exits.
+type MemoryStorage struct {
+	mu           sync.RWMutex
+	nextID       int
+	accounts     map[int]*t.Account
+	transactions []*t.Transcation
+	entries      []*t.JournalEntry
+	idempotency  map[string]*t.IdempotencyRecord
+	numbers      t.AccountNumberGenerator
+
+	// refreshTokens is keyed by token hash, revokedTokens maps a revoked
+	// access token jti to its expiry
+	refreshTokens map[string]*t.RefreshToken
+	revokedTokens map[string]time.Time
+
+	standingOrders    map[uuid.UUID]*t.StandingOrder
+	standingOrderRuns []*t.StandingOrderRun
+
+	// outbox holds every event in commit order; dispatched marks the ones
+	// already turned into webhook deliveries
+	outbox            []*t.OutboxEvent
+	dispatched        map[uuid.UUID]bool
+	webhookEndpoints  map[uuid.UUID]*t.WebhookEndpoint
+	webhookDeliveries map[uuid.UUID]*t.WebhookDelivery
+
+	// fxRates is keyed by "BASE/QUOTE"
+	fxRates map[string]*t.FXRate
+
+	holds map[uuid.UUID]*t.Hold
+
+	// recoveryCodes maps an account to its recovery code hashes and
+	// whether each was used; mfaChallenges is keyed by token hash
+	mfa           map[int64]*t.MFA
+	recoveryCodes map[int64]map[string]bool
+	mfaChallenges map[string]*t.MFAChallenge
+
+	loginAttempts  []*t.LoginAttempt
+	loginThrottles map[string]*t.LoginThrottle
+
+	// accountTokens is keyed by token hash
+	accountTokens map[string]*t.AccountToken
+
+	// balances caches the sum of all postings per account and currency.
+	// It is only ever changed by post.
+	balances map[int64]map[string]int64
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	s := &MemoryStorage{
+		nextID:      1,
+		accounts:    map[int]*t.Account{},
+		balances:    map[int64]map[string]int64{},
+		idempotency: map[string]*t.IdempotencyRecord{},
+		numbers:     t.NewCounterGenerator(),
+
+		refreshTokens: map[string]*t.RefreshToken{},
+		revokedTokens: map[string]time.Time{},
+
+		standingOrders: map[uuid.UUID]*t.StandingOrder{},
+
+		dispatched:        map[uuid.UUID]bool{},
+		webhookEndpoints:  map[uuid.UUID]*t.WebhookEndpoint{},
+		webhookDeliveries: map[uuid.UUID]*t.WebhookDelivery{},
+
+		fxRates: map[string]*t.FXRate{},
+
+		holds: map[uuid.UUID]*t.Hold{},
+
+		mfa:           map[int64]*t.MFA{},
+		recoveryCodes: map[int64]map[string]bool{},
+		mfaChallenges: map[string]*t.MFAChallenge{},
+
+		loginThrottles: map[string]*t.LoginThrottle{},
+
+		accountTokens: map[string]*t.AccountToken{},
+	}
+
+	system := []struct {
+		number int64
+		name   string
+	}{
+		{t.SystemAccountTopUp, "Top up"},
+		{t.SystemAccountFees, "Fees"},
+		{t.SystemAccountSuspense, "Suspense"},
+		{t.SystemAccountFX, "FX"},
+	}
+
+	for _, acc := range system {
+		s.accounts[s.nextID] = &t.Account{
+			ID:            s.nextID,
+			FirstName:     "System",
+			LastName:      acc.name,
+			AccountNumber: acc.number,
+			Balance:       t.NewMoney(0, t.DefaultCurrency),
+			Kind:          t.AccountKindSystem,
+			Status:        t.AccountActive,
+			CreatedAt:     time.Now().UTC(),
+		}
+		s.nextID++
+	}
+
+	return s
+}
+
+// SetAccountNumberGenerator replaces the generator used by CreateAccount.
+func (s *MemoryStorage) SetAccountNumberGenerator(g t.AccountNumberGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numbers = g
+}
+
+func (s *MemoryStorage) Init() error {
+	return nil
+}
+
+func (s *MemoryStorage) CreateAccount(acc *t.Account) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	generated := acc.AccountNumber == 0
+	for attempt := 1; generated; attempt++ {
+		number, err := s.numbers.Next()
+		if err != nil {
+			return err
+		}
+
+		if s.accountByNumber(number) == nil {
+			acc.AccountNumber = number
+			break
+		}
+
+		if attempt == maxAccountNumberAttempts {
+			return fmt.Errorf("could not find a free account number")
+		}
+	}
+
+	if s.accountByNumber(acc.AccountNumber) != nil {
+		return t.Conflict("account_exists", "account with acc_number [ %d ] already exists", acc.AccountNumber)
+	}
+
+	if s.emailInUse(acc.Email, 0) {
+		return ErrEmailInUse
+	}
+
+	if err := acc.Balance.Validate(); err != nil {
+		return err
+	}
+
+	acc.ID = s.nextID
+	s.nextID++
+
+	stored := *acc
+	stored.Kind = t.AccountKindCustomer
+	stored.Version = 1
+	if stored.Role == "" {
+		stored.Role = t.RoleCustomer
+	}
+	stored.Balance = t.NewMoney(0, acc.Balance.Currency)
+	stored.Status = t.AccountActive
+	acc.Status = t.AccountActive
+
+	event, err := t.NewAccountEvent(t.EventAccountCreated, &stored, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	s.accounts[acc.ID] = &stored
+	s.addOutboxEvent(event)
+
+	return nil
+}
+
+func (s *MemoryStorage) UpdateAccount(acc *t.Account) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.customerByID(acc.ID)
+	if stored == nil {
+		return t.NotFound("account_not_found", "account %d not found", acc.ID)
+	}
+
+	if stored.Version != acc.Version {
+		return ErrVersionConflict
+	}
+
+	if !strings.EqualFold(stored.Email, acc.Email) {
+		if s.emailInUse(acc.Email, acc.ID) {
+			return ErrEmailInUse
+		}
+		stored.EmailVerifiedAt = nil
+	}
+
+	stored.FirstName = acc.FirstName
+	stored.LastName = acc.LastName
+	stored.Email = acc.Email
+	stored.Version++
+	acc.Version = stored.Version
+
+	return nil
+}
+
+func (s *MemoryStorage) SetAccountRole(id int, role string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.customerByID(id)
+	if stored == nil {
+		return t.NotFound("account_not_found", "account %d not found", id)
+	}
+
+	stored.Role = role
+	stored.Version++
+
+	return nil
+}
+
+func (s *MemoryStorage) GetAccounts() ([]*t.Account, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := []*t.Account{}
+	for _, acc := range s.accounts {
+		if acc.Kind == t.AccountKindCustomer {
+			accounts = append(accounts, s.copyAccount(acc))
+		}
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+
+	return accounts, nil
+}
+
+func (s *MemoryStorage) GetAccountByID(id int) (*t.Account, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acc := s.customerByID(id)
+	if acc == nil {
+		return nil, t.NotFound("account_not_found", "account %d not found", id)
+	}
+
+	return s.copyAccount(acc), nil
+}
+
+func (s *MemoryStorage) GetAccountByNumber(number int) (*int, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.accountByNumber(int64(number)) == nil {
+		return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", number)
+	}
+
+	acc_num := number
+	return &acc_num, nil
+}
+
+func (s *MemoryStorage) GetAccountByAccountNumber(number int64) (*t.Account, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acc := s.customerByNumber(number)
+	if acc == nil {
+		return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", number)
+	}
+
+	return s.copyAccount(acc), nil
+}
+
+func (s *MemoryStorage) GetAccountByEmail(email string) (*t.Account, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, acc := range s.accounts {
+		if acc.Kind == t.AccountKindCustomer && strings.ToLower(acc.Email) == email {
+			return s.copyAccount(acc), nil
+		}
+	}
+
+	return nil, t.NotFound("account_not_found", "account not found")
+}
+
+func (s *MemoryStorage) GetAccountByPasswordAndEmail(req *t.LoginRequest) (*t.Account, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acc := s.accountByEmail(req.Email)
+	if acc == nil {
+		return checkPassword(nil, req.Pasword)
+	}
+
+	return checkPassword(s.copyAccount(acc), req.Pasword)
+}
+
+func (s *MemoryStorage) CheckIfEmailExists(email string) (bool, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.emailInUse(email, 0), nil
+}
+
+func (s *MemoryStorage) HasAccountWithRole(role string) (bool, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, acc := range s.accounts {
+		if acc.Kind == t.AccountKindCustomer && acc.Role == role {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStorage) LegacyAccountNumbers() (int64, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var max int64
+	for _, acc := range s.accounts {
+		if acc.AccountNumber > max && acc.AccountNumber < t.MinAccountNumber {
+			max = acc.AccountNumber
+		}
+	}
+
+	return max, nil
+}
+
+// transactions
+
+func (s *MemoryStorage) Transfer(req *t.TransferRequest) (*t.Transcation, error) {
+
+	if err := checkAmount(req.Amount); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transfer(req)
+}
+
+// transfer makes the transfer of req; s.mu must be held.
+func (s *MemoryStorage) transfer(req *t.TransferRequest) (*t.Transcation, error) {
+
+	from := s.customerByNumber(int64(req.FromAccount))
+	if from == nil {
+		return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", req.FromAccount)
+	}
+
+	to := s.customerByNumber(int64(req.ToAccount))
+	if to == nil {
+		return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", req.ToAccount)
+	}
+
+	transaction, entry, err := newTransfer(req, "Bank Transfer", s.copyAccount(from), s.copyAccount(to), s.fxRate)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := t.NewTransactionEvent(t.EventTransferCompleted, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transaction, its journal entry and its event are written under
+	// the same lock, so readers never observe a half finished transfer
+	s.transactions = append(s.transactions, transaction)
+	s.post(entry)
+	s.addOutboxEvent(event)
+
+	return s.viewTransaction(transaction), nil
+}
+
+func (s *MemoryStorage) TopUpAccount(req *t.TopUpRequest) error {
+
+	if err := checkAmount(req.Amount); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc := s.customerByNumber(int64(req.Account))
+	if acc == nil {
+		return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", req.Account)
+	}
+
+	if err := acc.CanCredit(); err != nil {
+		return err
+	}
+
+	topUp := int(t.SystemAccountTopUp)
+	transaction, err := t.NewTransaction(&topUp, &req.Account, req.Amount, "Credit", "Top Up")
+	if err != nil {
+		return err
+	}
+
+	entry, err := t.NewTransferEntry(t.EntryKindTopUp, transaction.Description, &transaction.Id,
+		t.SystemAccountTopUp, acc.AccountNumber, req.Amount)
+	if err != nil {
+		return err
+	}
+
+	event, err := t.NewTransactionEvent(t.EventTopUpCompleted, transaction)
+	if err != nil {
+		return err
+	}
+
+	s.transactions = append(s.transactions, transaction)
+	s.post(entry)
+	s.addOutboxEvent(event)
+
+	return nil
+}
+
+func (s *MemoryStorage) GetUserTransactions(acc_num int) ([]*t.Transcation, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transactions := []*t.Transcation{}
+	for _, tran := range s.transactions {
+		if tran.Sen_acc.AccountNumber == int64(acc_num) || tran.Rec_acc.AccountNumber == int64(acc_num) {
+			transactions = append(transactions, s.viewTransaction(tran))
+		}
+	}
+
+	return transactions, nil
+}
+
+func (s *MemoryStorage) ListUserTransactions(acc_num int, filter *t.TransactionFilter) (*t.TransactionPage, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transactions := []*t.Transcation{}
+	for _, tran := range s.transactions {
+		if !filter.Match(int64(acc_num), tran) {
+			continue
+		}
+		if filter.After != nil && !filter.After.Before(tran) {
+			continue
+		}
+		transactions = append(transactions, tran)
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		c := t.TransactionCursor{Date: transactions[i].Date, Id: transactions[i].Id}
+		return c.Before(transactions[j])
+	})
+
+	limit := pageLimit(filter)
+	if len(transactions) > limit+1 {
+		transactions = transactions[:limit+1]
+	}
+
+	for i, tran := range transactions {
+		transactions[i] = s.viewTransaction(tran)
+	}
+
+	return newTransactionPage(transactions, limit), nil
+}
+
+func (s *MemoryStorage) GetTransactions() ([]*t.Transcation, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transactions := []*t.Transcation{}
+	for _, tran := range s.transactions {
+		transactions = append(transactions, s.viewTransaction(tran))
+	}
+
+	return transactions, nil
+}
+
+// ledger
+
+func (s *MemoryStorage) GetJournalEntries(acc_num int) ([]*t.JournalEntry, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []*t.JournalEntry{}
+	for _, entry := range s.entries {
+		for _, p := range entry.Postings {
+			if p.Account == int64(acc_num) {
+				c := *entry
+				c.Postings = append([]t.Posting(nil), entry.Postings...)
+				entries = append(entries, &c)
+				break
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// helpers, callers must hold s.mu
+
+// post appends an already validated journal entry and applies its postings
+// to the balance cache.
+func (s *MemoryStorage) post(entry *t.JournalEntry) {
+
+	s.entries = append(s.entries, entry)
+
+	for _, p := range entry.Postings {
+		balances, ok := s.balances[p.Account]
+		if !ok {
+			balances = map[string]int64{}
+			s.balances[p.Account] = balances
+		}
+		balances[p.Amount.Currency] += p.Amount.Amount
+	}
+}
+
+func (s *MemoryStorage) balance(number int64, currency string) int64 {
+	return s.balances[number][currency]
+}
+
+func (s *MemoryStorage) customerByID(id int) *t.Account {
+	acc, ok := s.accounts[id]
+	if !ok || acc.Kind != t.AccountKindCustomer {
+		return nil
+	}
+	return acc
+}
+
+func (s *MemoryStorage) customerByNumber(number int64) *t.Account {
+	acc := s.accountByNumber(number)
+	if acc == nil || acc.Kind != t.AccountKindCustomer {
+		return nil
+	}
+	return acc
+}
+
+func (s *MemoryStorage) accountByNumber(number int64) *t.Account {
+	for _, acc := range s.accounts {
+		if acc.AccountNumber == number {
+			return acc
+		}
+	}
+	return nil
+}
+
+// accountByEmail finds the customer with email, in any case.
+func (s *MemoryStorage) accountByEmail(email string) *t.Account {
+	for _, acc := range s.accounts {
+		if acc.Kind == t.AccountKindCustomer && strings.EqualFold(acc.Email, email) {
+			return acc
+		}
+	}
+	return nil
+}
+
+// emailInUse reports whether a customer other than the one with id except
+// has email, in any case.
+func (s *MemoryStorage) emailInUse(email string, except int) bool {
+	for _, acc := range s.accounts {
+		if acc.Kind == t.AccountKindCustomer && acc.ID != except && strings.EqualFold(acc.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStorage) copyAccount(acc *t.Account) *t.Account {
+	c := *acc
+	c.Balance = t.NewMoney(s.balance(acc.AccountNumber, acc.Balance.Currency), acc.Balance.Currency)
+	c.Balances = subBalances(acc.Balance.Currency, s.balances[acc.AccountNumber])
+	setHeld(&c, s.held(acc.AccountNumber, time.Now().UTC()))
+	return &c
+}
+
+// viewTransaction joins a transaction with the current state of both
+// accounts, the same way transacationview does in Postgres.
+func (s *MemoryStorage) viewTransaction(tran *t.Transcation) *t.Transcation {
+	c := *tran
+
+	if acc := s.accountByNumber(tran.Sen_acc.AccountNumber); acc != nil {
+		c.Sen_acc = s.partyView(acc)
+	}
+	if acc := s.accountByNumber(tran.Rec_acc.AccountNumber); acc != nil {
+		c.Rec_acc = s.partyView(acc)
+	}
+
+	return &c
+}
+
+// partyView is the part of acc that transacationview shows for each side
+// of a transaction.
+func (s *MemoryStorage) partyView(acc *t.Account) t.Account {
+	return t.Account{
+		FirstName:     acc.FirstName,
+		LastName:      acc.LastName,
+		AccountNumber: acc.AccountNumber,
+		Email:         acc.Email,
+		Balance:       t.NewMoney(s.balance(acc.AccountNumber, acc.Balance.Currency), acc.Balance.Currency),
+	}
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+)
+
+var errMFAEnabled = t.Conflict("mfa_enabled", "two factor authentication is already enabled")
+
+func (s *PostgresStorage) SetMFASecret(m *t.MFA) error {
+
+	// an enabled enrolment is left alone, so a stolen session cannot
+	// silently move the second factor to another device
+	res, err := s.db.Exec(`INSERT INTO mfa (acc_number, secret, last_used_step, created_at) VALUES ($1,$2,0,$3)
+	ON CONFLICT (acc_number) DO UPDATE SET secret = $2, last_used_step = 0, created_at = $3
+	WHERE mfa.enabled_at IS NULL`, m.AccountNumber, m.Secret, m.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return errMFAEnabled
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetMFA(account int64) (*t.MFA, error) {
+	return readMFA(s.db, account, false)
+}
+
+func (s *PostgresStorage) EnableMFA(account int64, recoveryHashes []string, now time.Time) error {
+
+	return s.inTx(func(tx *sql.Tx) error {
+
+		m, err := readMFA(tx, account, true)
+		if err != nil {
+			return err
+		}
+
+		if m.Enabled() {
+			return errMFAEnabled
+		}
+
+		if _, err := tx.Exec(`UPDATE mfa SET enabled_at = $1 WHERE acc_number = $2`, now, account); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE acc_number = $1`, account); err != nil {
+			return err
+		}
+
+		for _, hash := range recoveryHashes {
+			if _, err := tx.Exec(`INSERT INTO mfa_recovery_codes (acc_number, code_hash) VALUES ($1,$2)`, account, hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *PostgresStorage) DisableMFA(account int64) error {
+
+	// the recovery codes go with it through ON DELETE CASCADE
+	res, err := s.db.Exec(`DELETE FROM mfa WHERE acc_number = $1`, account)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return mfaNotEnrolled(account)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) UseTOTPStep(account int64, step int64) error {
+
+	res, err := s.db.Exec(`UPDATE mfa SET last_used_step = $1 WHERE acc_number = $2 AND last_used_step < $1`, step, account)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) UseRecoveryCode(account int64, hash string, now time.Time) error {
+
+	res, err := s.db.Exec(`UPDATE mfa_recovery_codes SET used_at = $1
+	WHERE acc_number = $2 AND code_hash = $3 AND used_at IS NULL`, now, account, hash)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) CreateMFAChallenge(c *t.MFAChallenge) error {
+
+	_, err := s.db.Exec(`INSERT INTO mfa_challenges (id, acc_number, token_hash, attempts, created_at, expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6)`, c.Id, c.AccountNumber, c.TokenHash, c.Attempts, c.CreatedAt, c.ExpiresAt)
+
+	return err
+}
+
+func (s *PostgresStorage) GetMFAChallenge(hash string, now time.Time) (*t.MFAChallenge, error) {
+
+	c := &t.MFAChallenge{}
+	err := s.db.QueryRow(`SELECT id, acc_number, token_hash, attempts, created_at, expires_at, used_at
+	FROM mfa_challenges WHERE token_hash = $1`, hash).Scan(
+		&c.Id, &c.AccountNumber, &c.TokenHash, &c.Attempts, &c.CreatedAt, &c.ExpiresAt, &c.UsedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.Valid(now) {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	return c, nil
+}
+
+func (s *PostgresStorage) UseMFAChallenge(hash string, now time.Time) error {
+
+	res, err := s.db.Exec(`UPDATE mfa_challenges SET used_at = $1
+	WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 AND attempts < $3`, now, hash, t.MaxMFAAttempts)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrMFAChallengeInvalid
+	}
+
+	// challenges are only needed for a few minutes, so this is a good
+	// moment to clear out the old ones
+	_, err = s.db.Exec(`DELETE FROM mfa_challenges WHERE expires_at < $1`, now.Add(-24*time.Hour))
+	return err
+}
+
+func (s *PostgresStorage) FailMFAChallenge(hash string) error {
+
+	_, err := s.db.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1`, hash)
+	return err
+}
+
+func readMFA(db execer, account int64, lock bool) (*t.MFA, error) {
+
+	query := `SELECT acc_number, secret, enabled_at, last_used_step, created_at FROM mfa WHERE acc_number = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	m := &t.MFA{}
+	err := db.QueryRow(query, account).Scan(&m.AccountNumber, &m.Secret, &m.EnabledAt, &m.LastUsedStep, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, mfaNotEnrolled(account)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *MemoryStorage) SetMFASecret(m *t.MFA) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.mfa[m.AccountNumber]; ok && current.Enabled() {
+		return errMFAEnabled
+	}
+
+	c := *m
+	c.EnabledAt = nil
+	c.LastUsedStep = 0
+	s.mfa[m.AccountNumber] = &c
+
+	return nil
+}
+
+func (s *MemoryStorage) GetMFA(account int64) (*t.MFA, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.mfa[account]
+	if !ok {
+		return nil, mfaNotEnrolled(account)
+	}
+
+	c := *m
+	return &c, nil
+}
+
+func (s *MemoryStorage) EnableMFA(account int64, recoveryHashes []string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mfa[account]
+	if !ok {
+		return mfaNotEnrolled(account)
+	}
+
+	if m.Enabled() {
+		return errMFAEnabled
+	}
+
+	enabledAt := now
+	m.EnabledAt = &enabledAt
+
+	codes := map[string]bool{}
+	for _, hash := range recoveryHashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[account] = codes
+
+	return nil
+}
+
+func (s *MemoryStorage) DisableMFA(account int64) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mfa[account]; !ok {
+		return mfaNotEnrolled(account)
+	}
+
+	delete(s.mfa, account)
+	delete(s.recoveryCodes, account)
+
+	return nil
+}
+
+func (s *MemoryStorage) UseTOTPStep(account int64, step int64) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.mfa[account]
+	if !ok || m.LastUsedStep >= step {
+		return ErrInvalidMFACode
+	}
+
+	m.LastUsedStep = step
+	return nil
+}
+
+func (s *MemoryStorage) UseRecoveryCode(account int64, hash string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[account][hash]
+	if !ok || used {
+		return ErrInvalidMFACode
+	}
+
+	s.recoveryCodes[account][hash] = true
+	return nil
+}
+
+func (s *MemoryStorage) CreateMFAChallenge(c *t.MFAChallenge) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge := *c
+	s.mfaChallenges[c.TokenHash] = &challenge
+
+	return nil
+}
+
+func (s *MemoryStorage) GetMFAChallenge(hash string, now time.Time) (*t.MFAChallenge, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.mfaChallenges[hash]
+	if !ok || !c.Valid(now) {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	challenge := *c
+	return &challenge, nil
+}
+
+func (s *MemoryStorage) UseMFAChallenge(hash string, now time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.mfaChallenges[hash]
+	if !ok || !c.Valid(now) {
+		return ErrMFAChallengeInvalid
+	}
+
+	usedAt := now
+	c.UsedAt = &usedAt
+
+	for h, old := range s.mfaChallenges {
+		if old.ExpiresAt.Before(now.Add(-24 * time.Hour)) {
+			delete(s.mfaChallenges, h)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) FailMFAChallenge(hash string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.mfaChallenges[hash]; ok {
+		c.Attempts++
+	}
+
+	return nil
+}
+
+func mfaNotEnrolled(account int64) error {
+	return t.NotFound("mfa_not_enrolled", "two factor authentication is not set up for account %d", account)
+}
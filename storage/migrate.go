@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so two
+// instances booting at the same time cannot apply the same migration twice.
+const migrationLockKey = 7_264_372_651
+
+// schema is the migrations of one kind of database: the directory of
+// migrationFiles they are read from, and whether concurrent migrations are
+// kept apart with migrationLockKey.
+type schema struct {
+	dir          string
+	advisoryLock bool
+}
+
+var (
+	postgresSchema = schema{dir: "migrations", advisoryLock: true}
+
+	// a SQLite file belongs to a single process, and its own write lock is
+	// held by each migration's transaction
+	sqliteSchema = schema{dir: "migrations/sqlite"}
+)
+
+// Migration is one numbered schema change with its up and down scripts.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// loadMigrations reads files named <version>_<name>.<up|down>.sql from dir
+// and returns them ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+
+		if e.IsDir() {
+			continue
+		}
+
+		name := e.Name()
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		versionStr, label, ok := strings.Cut(base, "_")
+		if !ok || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s", name)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+
+		if direction == ".up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// withMigrationLock runs f on a single connection that holds the migration
+// advisory lock. The schema_migrations table is created first if needed.
+func (s *PostgresStorage) withMigrationLock(f func(conn *sql.Conn) error) error {
+
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if s.schema.advisoryLock {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint primary key,
+		name varchar(100) not null,
+		applied_at timestamptz not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+func appliedMigrations(conn *sql.Conn) (map[int]time.Time, error) {
+
+	rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+// MigrateUp applies every pending migration in order.
+func (s *PostgresStorage) MigrateUp() error {
+
+	migrations, err := loadMigrations(migrationFiles, s.schema.dir)
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(func(conn *sql.Conn) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			if err := runMigration(conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1,$2,$3)`,
+					m.Version, m.Name, time.Now().UTC())
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the last steps applied migrations.
+func (s *PostgresStorage) MigrateDown(steps int) error {
+
+	migrations, err := loadMigrations(migrationFiles, s.schema.dir)
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(func(conn *sql.Conn) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+
+			if err := runMigration(conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (s *PostgresStorage) MigrationStatus() ([]*MigrationStatus, error) {
+
+	migrations, err := loadMigrations(migrationFiles, s.schema.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	status := []*MigrationStatus{}
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			st := &MigrationStatus{Version: m.Version, Name: m.Name}
+			if at, ok := applied[m.Version]; ok {
+				st.AppliedAt = &at
+			}
+			status = append(status, st)
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+// runMigration executes script and record in one transaction, so a failed
+// migration leaves neither the schema change nor its bookkeeping row behind.
+func runMigration(conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
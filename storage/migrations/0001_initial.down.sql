@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS transacationview;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS accounts (
+	id serial,
+	first_name varchar(50),
+	last_name varchar(50),
+	acc_number serial unique,
+	balance money,
+	email varchar(50),
+	password varchar(200),
+	created_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	transaction_id uuid primary key,
+	sen_acc serial references accounts(acc_number),
+	rec_acc serial references accounts(acc_number),
+	amount money,
+	description varchar(80),
+	status varchar(20),
+	date timestamp
+);
+
+CREATE OR REPLACE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.email AS receiver_email
+FROM transactions t
+JOIN accounts s ON t.sen_acc = s.acc_number
+JOIN accounts r ON t.rec_acc = r.acc_number;
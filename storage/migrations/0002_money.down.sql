@@ -0,0 +1,21 @@
+DROP VIEW IF EXISTS transacationview;
+
+ALTER TABLE transactions
+	DROP COLUMN currency,
+	ALTER COLUMN amount TYPE money USING (amount::numeric / 100)::money;
+
+ALTER TABLE accounts
+	DROP COLUMN currency,
+	ALTER COLUMN balance DROP NOT NULL,
+	ALTER COLUMN balance DROP DEFAULT,
+	ALTER COLUMN balance TYPE money USING (balance::numeric / 100)::money;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.email AS receiver_email
+FROM transactions t
+JOIN accounts s ON t.sen_acc = s.acc_number
+JOIN accounts r ON t.rec_acc = r.acc_number;
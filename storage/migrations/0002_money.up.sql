@@ -0,0 +1,25 @@
+-- money depends on lc_monetary and comes back as "$1,234.00"; store
+-- integer minor units with an explicit currency instead
+DROP VIEW IF EXISTS transacationview;
+
+ALTER TABLE accounts
+	ALTER COLUMN balance TYPE bigint USING round(balance::numeric * 100)::bigint,
+	ADD COLUMN currency char(3) NOT NULL DEFAULT 'USD';
+UPDATE accounts SET balance = 0 WHERE balance IS NULL;
+ALTER TABLE accounts
+	ALTER COLUMN balance SET DEFAULT 0,
+	ALTER COLUMN balance SET NOT NULL;
+
+ALTER TABLE transactions
+	ALTER COLUMN amount TYPE bigint USING round(amount::numeric * 100)::bigint,
+	ADD COLUMN currency char(3) NOT NULL DEFAULT 'USD';
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accounts s ON t.sen_acc = s.acc_number
+JOIN accounts r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,84 @@
+-- balances are no longer stored on the account; they are the sum of the
+-- postings made against it
+ALTER TABLE accounts ADD COLUMN kind varchar(10) NOT NULL DEFAULT 'customer';
+
+-- system accounts use negative numbers so they can never clash with the
+-- numbers handed out to customers
+INSERT INTO accounts (first_name, last_name, acc_number, balance, currency, email, password, created_at, kind) VALUES
+	('System', 'Top up', -1, 0, 'USD', NULL, NULL, now(), 'system'),
+	('System', 'Fees', -2, 0, 'USD', NULL, NULL, now(), 'system'),
+	('System', 'Suspense', -3, 0, 'USD', NULL, NULL, now(), 'system');
+
+CREATE TABLE journal_entries (
+	id uuid primary key,
+	transaction_id uuid references transactions(transaction_id),
+	kind varchar(20) not null,
+	description varchar(80),
+	created_at timestamptz not null
+);
+
+CREATE TABLE postings (
+	id bigserial primary key,
+	entry_id uuid not null references journal_entries(id),
+	acc_number int not null references accounts(acc_number),
+	amount bigint not null check (amount <> 0),
+	currency char(3) not null
+);
+
+CREATE INDEX postings_account_idx ON postings (acc_number, currency);
+CREATE INDEX postings_entry_idx ON postings (entry_id);
+
+-- every journal entry must sum to zero per currency by the time the
+-- transaction that wrote it commits
+CREATE FUNCTION check_journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+	IF EXISTS (
+		SELECT 1 FROM postings WHERE entry_id = NEW.entry_id
+		GROUP BY currency HAVING sum(amount) <> 0
+	) THEN
+		RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+	AFTER INSERT OR UPDATE ON postings
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+-- carry existing balances over as opening entries against suspense
+CREATE TEMPORARY TABLE opening_balances ON COMMIT DROP AS
+SELECT gen_random_uuid() AS entry_id, acc_number, balance, currency
+FROM accounts WHERE kind = 'customer' AND balance <> 0;
+
+INSERT INTO journal_entries (id, kind, description, created_at)
+SELECT entry_id, 'opening', 'Opening balance', now() FROM opening_balances;
+
+INSERT INTO postings (entry_id, acc_number, amount, currency)
+SELECT entry_id, acc_number, balance, currency FROM opening_balances
+UNION ALL
+SELECT entry_id, -3, -balance, currency FROM opening_balances;
+
+DROP VIEW transacationview;
+ALTER TABLE accounts DROP COLUMN balance;
+
+-- system accounts have no email or password; they show as empty strings
+-- so the views always scan into plain strings
+CREATE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind
+FROM accounts a;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,12 @@
+CREATE TABLE idempotency_keys (
+	key varchar(255) not null,
+	scope varchar(255) not null,
+	fingerprint char(64) not null,
+	status_code int,
+	response bytea,
+	created_at timestamptz not null,
+	expires_at timestamptz not null,
+	primary key (key, scope)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
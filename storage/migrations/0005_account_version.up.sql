@@ -0,0 +1,24 @@
+ALTER TABLE accounts ADD COLUMN version int NOT NULL DEFAULT 1;
+
+-- system accounts have no email or password; expose them as empty strings
+-- so the views always scan into plain strings
+DROP VIEW transacationview;
+DROP VIEW accountview;
+
+CREATE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version
+FROM accounts a;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
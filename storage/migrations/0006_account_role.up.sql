@@ -0,0 +1,9 @@
+ALTER TABLE accounts ADD COLUMN role varchar(20) NOT NULL DEFAULT 'customer';
+
+CREATE OR REPLACE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role
+FROM accounts a;
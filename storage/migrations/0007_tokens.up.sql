@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+	id uuid primary key,
+	family_id uuid not null,
+	acc_number int not null references accounts(acc_number),
+	token_hash char(64) not null unique,
+	created_at timestamptz not null,
+	expires_at timestamptz not null,
+	revoked_at timestamptz,
+	replaced_by uuid
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+-- access tokens revoked before they expire; rows can be dropped once
+-- expires_at has passed
+CREATE TABLE revoked_tokens (
+	jti uuid primary key,
+	expires_at timestamptz not null
+);
@@ -0,0 +1 @@
+ALTER TABLE accounts DROP CONSTRAINT accounts_role_check;
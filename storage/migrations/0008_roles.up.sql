@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD CONSTRAINT accounts_role_check
+	CHECK (role IN ('customer', 'support', 'operator', 'admin'));
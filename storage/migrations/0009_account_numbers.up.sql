@@ -0,0 +1,34 @@
+-- account numbers become 10 digit numbers with a Luhn check digit handed
+-- out from a sequence, which no longer fit in an int
+CREATE SEQUENCE account_number_seq MINVALUE 1 MAXVALUE 899999999;
+
+DROP VIEW transacationview;
+DROP VIEW accountview;
+
+ALTER TABLE accounts ALTER COLUMN acc_number DROP DEFAULT;
+ALTER TABLE accounts ALTER COLUMN acc_number TYPE bigint;
+ALTER TABLE transactions
+	ALTER COLUMN sen_acc DROP DEFAULT,
+	ALTER COLUMN sen_acc TYPE bigint,
+	ALTER COLUMN rec_acc DROP DEFAULT,
+	ALTER COLUMN rec_acc TYPE bigint;
+ALTER TABLE postings ALTER COLUMN acc_number TYPE bigint;
+ALTER TABLE refresh_tokens ALTER COLUMN acc_number TYPE bigint;
+
+CREATE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role
+FROM accounts a;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transactions_status_idx;
+DROP INDEX IF EXISTS transactions_rec_acc_date_idx;
+DROP INDEX IF EXISTS transactions_sen_acc_date_idx;
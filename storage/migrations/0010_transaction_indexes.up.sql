@@ -0,0 +1,4 @@
+-- history is read newest first per account and paged by (date, transaction_id)
+CREATE INDEX transactions_sen_acc_date_idx ON transactions (sen_acc, date DESC, transaction_id DESC);
+CREATE INDEX transactions_rec_acc_date_idx ON transactions (rec_acc, date DESC, transaction_id DESC);
+CREATE INDEX transactions_status_idx ON transactions (status);
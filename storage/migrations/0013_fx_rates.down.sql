@@ -0,0 +1,23 @@
+DROP VIEW transacationview;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
+
+ALTER TABLE transactions
+	DROP CONSTRAINT transactions_credit_check,
+	DROP COLUMN credit_amount,
+	DROP COLUMN credit_currency,
+	DROP COLUMN fx_rate;
+
+-- fails once conversions have been posted against it, which cannot be
+-- undone by dropping columns
+DELETE FROM accounts WHERE acc_number = -4 AND kind = 'system';
+
+DROP TABLE fx_rates;
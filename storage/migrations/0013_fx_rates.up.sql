@@ -0,0 +1,37 @@
+-- one rate per direction of a currency pair, since the spread charged on
+-- buying and selling a currency can differ
+CREATE TABLE fx_rates (
+	base char(3) not null,
+	quote char(3) not null,
+	rate numeric(24,12) not null check (rate > 0),
+	spread_bps int not null default 0 check (spread_bps >= 0 AND spread_bps < 10000),
+	updated_at timestamptz not null,
+	primary key (base, quote),
+	check (base <> quote)
+);
+
+-- conversions are booked against the FX system account, which takes in the
+-- currency sold and pays out the currency bought
+INSERT INTO accounts (first_name, last_name, acc_number, currency, email, password, created_at, kind) VALUES
+	('System', 'FX', -4, 'USD', NULL, NULL, now(), 'system');
+
+-- a converted transfer credits the receiver in another currency
+ALTER TABLE transactions
+	ADD COLUMN credit_amount bigint,
+	ADD COLUMN credit_currency char(3),
+	ADD COLUMN fx_rate numeric(32,16),
+	ADD CONSTRAINT transactions_credit_check
+		CHECK ((credit_amount IS NULL) = (credit_currency IS NULL) AND (credit_amount IS NULL) = (fx_rate IS NULL));
+
+DROP VIEW transacationview;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,18 @@
+DROP VIEW transacationview;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
+
+DROP INDEX transactions_original_id_idx;
+
+ALTER TABLE transactions
+	DROP COLUMN original_id,
+	DROP COLUMN refunded_amount;
@@ -0,0 +1,16 @@
+-- accounts are closed instead of deleted, so their history stays
+ALTER TABLE accounts
+	ADD COLUMN status varchar(10) NOT NULL DEFAULT 'active'
+		CHECK (status IN ('active', 'frozen', 'dormant', 'closed')),
+	ADD COLUMN status_reason text NOT NULL DEFAULT '',
+	ADD COLUMN status_changed_at timestamp;
+
+CREATE INDEX accounts_status_idx ON accounts (status) WHERE kind = 'customer';
+
+CREATE OR REPLACE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role, a.status, a.status_reason, a.status_changed_at
+FROM accounts a;
@@ -0,0 +1,25 @@
+ALTER TABLE accounts ADD COLUMN email_verified_at timestamp;
+
+CREATE OR REPLACE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role, a.status, a.status_reason, a.status_changed_at,
+	a.email_verified_at
+FROM accounts a;
+
+-- single use tokens mailed for email verification and password resets;
+-- only their hashes are stored
+CREATE TABLE account_tokens (
+	id uuid PRIMARY KEY,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	purpose varchar(20) NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+	email varchar(255) NOT NULL,
+	token_hash char(64) NOT NULL UNIQUE,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX account_tokens_acc_number_idx ON account_tokens (acc_number, purpose) WHERE used_at IS NULL;
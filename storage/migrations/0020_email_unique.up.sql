@@ -0,0 +1,20 @@
+-- emails are unique whatever their case, so Bob@example.com cannot sign up
+-- next to bob@example.com; system accounts have no email. Accounts that
+-- already share an email in another case have to be told apart by hand,
+-- as nothing says which of them the inbox belongs to.
+DO $$
+DECLARE
+	duplicates text;
+BEGIN
+	SELECT string_agg(d.email, ', ') INTO duplicates FROM (
+		SELECT lower(email) AS email FROM accounts WHERE kind = 'customer'
+		GROUP BY lower(email) HAVING count(*) > 1
+	) d;
+
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'accounts have emails that differ only in case: %', duplicates
+			USING HINT = 'change the email of all but one account of each, then migrate again';
+	END IF;
+END $$;
+
+CREATE UNIQUE INDEX accounts_email_lower_key ON accounts (lower(email)) WHERE kind = 'customer';
@@ -0,0 +1,11 @@
+-- access tokens issued before the password last changed are turned away
+ALTER TABLE accounts ADD COLUMN password_changed_at timestamp;
+
+CREATE OR REPLACE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0)::bigint AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role, a.status, a.status_reason, a.status_changed_at,
+	a.email_verified_at, a.password_changed_at
+FROM accounts a;
@@ -0,0 +1,37 @@
+-- each SQLite migration makes the change of the postgres migration with the
+-- same version. uuids and decimals are kept as text so they come back
+-- exactly as they went in, and times are declared as timestamp so the
+-- driver scans them into time.Time.
+
+-- SQLite has no money type, so amounts are integer minor units from the
+-- start rather than from 0002
+CREATE TABLE IF NOT EXISTS accounts (
+	id integer PRIMARY KEY,
+	first_name varchar(50),
+	last_name varchar(50),
+	acc_number bigint UNIQUE,
+	balance bigint,
+	email varchar(50),
+	password varchar(200),
+	created_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	transaction_id text PRIMARY KEY,
+	sen_acc bigint REFERENCES accounts(acc_number),
+	rec_acc bigint REFERENCES accounts(acc_number),
+	amount bigint,
+	description varchar(80),
+	status varchar(20),
+	date timestamp
+);
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.email AS receiver_email
+FROM transactions t
+JOIN accounts s ON t.sen_acc = s.acc_number
+JOIN accounts r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,23 @@
+DROP VIEW transacationview;
+DROP VIEW accountview;
+
+ALTER TABLE accounts ADD COLUMN balance bigint NOT NULL DEFAULT 0;
+UPDATE accounts SET balance = COALESCE((SELECT sum(p.amount) FROM postings p
+	WHERE p.acc_number = accounts.acc_number AND p.currency = accounts.currency), 0);
+
+DROP TABLE postings;
+DROP TABLE journal_entries;
+
+DELETE FROM transactions WHERE sen_acc < 0 OR rec_acc < 0;
+DELETE FROM accounts WHERE kind = 'system';
+ALTER TABLE accounts DROP COLUMN kind;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accounts s ON t.sen_acc = s.acc_number
+JOIN accounts r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,12 @@
+CREATE TABLE idempotency_keys (
+	key varchar(255) NOT NULL,
+	scope varchar(255) NOT NULL,
+	fingerprint char(64) NOT NULL,
+	status_code int,
+	response blob,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	PRIMARY KEY (key, scope)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+	id text PRIMARY KEY,
+	family_id text NOT NULL,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	token_hash char(64) NOT NULL UNIQUE,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	revoked_at timestamp,
+	replaced_by text
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+-- access tokens revoked before they expire; rows can be dropped once
+-- expires_at has passed
+CREATE TABLE revoked_tokens (
+	jti text PRIMARY KEY,
+	expires_at timestamp NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TRIGGER accounts_role_check_update;
+DROP TRIGGER accounts_role_check_insert;
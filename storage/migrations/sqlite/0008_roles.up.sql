@@ -0,0 +1,13 @@
+-- SQLite cannot add a constraint to an existing table, so triggers make
+-- the check instead
+CREATE TRIGGER accounts_role_check_insert BEFORE INSERT ON accounts
+WHEN NEW.role NOT IN ('customer', 'support', 'operator', 'admin')
+BEGIN
+	SELECT RAISE(ABORT, 'CHECK constraint failed: accounts_role_check');
+END;
+
+CREATE TRIGGER accounts_role_check_update BEFORE UPDATE OF role ON accounts
+WHEN NEW.role NOT IN ('customer', 'support', 'operator', 'admin')
+BEGIN
+	SELECT RAISE(ABORT, 'CHECK constraint failed: accounts_role_check');
+END;
@@ -0,0 +1,9 @@
+-- account numbers become 10 digit numbers with a Luhn check digit. SQLite
+-- integers are 64 bit whatever a column is declared as, so only the
+-- sequence is new; SQLite has none, so it is kept as a row here.
+CREATE TABLE sequences (
+	name text PRIMARY KEY,
+	value integer NOT NULL CHECK (value <= 899999999)
+);
+
+INSERT INTO sequences (name, value) VALUES ('account_number_seq', 0);
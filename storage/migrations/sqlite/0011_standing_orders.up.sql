@@ -0,0 +1,35 @@
+CREATE TABLE standing_orders (
+	id text PRIMARY KEY,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	to_acc_number bigint NOT NULL,
+	amount bigint NOT NULL,
+	currency char(3) NOT NULL,
+	description varchar(80) NOT NULL,
+	frequency varchar(10) NOT NULL CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+	start_at timestamp NOT NULL,
+	end_date timestamp,
+	max_runs integer NOT NULL DEFAULT 0,
+	runs_done integer NOT NULL DEFAULT 0,
+	next_run_at timestamp NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text NOT NULL DEFAULT '',
+	status varchar(10) NOT NULL,
+	locked_until timestamp,
+	created_at timestamp NOT NULL,
+	updated_at timestamp NOT NULL
+);
+
+CREATE INDEX standing_orders_acc_number_idx ON standing_orders (acc_number);
+CREATE INDEX standing_orders_due_idx ON standing_orders (next_run_at) WHERE status = 'active';
+
+CREATE TABLE standing_order_runs (
+	id text PRIMARY KEY,
+	order_id text NOT NULL REFERENCES standing_orders(id) ON DELETE CASCADE,
+	run integer NOT NULL,
+	attempt integer NOT NULL,
+	transaction_id text REFERENCES transactions(transaction_id),
+	error text NOT NULL DEFAULT '',
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX standing_order_runs_order_id_idx ON standing_order_runs (order_id, created_at);
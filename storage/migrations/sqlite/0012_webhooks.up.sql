@@ -0,0 +1,42 @@
+-- acc_number 0 marks a tenant wide endpoint that receives every account's
+-- events; events is a comma separated list, empty meaning all of them
+CREATE TABLE webhook_endpoints (
+	id text PRIMARY KEY,
+	acc_number bigint NOT NULL DEFAULT 0,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text NOT NULL DEFAULT '',
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX webhook_endpoints_acc_number_idx ON webhook_endpoints (acc_number);
+
+-- the transactional outbox, written in the same transaction as the change
+-- each event describes
+CREATE TABLE outbox_events (
+	id text PRIMARY KEY,
+	type varchar(40) NOT NULL,
+	accounts text NOT NULL,
+	data text NOT NULL,
+	created_at timestamp NOT NULL,
+	dispatched_at timestamp
+);
+
+CREATE INDEX outbox_events_pending_idx ON outbox_events (created_at) WHERE dispatched_at IS NULL;
+
+CREATE TABLE webhook_deliveries (
+	id text PRIMARY KEY,
+	event_id text NOT NULL REFERENCES outbox_events(id),
+	endpoint_id text NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+	status varchar(10) NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamp NOT NULL,
+	last_error text NOT NULL DEFAULT '',
+	last_status integer NOT NULL DEFAULT 0,
+	locked_until timestamp,
+	created_at timestamp NOT NULL,
+	delivered_at timestamp
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_endpoint_idx ON webhook_deliveries (endpoint_id, status);
@@ -0,0 +1,48 @@
+-- one rate per direction of a currency pair, since the spread charged on
+-- buying and selling a currency can differ
+CREATE TABLE fx_rates (
+	base char(3) NOT NULL,
+	quote char(3) NOT NULL,
+	rate text NOT NULL CHECK (CAST(rate AS REAL) > 0),
+	spread_bps int NOT NULL DEFAULT 0 CHECK (spread_bps >= 0 AND spread_bps < 10000),
+	updated_at timestamp NOT NULL,
+	PRIMARY KEY (base, quote),
+	CHECK (base <> quote)
+);
+
+-- conversions are booked against the FX system account, which takes in the
+-- currency sold and pays out the currency bought
+INSERT INTO accounts (first_name, last_name, acc_number, currency, email, password, created_at, kind) VALUES
+	('System', 'FX', -4, 'USD', NULL, NULL, strftime('%Y-%m-%d %H:%M:%S', 'now'), 'system');
+
+-- a converted transfer credits the receiver in another currency. The
+-- columns go in one at a time and their check is made by triggers, as
+-- SQLite cannot add a table constraint.
+ALTER TABLE transactions ADD COLUMN credit_amount bigint;
+ALTER TABLE transactions ADD COLUMN credit_currency char(3);
+ALTER TABLE transactions ADD COLUMN fx_rate text;
+
+CREATE TRIGGER transactions_credit_check_insert BEFORE INSERT ON transactions
+WHEN NOT ((NEW.credit_amount IS NULL) = (NEW.credit_currency IS NULL) AND (NEW.credit_amount IS NULL) = (NEW.fx_rate IS NULL))
+BEGIN
+	SELECT RAISE(ABORT, 'CHECK constraint failed: transactions_credit_check');
+END;
+
+CREATE TRIGGER transactions_credit_check_update BEFORE UPDATE OF credit_amount, credit_currency, fx_rate ON transactions
+WHEN NOT ((NEW.credit_amount IS NULL) = (NEW.credit_currency IS NULL) AND (NEW.credit_amount IS NULL) = (NEW.fx_rate IS NULL))
+BEGIN
+	SELECT RAISE(ABORT, 'CHECK constraint failed: transactions_credit_check');
+END;
+
+DROP VIEW transacationview;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,20 @@
+-- reversals and refunds are transactions of their own that point back at
+-- the transaction they give back; refunded_amount is in the currency the
+-- original credited its receiver in
+ALTER TABLE transactions ADD COLUMN original_id text REFERENCES transactions(transaction_id);
+ALTER TABLE transactions ADD COLUMN refunded_amount bigint NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0);
+
+CREATE INDEX transactions_original_id_idx ON transactions (original_id) WHERE original_id IS NOT NULL;
+
+DROP VIEW transacationview;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.refunded_amount, t.original_id, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
@@ -0,0 +1,20 @@
+-- funds reserved on acc_number for a later payment to to_acc_number;
+-- active holds that have not expired count against the available balance
+CREATE TABLE holds (
+	id text PRIMARY KEY,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	to_acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	amount bigint NOT NULL CHECK (amount > 0),
+	currency char(3) NOT NULL,
+	captured_amount bigint CHECK (captured_amount > 0 AND captured_amount <= amount),
+	transaction_id text REFERENCES transactions(transaction_id),
+	description varchar(80) NOT NULL,
+	status varchar(10) NOT NULL CHECK (status IN ('active', 'captured', 'released', 'expired')),
+	expires_at timestamp NOT NULL,
+	created_at timestamp NOT NULL,
+	updated_at timestamp NOT NULL
+);
+
+CREATE INDEX holds_acc_number_idx ON holds (acc_number) WHERE status = 'active';
+CREATE INDEX holds_to_acc_number_idx ON holds (to_acc_number);
+CREATE INDEX holds_expires_at_idx ON holds (expires_at) WHERE status = 'active';
@@ -0,0 +1,29 @@
+-- accounts are closed instead of deleted, so their history stays
+ALTER TABLE accounts ADD COLUMN status varchar(10) NOT NULL DEFAULT 'active'
+	CHECK (status IN ('active', 'frozen', 'dormant', 'closed'));
+ALTER TABLE accounts ADD COLUMN status_reason text NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN status_changed_at timestamp;
+
+CREATE INDEX accounts_status_idx ON accounts (status) WHERE kind = 'customer';
+
+DROP VIEW transacationview;
+DROP VIEW accountview;
+
+CREATE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0) AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role, a.status, a.status_reason, a.status_changed_at
+FROM accounts a;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.refunded_amount, t.original_id, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
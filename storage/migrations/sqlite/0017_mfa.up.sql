@@ -0,0 +1,30 @@
+-- TOTP enrolments; enabled_at stays NULL until the first code is confirmed.
+-- The secret has to be readable to check codes, so unlike passwords and
+-- tokens it cannot be hashed.
+CREATE TABLE mfa (
+	acc_number bigint PRIMARY KEY REFERENCES accounts(acc_number),
+	secret varchar(64) NOT NULL,
+	enabled_at timestamp,
+	last_used_step bigint NOT NULL DEFAULT 0,
+	created_at timestamp NOT NULL
+);
+
+CREATE TABLE mfa_recovery_codes (
+	acc_number bigint NOT NULL REFERENCES mfa(acc_number) ON DELETE CASCADE,
+	code_hash char(64) NOT NULL,
+	used_at timestamp,
+	PRIMARY KEY (acc_number, code_hash)
+);
+
+-- logins that passed the password check and still owe the second factor
+CREATE TABLE mfa_challenges (
+	id text PRIMARY KEY,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	token_hash char(64) NOT NULL UNIQUE,
+	attempts int NOT NULL DEFAULT 0,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX mfa_challenges_expires_at_idx ON mfa_challenges (expires_at);
@@ -0,0 +1,20 @@
+-- every login attempt; acc_number is NULL when the email has no account
+CREATE TABLE login_attempts (
+	id text PRIMARY KEY,
+	acc_number bigint REFERENCES accounts(acc_number),
+	email varchar(255) NOT NULL,
+	ip varchar(64) NOT NULL,
+	user_agent varchar(512) NOT NULL,
+	result varchar(20) NOT NULL,
+	created_at timestamp NOT NULL
+);
+
+CREATE INDEX login_attempts_acc_number_idx ON login_attempts (acc_number, created_at DESC);
+
+-- failed login counters keyed by "email:<email>" or "ip:<address>"
+CREATE TABLE login_throttles (
+	key varchar(300) PRIMARY KEY,
+	failures int NOT NULL,
+	last_failure_at timestamp NOT NULL,
+	locked_until timestamp
+);
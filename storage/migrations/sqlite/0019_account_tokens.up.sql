@@ -0,0 +1,39 @@
+ALTER TABLE accounts ADD COLUMN email_verified_at timestamp;
+
+DROP VIEW transacationview;
+DROP VIEW accountview;
+
+CREATE VIEW accountview AS
+SELECT a.id, a.first_name, a.last_name, a.acc_number,
+	COALESCE((SELECT sum(p.amount) FROM postings p
+		WHERE p.acc_number = a.acc_number AND p.currency = a.currency), 0) AS balance,
+	a.currency, COALESCE(a.email, '') AS email, COALESCE(a.password, '') AS password,
+	a.created_at, a.kind, a.version, a.role, a.status, a.status_reason, a.status_changed_at,
+	a.email_verified_at
+FROM accounts a;
+
+CREATE VIEW transacationview AS
+SELECT t.transaction_id, t.amount, t.currency, t.credit_amount, t.credit_currency, t.fx_rate,
+	t.refunded_amount, t.original_id, t.description, t.status, t.date,
+	s.acc_number AS sender_acc, s.first_name AS sender_fn, s.last_name AS sender_ln,
+	s.balance AS sender_balance, s.currency AS sender_currency, s.email AS sender_email,
+	r.acc_number AS receiver_acc, r.first_name AS receiver_fn, r.last_name AS receiver_ln,
+	r.balance AS receiver_balance, r.currency AS receiver_currency, r.email AS receiver_email
+FROM transactions t
+JOIN accountview s ON t.sen_acc = s.acc_number
+JOIN accountview r ON t.rec_acc = r.acc_number;
+
+-- single use tokens mailed for email verification and password resets;
+-- only their hashes are stored
+CREATE TABLE account_tokens (
+	id text PRIMARY KEY,
+	acc_number bigint NOT NULL REFERENCES accounts(acc_number),
+	purpose varchar(20) NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+	email varchar(255) NOT NULL,
+	token_hash char(64) NOT NULL UNIQUE,
+	created_at timestamp NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX account_tokens_acc_number_idx ON account_tokens (acc_number, purpose) WHERE used_at IS NULL;
@@ -0,0 +1,20 @@
+-- emails are unique whatever their case, so Bob@example.com cannot sign up
+-- next to bob@example.com; system accounts have no email. Accounts that
+-- already share an email in another case have to be told apart by hand,
+-- as nothing says which of them the inbox belongs to. SQLite can only
+-- raise an error from a trigger, so one is fired once for the check.
+CREATE TEMP TABLE email_case_check (checked integer);
+
+CREATE TEMP TRIGGER email_case_check BEFORE INSERT ON email_case_check
+WHEN EXISTS (
+	SELECT 1 FROM accounts WHERE kind = 'customer'
+	GROUP BY lower(email) HAVING count(*) > 1
+)
+BEGIN
+	SELECT RAISE(ABORT, 'accounts have emails that differ only in case; change the email of all but one account of each, then migrate again');
+END;
+
+INSERT INTO email_case_check VALUES (1);
+DROP TABLE email_case_check;
+
+CREATE UNIQUE INDEX accounts_email_lower_key ON accounts (lower(email)) WHERE kind = 'customer';
@@ -0,0 +1,4 @@
+-- a run of a standing order is paid at most once. The scheduler writes the
+-- paid run in the transaction that makes its transfer, so a run that is
+-- retried, however much later, cannot be paid again.
+CREATE UNIQUE INDEX standing_order_runs_paid_key ON standing_order_runs (order_id, run) WHERE transaction_id IS NOT NULL;
@@ -0,0 +1,3 @@
+-- the scheduler and the owner both save standing orders; each save checks
+-- the version it read so neither overwrites the other
+ALTER TABLE standing_orders ADD COLUMN version integer NOT NULL DEFAULT 0;
@@ -2,45 +2,32 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	t "github.com/mrkhay/gobank/type"
-	"golang.org/x/crypto/bcrypt"
 )
 
-type Storage interface {
-	CreateAccount(*t.Account) error
-	DeleteAccount(int) error
-	UpdateAccount(*t.Account) error
-	GetAccounts() ([]*t.Account, error)
-	AccountQuerey
-	Transaction
-}
-
-type AccountQuerey interface {
-	GetAccountByID(int) (*t.Account, error)
-	GetAccountByNumber(int) (*int, error)
-	GetAccountByPasswordAndEmail(req *t.LoginRequest) (*t.Account, error)
-	CheckIfEmailExists(email string) (bool, error)
+type PostgresStorage struct {
+	db      *sql.DB
+	numbers t.AccountNumberGenerator
+	schema  schema
 }
 
-type Transaction interface {
-	TranscationTest() (bool, error)
-	Transfer(req *t.TransferRequest) (*t.Transcation, error)
-	TopUpAccount(req *t.TopUpRequest) error
-	GetUserTransactions(acc_num int) ([]*t.Transcation, error)
-	GetTransactions() ([]*t.Transcation, error)
+func NewPostgresStorage() (*PostgresStorage, error) {
+	return OpenPostgresStorage(os.Getenv("POSTGRES_URI"))
 }
 
-type PostgresStorage struct {
-	db *sql.DB
-}
+// OpenPostgresStorage connects to the database at connStr, a postgres://
+// URL or a key=value connection string.
+func OpenPostgresStorage(connStr string) (*PostgresStorage, error) {
 
-func NewPostgresStorage() (*PostgresStorage, error) {
-	connStr := os.Getenv("POSTGRES_URI")
 	db, err := sql.Open("postgres", connStr)
 
 	if err != nil {
@@ -51,144 +38,120 @@ func NewPostgresStorage() (*PostgresStorage, error) {
 		return nil, err
 	}
 
-	return &PostgresStorage{
-		db: db,
-	}, nil
+	s := &PostgresStorage{
+		db:     db,
+		schema: postgresSchema,
+	}
 
-}
+	s.numbers = t.NewSequenceGenerator(func() (int64, error) {
+		var seq int64
+		err := s.db.QueryRow(`SELECT nextval('account_number_seq')`).Scan(&seq)
+		return seq, err
+	})
 
-func (s *PostgresStorage) Init() error {
-
-	return s.CreateAccountTable()
+	return s, nil
 
 }
-func (s *PostgresStorage) CreateAccountTable() error {
-
-	querey := `CREATE TABLE IF NOT EXISTS accounts (
-	id serial,
-	first_name varchar(50),
-	last_name varchar(50),
-	acc_number serial unique,
-	balance money,
-	email varchar(50) ,
-    password varchar(200),
-	created_at timestamp
-  )`
-
-	_, err := s.db.Exec(querey)
-
-	if err != nil {
-		return err
-	}
-
-	querey = `CREATE TABLE IF NOT EXISTS transactions (
-		transaction_id uuid primary key,
-		sen_acc serial references accounts(acc_number),
-		rec_acc serial references accounts(acc_number),
-		amount money,
-		description varchar(80),
-		status varchar(20),
-		date timestamp
-		)`
-
-	_, err = s.db.Exec(querey)
 
-	if err != nil {
-		return err
-	}
+// SetAccountNumberGenerator replaces the generator used by CreateAccount.
+func (s *PostgresStorage) SetAccountNumberGenerator(g t.AccountNumberGenerator) {
+	s.numbers = g
+}
 
-	querey = `CREATE OR REPLACE VIEW transacationview AS
-	SELECT t.transaction_id, t.amount, t.description,t.status,t.date,s.acc_number AS sender_acc,
-	s.first_name AS sender_fn,s.last_name AS sender_ln, s.balance AS sender_balance,s.email AS
-	sender_email,r.acc_number AS receiver_acc, r.first_name AS receiver_fn,r.last_name AS receiver_ln,
-	r.balance AS receiver_balance,r.email AS receiver_email FROM transactions t JOIN accounts s ON t.sen_acc=s.acc_number
-	 JOIN accounts r ON t.rec_acc=r.acc_number`
+// Init brings the schema up to date by applying any pending migrations.
+func (s *PostgresStorage) Init() error {
 
-	_, err = s.db.Exec(querey)
+	return s.MigrateUp()
 
-	return err
 }
 
 func (s *PostgresStorage) GetAccountByPasswordAndEmail(req *t.LoginRequest) (*t.Account, error) {
 
-	rows, err := s.db.Query("select * from accounts where email = $1", req.Email)
+	rows, err := s.db.Query("select "+accountColumns+" from accountview where lower(email) = lower($1) and kind = 'customer'", req.Email)
 
 	if err != nil {
 		return nil, err
 	}
 
-	for rows.Next() {
-		acc, err := scanIntoAccount(rows)
+	defer rows.Close()
 
-		if err != nil {
+	var acc *t.Account
+	if rows.Next() {
+		if acc, err = scanIntoAccount(rows); err != nil {
 			return nil, err
 		}
+	}
 
-		// validating password
-
-		if err := bcrypt.CompareHashAndPassword([]byte(acc.EncryptedPassword), []byte(req.Pasword)); err != nil {
-
-			return nil, fmt.Errorf("invalid password")
-		} else {
-			return acc, nil
-		}
-
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("accounts with email [ %s ] not found", req.Email)
+	return checkPassword(acc, req.Pasword)
 }
 
+// CreateAccount inserts acc. When acc has no account number one is taken
+// from the generator, retrying with a fresh number if it is already used.
 func (s *PostgresStorage) CreateAccount(acc *t.Account) error {
 
-	// begin transaction
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
+	generated := acc.AccountNumber == 0
+	acc.Status = t.AccountActive
 
-	}
-
-	query :=
-		`insert into accounts
-	(first_name, last_name, acc_number, balance, email, password, created_at)
-	values($1,$2,$3,$4,$5,$6,$7)
-	RETURNING id`
+	for attempt := 1; ; attempt++ {
 
-	res, err := tx.Exec(
-		query,
-		acc.FirstName,
-		acc.LastName,
-		acc.AccountNumber,
-		acc.Balance,
-		acc.Email,
-		acc.EncryptedPassword,
-		acc.CreatedAt)
-
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		if generated {
+			number, err := s.numbers.Next()
+			if err != nil {
+				return err
+			}
+			acc.AccountNumber = number
+		}
 
-	i, err := res.RowsAffected()
+		err := s.inTx(func(tx *sql.Tx) error {
+
+			err := tx.QueryRow(
+				`insert into accounts
+			(first_name, last_name, acc_number, currency, email, password, created_at, role)
+			values($1,$2,$3,$4,$5,$6,$7,$8)
+			RETURNING id`,
+				acc.FirstName,
+				acc.LastName,
+				acc.AccountNumber,
+				acc.Balance.Currency,
+				acc.Email,
+				acc.EncryptedPassword,
+				acc.CreatedAt,
+				acc.Role).Scan(&acc.ID)
+			if err != nil {
+				return err
+			}
+
+			event, err := t.NewAccountEvent(t.EventAccountCreated, acc, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+
+			return addOutboxEvent(tx, event)
+		})
+
+		if generated && isUniqueViolation(err, "accounts_acc_number_key") && attempt < maxAccountNumberAttempts {
+			continue
+		}
 
-	if err != nil {
-		return err
-	}
+		if generated && err != nil {
+			acc.AccountNumber = 0
+		}
 
-	if i < 1 {
-		return fmt.Errorf("account not found")
-	}
+		if isUniqueViolation(err, "accounts_email_lower_key") {
+			return ErrEmailInUse
+		}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
 		return err
 	}
-
-	return nil
 }
+
 func (s *PostgresStorage) GetAccounts() ([]*t.Account, error) {
 
-	rows, err := s.db.Query("select * from accounts")
+	rows, err := s.db.Query("select " + accountColumns + " from accountview where kind = 'customer'")
 
 	if err != nil {
 		return nil, err
@@ -204,6 +167,17 @@ func (s *PostgresStorage) GetAccounts() ([]*t.Account, error) {
 
 		accounts = append(accounts, account)
 	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		if err := loadBalances(s.db, account); err != nil {
+			return nil, err
+		}
+	}
+
 	return accounts, nil
 }
 
@@ -215,117 +189,162 @@ func (s *PostgresStorage) GetAccountByNumber(number int) (*int, error) {
 		return nil, err
 	}
 
-	for rows.Next() {
+	defer rows.Close()
 
-		acc_num := 0
-		err := rows.Scan(
-			&acc_num)
+	if rows.Next() {
 
-		if err != nil {
+		acc_num := 0
+		if err := rows.Scan(&acc_num); err != nil {
 			return nil, err
-		} else {
-			return &acc_num, nil
 		}
+
+		return &acc_num, nil
 	}
 
-	return nil, fmt.Errorf("account with acc_number [ %d ] not found", number)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", number)
 }
 
-func (s *PostgresStorage) UpdateAccount(*t.Account) error {
+// UpdateAccount saves the profile fields of acc if its Version still
+// matches the stored one, and bumps the version on success.
+func (s *PostgresStorage) UpdateAccount(acc *t.Account) error {
+
+	// a new email has to be verified again
+	res, err := s.db.Exec(`UPDATE accounts SET first_name = $1, last_name = $2, email = $3, version = version + 1,
+	email_verified_at = CASE WHEN lower(email) = lower($3) THEN email_verified_at END
+	WHERE id = $4 AND version = $5 AND kind = $6`,
+		acc.FirstName, acc.LastName, acc.Email, acc.ID, acc.Version, t.AccountKindCustomer)
+
+	if isUniqueViolation(err, "accounts_email_lower_key") {
+		return ErrEmailInUse
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		if _, err := s.GetAccountByID(acc.ID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
+	}
+
+	acc.Version++
 	return nil
 }
-func (s *PostgresStorage) DeleteAccount(id int) error {
+func (s *PostgresStorage) SetAccountRole(id int, role string) error {
 
-	_, err := s.db.Exec("delete from accounts where id = $1", id)
+	res, err := s.db.Exec(`UPDATE accounts SET role = $1, version = version + 1 WHERE id = $2 AND kind = $3`,
+		role, id, t.AccountKindCustomer)
 
 	if err != nil {
-		return fmt.Errorf("account with id:{ %d } not found", id)
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return t.NotFound("account_not_found", "account %d not found", id)
 	}
 
 	return nil
 }
+
 func (s *PostgresStorage) GetAccountByID(id int) (*t.Account, error) {
 
-	rows, err := s.db.Query("select * from accounts where id = $1", id)
+	rows, err := s.db.Query("select "+accountColumns+" from accountview where id = $1 and kind = 'customer'", id)
 
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
-		return scanIntoAccount(rows)
+		return scanAccountWithBalances(s.db, rows)
 	}
-	defer rows.Close()
-	return nil, fmt.Errorf("account %d not found", id)
+
+	return nil, t.NotFound("account_not_found", "account %d not found", id)
 }
 
-func (s *PostgresStorage) CheckIfEmailExists(email string) (bool, error) {
+func (s *PostgresStorage) GetAccountByAccountNumber(number int64) (*t.Account, error) {
 
-	rows, err := s.db.Query("select email from accounts where email = $1", email)
+	rows, err := s.db.Query("select "+accountColumns+" from accountview where acc_number = $1 and kind = 'customer'", number)
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	account := new(t.Account)
-	for rows.Next() {
-
-		err := rows.Scan(
-			&account.Email,
-		)
+	defer rows.Close()
 
-		if err != nil {
-			return false, nil
-		} else {
-			return true, nil
-		}
+	for rows.Next() {
+		return scanAccountWithBalances(s.db, rows)
 	}
 
-	defer rows.Close()
-
-	return false, nil
+	return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", number)
 }
 
-// transactions
+func (s *PostgresStorage) GetAccountByEmail(email string) (*t.Account, error) {
 
-func (s *PostgresStorage) TranscationTest() (bool, error) {
+	// lower(email) and the kind match accounts_email_lower_key, so this is
+	// an index lookup
+	rows, err := s.db.Query("select "+accountColumns+" from accountview where lower(email) = $1 and kind = 'customer'", email)
 
-	// begin transaction
-	tx, err := s.db.Begin()
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	defer rows.Close()
 
+	for rows.Next() {
+		return scanAccountWithBalances(s.db, rows)
 	}
 
-	name := "DFGHJK"
-	// function
-	res, err := tx.Exec(`delete from accounts where first_name = $1`, name)
+	return nil, t.NotFound("account_not_found", "account not found")
+}
+
+func (s *PostgresStorage) CheckIfEmailExists(email string) (bool, error) {
+
+	rows, err := s.db.Query("select email from accounts where lower(email) = lower($1) and kind = $2", email, t.AccountKindCustomer)
 
 	if err != nil {
-		tx.Rollback()
 		return false, err
 	}
 
-	r, _ := res.RowsAffected()
+	defer rows.Close()
 
-	if r < 1 {
-		fmt.Println(r)
-		return false, fmt.Errorf("account not found")
+	if rows.Next() {
+		return true, nil
 	}
 
-	// commit the transaction
-	err = tx.Commit()
+	return false, rows.Err()
+}
 
-	if err != nil {
-		return false, err
-	}
+func (s *PostgresStorage) HasAccountWithRole(role string) (bool, error) {
+
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM accounts WHERE role = $1 AND kind = $2`, role, t.AccountKindCustomer).Scan(&n)
+
+	return n > 0, err
+}
+
+// LegacyAccountNumbers relies on CreateAccount only handing out numbers
+// with check digits since 0009_account_numbers, so every shorter number
+// there is was handed out before.
+func (s *PostgresStorage) LegacyAccountNumbers() (int64, error) {
 
-	return true, nil
+	var max int64
+	err := s.db.QueryRow(`SELECT COALESCE(max(acc_number), 0) FROM accounts WHERE acc_number > 0 AND acc_number < $1`,
+		t.MinAccountNumber).Scan(&max)
 
+	return max, err
 }
 
+// transactions
+
 func (s *PostgresStorage) GetTransactiobById(id *string) (*t.Transcation, error) {
 
-	rows, err := s.db.Query(`select * from transacationview where transaction_id = $1`, id)
+	rows, err := s.db.Query("select "+transactionColumns+" from transacationview where transaction_id = $1", id)
 
 	if err != nil {
 		return nil, err
@@ -342,185 +361,365 @@ func (s *PostgresStorage) GetTransactiobById(id *string) (*t.Transcation, error)
 		}
 	}
 
-	return nil, fmt.Errorf("transaction with id [ %d ] not found", id)
+	return nil, t.NotFound("transaction_not_found", "transaction with id [ %d ] not found", id)
 }
 func (s *PostgresStorage) Transfer(req *t.TransferRequest) (*t.Transcation, error) {
 
-	// begin transaction
-	tx, err := s.db.Begin()
-	if err != nil {
-		fmt.Println("1")
+	if err := checkAmount(req.Amount); err != nil {
 		return nil, err
-
 	}
 
-	amount, err := strconv.ParseFloat(req.Amount, 64)
+	var transaction *t.Transcation
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		transaction, err = transfer(tx, req)
+		return err
+	})
+
 	if err != nil {
-		fmt.Println("2")
 		return nil, err
 	}
 
-	// remove from sender account
-	res, err := tx.Exec(`UPDATE accounts SET balance = balance - $1 WHERE acc_number = $2 AND balance > $1`, amount, req.FromAccount)
+	id := transaction.Id.String()
+	return s.GetTransactiobById(&id)
 
+}
+
+// transfer makes the transfer of req inside tx.
+func transfer(tx *sql.Tx, req *t.TransferRequest) (*t.Transcation, error) {
+
+	// both rows are locked, always in account number order, so two
+	// transfers cannot both spend the same balance and opposite
+	// transfers between the same accounts cannot deadlock
+	accounts, err := lockCustomerAccounts(tx, req.FromAccount, req.ToAccount)
 	if err != nil {
-		fmt.Println("3")
-		tx.Rollback()
 		return nil, err
 	}
 
-	r, err := res.RowsAffected()
+	from, to := accounts[0], accounts[1]
+
+	transaction, entry, err := newTransfer(req, "Bank Transfer", from, to, func(base, quote string) (*t.FXRate, error) {
+		return readFXRate(tx, base, quote)
+	})
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	if r < 1 {
-		fmt.Println("4")
-		tx.Rollback()
-		return nil, fmt.Errorf("insufficient fund or invalid accound number")
+	if err := addTransaction(tx, transaction); err != nil {
+		return nil, err
 	}
 
-	// add to receiver account
-	res, err = tx.Exec(`UPDATE accounts SET balance = $1 + balance WHERE acc_number = $2`, amount, req.ToAccount)
-
-	if err != nil {
-		fmt.Println("6")
-		tx.Rollback()
+	if err := postJournalEntry(tx, entry); err != nil {
 		return nil, err
 	}
 
-	r, err = res.RowsAffected()
-
+	event, err := t.NewTransactionEvent(t.EventTransferCompleted, transaction)
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	fmt.Println(r)
+	return transaction, addOutboxEvent(tx, event)
+}
+
+func (s *PostgresStorage) TopUpAccount(req *t.TopUpRequest) error {
 
-	if r < 1 {
-		fmt.Println(r)
-		tx.Rollback()
-		return nil, fmt.Errorf("something went wrong")
+	if err := checkAmount(req.Amount); err != nil {
+		return err
 	}
 
-	transaction, err := t.NewTransaction(&req.FromAccount, &req.ToAccount, req.Amount, "Credit", "Bank Transfer")
+	return s.inTx(func(tx *sql.Tx) error {
+
+		accounts, err := lockCustomerAccounts(tx, req.Account)
+		if err != nil {
+			return err
+		}
+		acc := accounts[0]
+
+		if err := acc.CanCredit(); err != nil {
+			return err
+		}
+
+		// top ups are funded by the top up system account, so the money a
+		// customer receives always has a traceable origin in the ledger
+		topUp := int(t.SystemAccountTopUp)
+		transaction, err := t.NewTransaction(&topUp, &req.Account, req.Amount, "Credit", "Top Up")
+		if err != nil {
+			return err
+		}
+
+		if err := addTransaction(tx, transaction); err != nil {
+			return err
+		}
+
+		entry, err := t.NewTransferEntry(t.EntryKindTopUp, transaction.Description, &transaction.Id,
+			t.SystemAccountTopUp, acc.AccountNumber, req.Amount)
+		if err != nil {
+			return err
+		}
+
+		if err := postJournalEntry(tx, entry); err != nil {
+			return err
+		}
+
+		event, err := t.NewTransactionEvent(t.EventTopUpCompleted, transaction)
+		if err != nil {
+			return err
+		}
+
+		return addOutboxEvent(tx, event)
+	})
+}
+
+func (s *PostgresStorage) GetJournalEntries(acc_num int) ([]*t.JournalEntry, error) {
+
+	rows, err := s.db.Query(`SELECT e.id, e.transaction_id, e.kind, e.description, e.created_at, p.acc_number, p.amount, p.currency
+	FROM journal_entries e JOIN postings p ON p.entry_id = e.id
+	WHERE e.id IN (SELECT entry_id FROM postings WHERE acc_number = $1)
+	ORDER BY e.created_at, e.id, p.id`, acc_num)
+
 	if err != nil {
-		fmt.Println("9")
-		tx.Rollback()
 		return nil, err
 	}
+	defer rows.Close()
+
+	entries := []*t.JournalEntry{}
+	var last *t.JournalEntry
+	for rows.Next() {
 
-	id, err := s.AddTransaction(transaction)
+		entry := new(t.JournalEntry)
+		var transactionId uuid.NullUUID
+		var description sql.NullString
+		var p t.Posting
 
-	if err != nil {
-		fmt.Println("10")
-		tx.Rollback()
-		return nil, err
+		err := rows.Scan(
+			&entry.Id,
+			&transactionId,
+			&entry.Kind,
+			&description,
+			&entry.CreatedAt,
+			&p.Account,
+			&p.Amount.Amount,
+			&p.Amount.Currency,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if last == nil || last.Id != entry.Id {
+			if transactionId.Valid {
+				entry.TransactionId = &transactionId.UUID
+			}
+			entry.Description = description.String
+			entries = append(entries, entry)
+			last = entry
+		}
+
+		last.Postings = append(last.Postings, p)
 	}
 
-	// commit the transaction
-	err = tx.Commit()
-	if err != nil {
-		fmt.Println("12")
-		tx.Rollback()
-		return nil, err
+	return entries, rows.Err()
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// lockCustomerAccounts locks the rows of the given customer accounts with
+// SELECT ... FOR UPDATE, in ascending account number order whatever order
+// they were asked for in, and returns them in the order asked for.
+func lockCustomerAccounts(tx *sql.Tx, numbers ...int) ([]*t.Account, error) {
+
+	ordered := append([]int(nil), numbers...)
+	sort.Ints(ordered)
+
+	for _, number := range ordered {
+		if _, err := tx.Exec(`SELECT 1 FROM accounts WHERE acc_number = $1 AND kind = $2 FOR UPDATE`,
+			number, t.AccountKindCustomer); err != nil {
+			return nil, err
+		}
+	}
+
+	accounts := make([]*t.Account, 0, len(numbers))
+	for _, number := range numbers {
+		acc, err := readCustomerAccount(tx, number)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
 	}
 
-	t, err := s.GetTransactiobById(id)
+	return accounts, nil
+}
+
+func readCustomerAccount(tx *sql.Tx, number int) (*t.Account, error) {
 
+	rows, err := tx.Query("SELECT "+accountColumns+" FROM accountview WHERE acc_number = $1 AND kind = $2",
+		number, t.AccountKindCustomer)
 	if err != nil {
-		fmt.Println("11")
-		tx.Rollback()
 		return nil, err
 	}
+	defer rows.Close()
 
-	return t, nil
+	for rows.Next() {
+		return scanAccountWithBalances(tx, rows)
+	}
 
+	return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", number)
 }
 
-func (s *PostgresStorage) AddTransaction(t *t.Transcation) (*string, error) {
+func addTransaction(db execer, t *t.Transcation) error {
 
 	query := `
 	INSERT INTO transactions
-	(transaction_id,sen_acc,rec_acc,amount,description,status,date)
-	VALUES($1,$2,$3,$4,$5,$6,$7)
-    RETURNING transaction_id`
+	(transaction_id,sen_acc,rec_acc,amount,currency,credit_amount,credit_currency,fx_rate,original_id,description,status,date)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`
+
+	var creditAmount sql.NullInt64
+	var creditCurrency, fxRate sql.NullString
+	if t.Converted != nil {
+		creditAmount = sql.NullInt64{Int64: t.Converted.Amount, Valid: true}
+		creditCurrency = sql.NullString{String: t.Converted.Currency, Valid: true}
+		fxRate = sql.NullString{String: t.FXRate, Valid: true}
+	}
 
-	row, err := s.db.Query(
+	_, err := db.Exec(
 		query,
 		t.Id,
 		t.Sen_acc.AccountNumber,
 		t.Rec_acc.AccountNumber,
-		t.Amount,
+		t.Amount.Amount,
+		t.Amount.Currency,
+		creditAmount,
+		creditCurrency,
+		fxRate,
+		t.OriginalId,
 		t.Description,
 		t.Status,
 		t.Date)
 
-	if err != nil {
-		return nil, err
+	return err
+}
+
+// postJournalEntry writes a validated entry and its postings. It must run
+// inside the same transaction as the business change it records.
+func postJournalEntry(db execer, entry *t.JournalEntry) error {
+
+	if err := entry.Validate(); err != nil {
+		return err
 	}
 
-	var id string
-	for row.Next() {
+	_, err := db.Exec(`INSERT INTO journal_entries (id, transaction_id, kind, description, created_at)
+	VALUES ($1,$2,$3,$4,$5)`, entry.Id, entry.TransactionId, entry.Kind, entry.Description, entry.CreatedAt)
 
-		err := row.Scan(
-			&id,
-		)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return nil, err
-		} else {
-			return &id, nil
+	for _, p := range entry.Postings {
+		_, err := db.Exec(`INSERT INTO postings (entry_id, acc_number, amount, currency) VALUES ($1,$2,$3,$4)`,
+			entry.Id, p.Account, p.Amount.Amount, p.Amount.Currency)
 
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil, err
+	return nil
 }
-func (s *PostgresStorage) TopUpAccount(req *t.TopUpRequest) error {
 
-	// begin transaction
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
-	}
+func (s *PostgresStorage) GetUserTransactions(acc_num int) ([]*t.Transcation, error) {
 
 	// function
-	res, err := tx.Exec(`UPDATE accounts SET balance = balance + $1 WHERE acc_number = $2`, req.Amount, req.Account)
+	rows, err := s.db.Query("SELECT "+transactionColumns+" FROM transacationview WHERE sender_acc = $1 OR receiver_acc = $1", acc_num)
 
 	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf(tx.Rollback().Error())
+		return nil, err
+	}
+
+	transactions := []*t.Transcation{}
+	for rows.Next() {
+
+		transcation, err := scanIntoTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		transactions = append(transactions, transcation)
 	}
+	return transactions, nil
+}
 
-	r, _ := res.RowsAffected()
+func (s *PostgresStorage) ListUserTransactions(acc_num int, filter *t.TransactionFilter) (*t.TransactionPage, error) {
 
-	if r < 1 {
-		fmt.Println(r)
-		return fmt.Errorf("account not found")
+	args := []any{acc_num}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
 	}
 
-	// commit the transaction
-	err = tx.Commit()
+	where := []string{}
+	switch filter.Direction {
+	case t.DirectionSent:
+		where = append(where, "sender_acc = $1")
+	case t.DirectionReceived:
+		where = append(where, "receiver_acc = $1")
+	default:
+		where = append(where, "(sender_acc = $1 OR receiver_acc = $1)")
+	}
 
-	if err != nil {
-		return err
+	if filter.Counterparty != 0 {
+		cp := arg(filter.Counterparty)
+		where = append(where, "((sender_acc = $1 AND receiver_acc = "+cp+") OR (receiver_acc = $1 AND sender_acc = "+cp+"))")
 	}
 
-	return nil
+	if !filter.From.IsZero() {
+		where = append(where, "date >= "+arg(filter.From))
+	}
 
-}
+	if !filter.To.IsZero() {
+		where = append(where, "date < "+arg(filter.To))
+	}
 
-func (s *PostgresStorage) GetUserTransactions(acc_num int) ([]*t.Transcation, error) {
+	if filter.Status != "" {
+		where = append(where, "status = "+arg(filter.Status))
+	}
 
-	// function
-	rows, err := s.db.Query(`SELECT * FROM transacationview WHERE sender_acc = $1 OR receiver_acc = $1 `, acc_num)
+	// amounts are compared with the leg the account took part in, which
+	// for the receiver of a conversion is the converted amount
+	if filter.MinAmount != nil || filter.MaxAmount != nil {
+		leg := func(amount, currency string) string {
+			conds := []string{}
+			if filter.MinAmount != nil {
+				conds = append(conds, currency+" = "+arg(filter.MinAmount.Currency), amount+" >= "+arg(filter.MinAmount.Amount))
+			}
+			if filter.MaxAmount != nil {
+				conds = append(conds, currency+" = "+arg(filter.MaxAmount.Currency), amount+" <= "+arg(filter.MaxAmount.Amount))
+			}
+			return strings.Join(conds, " AND ")
+		}
 
+		where = append(where, "((sender_acc = $1 AND "+leg("amount", "currency")+") OR (receiver_acc = $1 AND "+
+			leg("COALESCE(credit_amount, amount)", "COALESCE(credit_currency, currency)")+"))")
+	}
+
+	if filter.After != nil {
+		where = append(where, "(date, transaction_id) < ("+arg(filter.After.Date)+", "+arg(filter.After.Id)+")")
+	}
+
+	limit := pageLimit(filter)
+	query := "SELECT " + transactionColumns + " FROM transacationview WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY date DESC, transaction_id DESC LIMIT " + arg(limit+1)
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	transactions := []*t.Transcation{}
 	for rows.Next() {
@@ -532,12 +731,17 @@ func (s *PostgresStorage) GetUserTransactions(acc_num int) ([]*t.Transcation, er
 
 		transactions = append(transactions, transcation)
 	}
-	return transactions, nil
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newTransactionPage(transactions, limit), nil
 }
 
 func (s *PostgresStorage) GetTransactions() ([]*t.Transcation, error) {
 
-	rows, err := s.db.Query("select * from transacationview")
+	rows, err := s.db.Query("select " + transactionColumns + " from transacationview")
 
 	if err != nil {
 		return nil, err
@@ -563,6 +767,22 @@ func DropTable(db *sql.DB, n string) error {
 	return nil
 }
 
+const accountColumns = `id, first_name, last_name, acc_number, balance, currency, email, password, created_at, version, role,
+	status, status_reason, status_changed_at, email_verified_at, password_changed_at`
+
+const transactionColumns = `transaction_id, amount, currency, credit_amount, credit_currency, fx_rate,
+	refunded_amount, original_id, description, status, date,
+	sender_acc, sender_fn, sender_ln, sender_balance, sender_currency, sender_email,
+	receiver_acc, receiver_fn, receiver_ln, receiver_balance, receiver_currency, receiver_email`
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && pqErr.Constraint == constraint
+	}
+	return isSQLiteUniqueViolation(err, constraint)
+}
+
 func scanIntoAccount(rows *sql.Rows) (*t.Account, error) {
 
 	account := new(t.Account)
@@ -571,39 +791,73 @@ func scanIntoAccount(rows *sql.Rows) (*t.Account, error) {
 		&account.FirstName,
 		&account.LastName,
 		&account.AccountNumber,
-		&account.Balance,
+		&account.Balance.Amount,
+		&account.Balance.Currency,
 		&account.Email,
 		&account.EncryptedPassword,
 		&account.CreatedAt,
+		&account.Version,
+		&account.Role,
+		&account.Status,
+		&account.StatusReason,
+		&account.StatusChangedAt,
+		&account.EmailVerifiedAt,
+		&account.PasswordChangedAt,
 	)
 
 	return account, err
 
 }
 
+// scanAccountWithBalances scans the current row and, once rows is closed,
+// loads the account's sub-balances through db.
+func scanAccountWithBalances(db execer, rows *sql.Rows) (*t.Account, error) {
+
+	account, err := scanIntoAccount(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return account, loadBalances(db, account)
+}
+
 func scanIntoTransaction(rows *sql.Rows) (*t.Transcation, error) {
 	s := new(t.Account)
 	r := new(t.Account)
 	tran := new(t.Transcation)
 
-	// s.Balance = sb
-	// r.Balance = rb
+	var creditAmount sql.NullInt64
+	var creditCurrency, fxRate sql.NullString
+	var refunded int64
+	var originalId uuid.NullUUID
 
 	err := rows.Scan(
 		&tran.Id,
-		&tran.Amount,
+		&tran.Amount.Amount,
+		&tran.Amount.Currency,
+		&creditAmount,
+		&creditCurrency,
+		&fxRate,
+		&refunded,
+		&originalId,
 		&tran.Description,
 		&tran.Status,
 		&tran.Date,
 		&s.AccountNumber,
 		&s.FirstName,
 		&s.LastName,
-		&s.Balance,
+		&s.Balance.Amount,
+		&s.Balance.Currency,
 		&s.Email,
 		&r.AccountNumber,
 		&r.FirstName,
 		&r.LastName,
-		&r.Balance,
+		&r.Balance.Amount,
+		&r.Balance.Currency,
 		&r.Email,
 	)
 
@@ -611,13 +865,24 @@ func scanIntoTransaction(rows *sql.Rows) (*t.Transcation, error) {
 		return nil, err
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
 	tran.Sen_acc = *s
 	tran.Rec_acc = *r
 
+	if creditAmount.Valid {
+		converted := t.NewMoney(creditAmount.Int64, creditCurrency.String)
+		tran.Converted = &converted
+		tran.FXRate = trimDecimal(fxRate.String)
+	}
+
+	if refunded != 0 {
+		amount := t.NewMoney(refunded, tran.Credited().Currency)
+		tran.Refunded = &amount
+	}
+
+	if originalId.Valid {
+		tran.OriginalId = &originalId.UUID
+	}
+
 	return tran, err
 
 }
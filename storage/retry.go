@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Transactions that lose a serialization conflict or are picked as a
+// deadlock victim are run again, up to maxTxAttempts times in total.
+const (
+	maxTxAttempts = 5
+	txRetryDelay  = 10 * time.Millisecond
+)
+
+// inTx runs fn in a transaction and commits it. When postgres aborts the
+// transaction because of a serialization failure or a deadlock, the whole
+// of fn is retried after a jittered exponential backoff; fn must therefore
+// not have side effects outside tx.
+func (s *PostgresStorage) inTx(fn func(tx *sql.Tx) error) error {
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+
+		if err = s.runTx(fn); !isRetryable(err) {
+			return err
+		}
+
+		if attempt < maxTxAttempts {
+			time.Sleep(retryDelay(attempt))
+		}
+	}
+
+	return err
+}
+
+func (s *PostgresStorage) runTx(fn func(tx *sql.Tx) error) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isRetryable reports whether err is a serialization_failure (40001) or a
+// deadlock_detected (40P01) error, or SQLite gave up waiting for a lock.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return isSQLiteBusy(err)
+}
+
+// retryDelay doubles with every attempt and adds up to as much again in
+// jitter, so transactions that collided once do not collide again.
+func retryDelay(attempt int) time.Duration {
+	d := txRetryDelay << (attempt - 1)
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) GetTransaction(id uuid.UUID) (*t.Transcation, error) {
+	return readTransaction(s.db, id)
+}
+
+func (s *PostgresStorage) ReverseTransaction(id uuid.UUID) (*t.Transcation, error) {
+	return s.compensate(id, nil, true)
+}
+
+func (s *PostgresStorage) RefundTransaction(id uuid.UUID, amount *t.Money) (*t.Transcation, error) {
+	return s.compensate(id, amount, false)
+}
+
+func (s *PostgresStorage) compensate(id uuid.UUID, amount *t.Money, reversal bool) (*t.Transcation, error) {
+
+	var comp *t.Transcation
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		// the original is locked before the receiver's account, so two
+		// refunds of the same transaction queue up instead of both giving
+		// back the same remainder
+		if _, err := tx.Exec(`SELECT 1 FROM transactions WHERE transaction_id = $1 FOR UPDATE`, id); err != nil {
+			return err
+		}
+
+		tran, err := readTransaction(tx, id)
+		if err != nil {
+			return err
+		}
+
+		// top ups are reversed into a system account, which is never closed
+		numbers := []int{int(tran.Rec_acc.AccountNumber)}
+		if tran.Sen_acc.AccountNumber > 0 {
+			numbers = append(numbers, int(tran.Sen_acc.AccountNumber))
+		}
+
+		accounts, err := lockCustomerAccounts(tx, numbers...)
+		if err != nil {
+			return err
+		}
+
+		var sender *t.Account
+		if len(accounts) > 1 {
+			sender = accounts[1]
+		}
+
+		var entry *t.JournalEntry
+		comp, entry, err = newCompensation(tran, accounts[0], sender, amount, reversal)
+		if err != nil {
+			return err
+		}
+
+		if err := addTransaction(tx, comp); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE transactions SET status = $1, refunded_amount = $2 WHERE transaction_id = $3`,
+			tran.Status, tran.Refunded.Amount, tran.Id); err != nil {
+			return err
+		}
+
+		if err := postJournalEntry(tx, entry); err != nil {
+			return err
+		}
+
+		event, err := t.CompensationEvent(comp)
+		if err != nil {
+			return err
+		}
+
+		return addOutboxEvent(tx, event)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readTransaction(s.db, comp.Id)
+}
+
+func readTransaction(db execer, id uuid.UUID) (*t.Transcation, error) {
+
+	rows, err := db.Query("SELECT "+transactionColumns+" FROM transacationview WHERE transaction_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTransaction(rows)
+	}
+
+	return nil, t.NotFound("transaction_not_found", "transaction %s not found", id)
+}
+
+func (s *MemoryStorage) GetTransaction(id uuid.UUID) (*t.Transcation, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tran := s.transactionByID(id)
+	if tran == nil {
+		return nil, t.NotFound("transaction_not_found", "transaction %s not found", id)
+	}
+
+	return s.viewTransaction(tran), nil
+}
+
+func (s *MemoryStorage) ReverseTransaction(id uuid.UUID) (*t.Transcation, error) {
+	return s.compensate(id, nil, true)
+}
+
+func (s *MemoryStorage) RefundTransaction(id uuid.UUID, amount *t.Money) (*t.Transcation, error) {
+	return s.compensate(id, amount, false)
+}
+
+func (s *MemoryStorage) compensate(id uuid.UUID, amount *t.Money, reversal bool) (*t.Transcation, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.transactionByID(id)
+	if stored == nil {
+		return nil, t.NotFound("transaction_not_found", "transaction %s not found", id)
+	}
+
+	receiver := s.customerByNumber(stored.Rec_acc.AccountNumber)
+	if receiver == nil {
+		return nil, t.NotFound("account_not_found", "account with acc_number [ %d ] not found", stored.Rec_acc.AccountNumber)
+	}
+
+	var sender *t.Account
+	if acc := s.customerByNumber(stored.Sen_acc.AccountNumber); acc != nil {
+		sender = s.copyAccount(acc)
+	}
+
+	tran := *stored
+	comp, entry, err := newCompensation(&tran, s.copyAccount(receiver), sender, amount, reversal)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := t.CompensationEvent(comp)
+	if err != nil {
+		return nil, err
+	}
+
+	stored.Status = tran.Status
+	stored.Refunded = tran.Refunded
+	s.transactions = append(s.transactions, comp)
+	s.post(entry)
+	s.addOutboxEvent(event)
+
+	return s.viewTransaction(comp), nil
+}
+
+// transactionByID must be called with s.mu held.
+func (s *MemoryStorage) transactionByID(id uuid.UUID) *t.Transcation {
+	for _, tran := range s.transactions {
+		if tran.Id == id {
+			return tran
+		}
+	}
+	return nil
+}
+
+// newCompensation builds the transaction giving tran back, provided the
+// receiver still has the money to give and the sender, which is nil for a
+// system account, can take it. Staff reverse transfers into frozen
+// accounts, so only refunds are held back by the receiver's status.
+func newCompensation(tran *t.Transcation, receiver, sender *t.Account, amount *t.Money, reversal bool) (*t.Transcation, *t.JournalEntry, error) {
+
+	if !reversal {
+		if err := receiver.CanDebit(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if sender != nil {
+		if err := sender.CanCredit(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	comp, entry, err := t.Compensate(tran, amount, reversal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if receiver.AvailableIn(comp.Amount.Currency).Amount < comp.Amount.Amount {
+		return nil, nil, t.InsufficientFunds("account %d no longer has the funds to give back", receiver.AccountNumber)
+	}
+
+	return comp, entry, nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	t "github.com/mrkhay/gobank/type"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStorage keeps the bank in a single SQLite file, for deployments
+// that run as one process without a database server. It runs the same
+// queries as PostgresStorage; the sqliteDriver rewrites them for SQLite.
+// Its schema comes from migrations/sqlite, which has a migration for every
+// postgres one.
+//
+// Row locks do not exist in SQLite. Every transaction takes the database
+// write lock when it begins instead, so transactions never interleave.
+type SQLiteStorage struct {
+	*PostgresStorage
+}
+
+// sqliteDriverName is the database/sql driver that rewrites queries before
+// handing them to SQLite.
+const sqliteDriverName = "gobank-sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, sqliteDriver{})
+}
+
+// NewSQLiteStorage opens the database file at path, creating it if needed.
+// The file needs SQLite 3.35 or later, which the bundled driver provides,
+// for RETURNING.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+
+	// WAL lets readers carry on while a transaction writes; _txlock makes
+	// every transaction take the write lock up front, which is what the
+	// FOR UPDATE of the postgres queries achieved
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_txlock", "immediate")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open(sqliteDriverName, "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStorage{&PostgresStorage{db: db, schema: sqliteSchema}}
+
+	s.numbers = t.NewSequenceGenerator(func() (int64, error) {
+		var seq int64
+		err := s.db.QueryRow(`UPDATE sequences SET value = value + 1 WHERE name = 'account_number_seq' RETURNING value`).Scan(&seq)
+		return seq, err
+	})
+
+	return s, nil
+}
+
+// Close closes the database file.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+var (
+	sqlitePlaceholder = regexp.MustCompile(`\$(\d+)`)
+	sqliteRowLock     = regexp.MustCompile(`(?i)\s+FOR\s+UPDATE(\s+SKIP\s+LOCKED)?`)
+)
+
+// sqliteQuery rewrites a postgres query for SQLite: $n placeholders become
+// ?n, row locks are dropped, and casts and types SQLite lacks are replaced.
+func sqliteQuery(query string) string {
+
+	query = sqlitePlaceholder.ReplaceAllString(query, "?$1")
+	query = sqliteRowLock.ReplaceAllString(query, "")
+	query = strings.ReplaceAll(query, "::bigint", "")
+
+	// the driver only turns columns declared as timestamp back into
+	// time.Time
+	return strings.ReplaceAll(query, "timestamptz", "timestamp")
+}
+
+// sqliteArgs stores times in UTC, so that comparing them as text, which is
+// how SQLite compares them, also compares them in time.
+func sqliteArgs(args []driver.NamedValue) []driver.NamedValue {
+
+	for i, arg := range args {
+		if at, ok := arg.Value.(time.Time); ok {
+			args[i].Value = at.UTC()
+		}
+	}
+
+	return args
+}
+
+type sqliteDriver struct{}
+
+func (sqliteDriver) Open(name string) (driver.Conn, error) {
+
+	conn, err := (&sqlite.Driver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteConn{conn.(sqliteInnerConn)}, nil
+}
+
+// sqliteInnerConn is what the connections of the sqlite driver implement.
+type sqliteInnerConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type sqliteConn struct {
+	inner sqliteInnerConn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.inner.Prepare(sqliteQuery(query))
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.inner.PrepareContext(ctx, sqliteQuery(query))
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.inner.ExecContext(ctx, sqliteQuery(query), sqliteArgs(args))
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.inner.QueryContext(ctx, sqliteQuery(query), sqliteArgs(args))
+}
+
+func (c *sqliteConn) Begin() (driver.Tx, error) {
+	return c.inner.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.inner.BeginTx(ctx, opts)
+}
+
+func (c *sqliteConn) Ping(ctx context.Context) error {
+	return c.inner.Ping(ctx)
+}
+
+func (c *sqliteConn) Close() error {
+	return c.inner.Close()
+}
+
+// sqliteUniqueColumns maps the postgres names of the unique constraints
+// the code looks for to what SQLite names instead: the column, or the
+// index when it is on an expression.
+var sqliteUniqueColumns = map[string]string{
+	"accounts_acc_number_key":      "accounts.acc_number",
+	"accounts_email_lower_key":     "index 'accounts_email_lower_key'",
+	"standing_order_runs_paid_key": "standing_order_runs.order_id, standing_order_runs.run",
+}
+
+func isSQLiteUniqueViolation(err error, constraint string) bool {
+
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code()&0xff != sqlite3.SQLITE_CONSTRAINT {
+		return false
+	}
+
+	column, ok := sqliteUniqueColumns[constraint]
+	return ok && strings.Contains(sqliteErr.Error(), "UNIQUE constraint failed: "+column)
+}
+
+// isSQLiteBusy reports whether SQLite gave up waiting for another
+// connection's lock.
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY || sqliteErr.Code()&0xff == sqlite3.SQLITE_LOCKED)
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+const standingOrderColumns = `id, acc_number, to_acc_number, amount, currency, description, frequency,
+	start_at, end_date, max_runs, runs_done, next_run_at, attempts, last_error, status, locked_until,
+	created_at, updated_at, version`
+
+func (s *PostgresStorage) CreateStandingOrder(o *t.StandingOrder) error {
+
+	_, err := s.db.Exec(`INSERT INTO standing_orders (`+standingOrderColumns+`)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19)`,
+		o.Id, o.Account, o.ToAccount, o.Amount.Amount, o.Amount.Currency, o.Description, o.Frequency,
+		o.StartAt, o.EndDate, o.MaxRuns, o.RunsDone, o.NextRunAt, o.Attempts, o.LastError, o.Status, o.LockedUntil,
+		o.CreatedAt, o.UpdatedAt, o.Version)
+
+	return err
+}
+
+func (s *PostgresStorage) GetStandingOrders(account int64) ([]*t.StandingOrder, error) {
+
+	rows, err := s.db.Query(`SELECT `+standingOrderColumns+` FROM standing_orders
+	WHERE acc_number = $1 ORDER BY created_at`, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStandingOrders(rows)
+}
+
+func (s *PostgresStorage) GetStandingOrder(id uuid.UUID) (*t.StandingOrder, error) {
+
+	rows, err := s.db.Query(`SELECT `+standingOrderColumns+` FROM standing_orders WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := scanStandingOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, t.NotFound("standing_order_not_found", "standing order %s not found", id)
+	}
+
+	return orders[0], nil
+}
+
+// UpdateStandingOrder saves the schedule, status and lock of o if its
+// Version still matches the stored one, and bumps the version on success.
+func (s *PostgresStorage) UpdateStandingOrder(o *t.StandingOrder) error {
+
+	res, err := s.db.Exec(`UPDATE standing_orders SET amount = $1, currency = $2, description = $3,
+	end_date = $4, max_runs = $5, runs_done = $6, next_run_at = $7, attempts = $8, last_error = $9,
+	status = $10, locked_until = $11, updated_at = $12, version = version + 1 WHERE id = $13 AND version = $14`,
+		o.Amount.Amount, o.Amount.Currency, o.Description, o.EndDate, o.MaxRuns, o.RunsDone, o.NextRunAt,
+		o.Attempts, o.LastError, o.Status, o.LockedUntil, o.UpdatedAt, o.Id, o.Version)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		if _, err := s.GetStandingOrder(o.Id); err != nil {
+			return err
+		}
+		return ErrStandingOrderConflict
+	}
+
+	o.Version++
+	return nil
+}
+
+// ClaimDueStandingOrders locks up to limit active orders that are due at
+// now for lease, so that several scheduler instances never run the same
+// order at once. An instance that dies leaves its orders to be claimed
+// again once the lease is over.
+func (s *PostgresStorage) ClaimDueStandingOrders(now time.Time, lease time.Duration, limit int) ([]*t.StandingOrder, error) {
+
+	rows, err := s.db.Query(`UPDATE standing_orders SET locked_until = $2
+	WHERE id IN (
+		SELECT id FROM standing_orders
+		WHERE status = $3 AND next_run_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+		ORDER BY next_run_at LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	) RETURNING `+standingOrderColumns, now, now.Add(lease), t.StandingOrderActive, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStandingOrders(rows)
+}
+
+func (s *PostgresStorage) AddStandingOrderRun(run *t.StandingOrderRun) error {
+	return addStandingOrderRun(s.db, run)
+}
+
+func addStandingOrderRun(db execer, run *t.StandingOrderRun) error {
+
+	_, err := db.Exec(`INSERT INTO standing_order_runs (id, order_id, run, attempt, transaction_id, error, created_at)
+	VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		run.Id, run.OrderId, run.Run, run.Attempt, run.TransactionId, run.Error, run.CreatedAt)
+
+	return err
+}
+
+func (s *PostgresStorage) PayStandingOrderRun(run *t.StandingOrderRun, req *t.TransferRequest) (*t.Transcation, error) {
+
+	if err := checkAmount(req.Amount); err != nil {
+		return nil, err
+	}
+
+	var transaction *t.Transcation
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		// the run row is written by the same transaction as the transfer,
+		// and standing_order_runs_paid_key lets only one of two schedulers
+		// racing for the same run commit
+		var paid bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM standing_order_runs
+		WHERE order_id = $1 AND run = $2 AND transaction_id IS NOT NULL)`, run.OrderId, run.Run).Scan(&paid)
+		if err != nil {
+			return err
+		}
+
+		if paid {
+			return ErrStandingOrderRunPaid
+		}
+
+		transaction, err = transfer(tx, req)
+		if err != nil {
+			return err
+		}
+
+		run.TransactionId = &transaction.Id
+		return addStandingOrderRun(tx, run)
+	})
+
+	if err != nil {
+		run.TransactionId = nil
+	}
+
+	if isUniqueViolation(err, "standing_order_runs_paid_key") {
+		return nil, ErrStandingOrderRunPaid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := transaction.Id.String()
+	return s.GetTransactiobById(&id)
+}
+
+func (s *PostgresStorage) GetStandingOrderRuns(id uuid.UUID) ([]*t.StandingOrderRun, error) {
+
+	rows, err := s.db.Query(`SELECT id, order_id, run, attempt, transaction_id, error, created_at
+	FROM standing_order_runs WHERE order_id = $1 ORDER BY created_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*t.StandingOrderRun{}
+	for rows.Next() {
+		run := &t.StandingOrderRun{}
+		if err := rows.Scan(&run.Id, &run.OrderId, &run.Run, &run.Attempt, &run.TransactionId, &run.Error, &run.CreatedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+func scanStandingOrders(rows *sql.Rows) ([]*t.StandingOrder, error) {
+
+	defer rows.Close()
+
+	orders := []*t.StandingOrder{}
+	for rows.Next() {
+		o := &t.StandingOrder{}
+		err := rows.Scan(
+			&o.Id, &o.Account, &o.ToAccount, &o.Amount.Amount, &o.Amount.Currency, &o.Description, &o.Frequency,
+			&o.StartAt, &o.EndDate, &o.MaxRuns, &o.RunsDone, &o.NextRunAt, &o.Attempts, &o.LastError, &o.Status,
+			&o.LockedUntil, &o.CreatedAt, &o.UpdatedAt, &o.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, rows.Err()
+}
+
+func (s *MemoryStorage) CreateStandingOrder(o *t.StandingOrder) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.customerByNumber(o.Account) == nil {
+		return t.NotFound("account_not_found", "account with acc_number [ %d ] not found", o.Account)
+	}
+
+	s.standingOrders[o.Id] = copyStandingOrder(o)
+	return nil
+}
+
+func (s *MemoryStorage) GetStandingOrders(account int64) ([]*t.StandingOrder, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := []*t.StandingOrder{}
+	for _, o := range s.standingOrders {
+		if o.Account == account {
+			orders = append(orders, copyStandingOrder(o))
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+
+func (s *MemoryStorage) GetStandingOrder(id uuid.UUID) (*t.StandingOrder, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.standingOrders[id]
+	if !ok {
+		return nil, t.NotFound("standing_order_not_found", "standing order %s not found", id)
+	}
+
+	return copyStandingOrder(o), nil
+}
+
+func (s *MemoryStorage) UpdateStandingOrder(o *t.StandingOrder) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.standingOrders[o.Id]
+	if !ok {
+		return t.NotFound("standing_order_not_found", "standing order %s not found", o.Id)
+	}
+
+	if stored.Version != o.Version {
+		return ErrStandingOrderConflict
+	}
+
+	o.Version++
+	s.standingOrders[o.Id] = copyStandingOrder(o)
+	return nil
+}
+
+func (s *MemoryStorage) ClaimDueStandingOrders(now time.Time, lease time.Duration, limit int) ([]*t.StandingOrder, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*t.StandingOrder{}
+	for _, o := range s.standingOrders {
+		if o.Status == t.StandingOrderActive && !o.NextRunAt.After(now) && (o.LockedUntil == nil || o.LockedUntil.Before(now)) {
+			due = append(due, o)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].NextRunAt.Before(due[j].NextRunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	until := now.Add(lease)
+	for i, o := range due {
+		o.LockedUntil = &until
+		due[i] = copyStandingOrder(o)
+	}
+
+	return due, nil
+}
+
+func (s *MemoryStorage) AddStandingOrderRun(run *t.StandingOrderRun) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *run
+	s.standingOrderRuns = append(s.standingOrderRuns, &c)
+	return nil
+}
+
+func (s *MemoryStorage) PayStandingOrderRun(run *t.StandingOrderRun, req *t.TransferRequest) (*t.Transcation, error) {
+
+	if err := checkAmount(req.Amount); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.standingOrderRuns {
+		if r.OrderId == run.OrderId && r.Run == run.Run && r.TransactionId != nil {
+			return nil, ErrStandingOrderRunPaid
+		}
+	}
+
+	transaction, err := s.transfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *run
+	c.TransactionId = &transaction.Id
+	s.standingOrderRuns = append(s.standingOrderRuns, &c)
+	run.TransactionId = c.TransactionId
+
+	return transaction, nil
+}
+
+func (s *MemoryStorage) GetStandingOrderRuns(id uuid.UUID) ([]*t.StandingOrderRun, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := []*t.StandingOrderRun{}
+	for _, run := range s.standingOrderRuns {
+		if run.OrderId == id {
+			c := *run
+			runs = append(runs, &c)
+		}
+	}
+
+	return runs, nil
+}
+
+func copyStandingOrder(o *t.StandingOrder) *t.StandingOrder {
+	c := *o
+	if o.EndDate != nil {
+		end := *o.EndDate
+		c.EndDate = &end
+	}
+	if o.LockedUntil != nil {
+		until := *o.LockedUntil
+		c.LockedUntil = &until
+	}
+	return &c
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) CreateRefreshToken(rt *t.RefreshToken) error {
+
+	_, err := s.db.Exec(`INSERT INTO refresh_tokens (id, family_id, acc_number, token_hash, created_at, expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6)`, rt.Id, rt.FamilyId, rt.AccountNumber, rt.TokenHash, rt.CreatedAt, rt.ExpiresAt)
+
+	return err
+}
+
+func (s *PostgresStorage) RotateRefreshToken(hash string, next *t.RefreshToken) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var current t.RefreshToken
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`SELECT id, family_id, acc_number, expires_at, revoked_at
+	FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`, hash).Scan(
+		&current.Id,
+		&current.FamilyId,
+		&current.AccountNumber,
+		&current.ExpiresAt,
+		&revokedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrRefreshTokenInvalid
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if revokedAt.Valid {
+		// reuse of a rotated token: lock the thief and the owner out of
+		// this session alike
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`,
+			next.CreatedAt, current.FamilyId); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	if current.ExpiresAt.Before(next.CreatedAt) {
+		tx.Rollback()
+		return ErrRefreshTokenInvalid
+	}
+
+	next.FamilyId = current.FamilyId
+	next.AccountNumber = current.AccountNumber
+
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (id, family_id, acc_number, token_hash, created_at, expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6)`, next.Id, next.FamilyId, next.AccountNumber, next.TokenHash, next.CreatedAt, next.ExpiresAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = $1, replaced_by = $2 WHERE id = $3`,
+		next.CreatedAt, next.Id, current.Id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) RevokeRefreshTokenFamily(hash string, account int64) error {
+
+	res, err := s.db.Exec(`UPDATE refresh_tokens SET revoked_at = $1
+	WHERE revoked_at IS NULL AND family_id = (
+		SELECT family_id FROM refresh_tokens WHERE token_hash = $2 AND acc_number = $3
+	)`, time.Now().UTC(), hash, account)
+
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return ErrRefreshTokenInvalid
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) RevokeAccessToken(jti string, expiresAt time.Time) error {
+
+	if _, err := s.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1,$2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt)
+
+	return err
+}
+
+func (s *PostgresStorage) IsAccessTokenRevoked(jti string) (bool, error) {
+
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM revoked_tokens WHERE jti = $1`, jti).Scan(&n)
+
+	return n > 0, err
+}
+
+func (s *MemoryStorage) CreateRefreshToken(rt *t.RefreshToken) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *rt
+	s.refreshTokens[rt.TokenHash] = &c
+
+	return nil
+}
+
+func (s *MemoryStorage) RotateRefreshToken(hash string, next *t.RefreshToken) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.refreshTokens[hash]
+	if !ok {
+		return ErrRefreshTokenInvalid
+	}
+
+	if current.RevokedAt != nil {
+		s.revokeFamily(current.FamilyId, next.CreatedAt)
+		return ErrRefreshTokenReused
+	}
+
+	if current.ExpiresAt.Before(next.CreatedAt) {
+		return ErrRefreshTokenInvalid
+	}
+
+	next.FamilyId = current.FamilyId
+	next.AccountNumber = current.AccountNumber
+
+	c := *next
+	s.refreshTokens[next.TokenHash] = &c
+
+	revokedAt := next.CreatedAt
+	replacedBy := next.Id
+	current.RevokedAt = &revokedAt
+	current.ReplacedBy = &replacedBy
+
+	return nil
+}
+
+func (s *MemoryStorage) RevokeRefreshTokenFamily(hash string, account int64) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.refreshTokens[hash]
+	if !ok || current.AccountNumber != account {
+		return ErrRefreshTokenInvalid
+	}
+
+	s.revokeFamily(current.FamilyId, time.Now().UTC())
+
+	return nil
+}
+
+func (s *MemoryStorage) RevokeAccessToken(jti string, expiresAt time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, exp := range s.revokedTokens {
+		if exp.Before(now) {
+			delete(s.revokedTokens, id)
+		}
+	}
+
+	s.revokedTokens[jti] = expiresAt
+
+	return nil
+}
+
+func (s *MemoryStorage) IsAccessTokenRevoked(jti string) (bool, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revokedTokens[jti]
+
+	return ok, nil
+}
+
+// revokeFamily must be called with s.mu held.
+func (s *MemoryStorage) revokeFamily(family uuid.UUID, at time.Time) {
+	for _, rt := range s.refreshTokens {
+		if rt.FamilyId == family && rt.RevokedAt == nil {
+			revokedAt := at
+			rt.RevokedAt = &revokedAt
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	t "github.com/mrkhay/gobank/type"
+)
+
+func (s *PostgresStorage) CreateWebhookEndpoint(e *t.WebhookEndpoint) error {
+
+	_, err := s.db.Exec(`INSERT INTO webhook_endpoints (id, acc_number, url, secret, events, created_at)
+	VALUES ($1,$2,$3,$4,$5,$6)`,
+		e.Id, e.Account, e.URL, e.Secret, strings.Join(e.Events, ","), e.CreatedAt)
+
+	return err
+}
+
+func (s *PostgresStorage) GetWebhookEndpoints(account int64) ([]*t.WebhookEndpoint, error) {
+
+	rows, err := s.db.Query(`SELECT id, acc_number, url, secret, events, created_at
+	FROM webhook_endpoints WHERE acc_number = $1 ORDER BY created_at`, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWebhookEndpoints(rows)
+}
+
+func (s *PostgresStorage) DeleteWebhookEndpoint(id uuid.UUID, account int64) error {
+
+	res, err := s.db.Exec(`DELETE FROM webhook_endpoints WHERE id = $1 AND acc_number = $2`, id, account)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n < 1 {
+		return t.NotFound("webhook_not_found", "webhook %s not found", id)
+	}
+
+	return nil
+}
+
+// DispatchOutboxEvents turns up to limit undispatched events into one
+// delivery per subscribed endpoint and returns how many events it handled.
+func (s *PostgresStorage) DispatchOutboxEvents(now time.Time, limit int) (int, error) {
+
+	dispatched := 0
+
+	err := s.inTx(func(tx *sql.Tx) error {
+
+		rows, err := tx.Query(`SELECT id, type, accounts, data, created_at FROM outbox_events
+		WHERE dispatched_at IS NULL ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED`, limit)
+		if err != nil {
+			return err
+		}
+
+		events := []*t.OutboxEvent{}
+		for rows.Next() {
+			e := &t.OutboxEvent{}
+			var accounts, data string
+			if err := rows.Scan(&e.Id, &e.Type, &accounts, &data, &e.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			e.Accounts = splitAccounts(accounts)
+			e.Data = []byte(data)
+			events = append(events, e)
+		}
+		rows.Close()
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		rows, err = tx.Query(`SELECT id, acc_number, url, secret, events, created_at FROM webhook_endpoints`)
+		if err != nil {
+			return err
+		}
+
+		endpoints, err := scanWebhookEndpoints(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			for _, endpoint := range endpoints {
+				if !endpoint.Wants(event) {
+					continue
+				}
+
+				d := t.NewWebhookDelivery(event, endpoint)
+				if _, err := tx.Exec(`INSERT INTO webhook_deliveries
+				(id, event_id, endpoint_id, status, attempts, next_attempt_at, created_at)
+				VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+					d.Id, d.EventId, d.EndpointId, d.Status, d.Attempts, d.NextAttemptAt, d.CreatedAt); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec(`UPDATE outbox_events SET dispatched_at = $1 WHERE id = $2`, now, event.Id); err != nil {
+				return err
+			}
+		}
+
+		dispatched = len(events)
+		return nil
+	})
+
+	return dispatched, err
+}
+
+// ClaimDueWebhookDeliveries locks up to limit pending deliveries that are
+// due at now for lease and returns them with their event and endpoint.
+func (s *PostgresStorage) ClaimDueWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]*t.WebhookDelivery, error) {
+
+	rows, err := s.db.Query(`UPDATE webhook_deliveries SET locked_until = $2
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = $3 AND next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until < $1)
+		ORDER BY next_attempt_at LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	) RETURNING id`, now, now.Add(lease), t.DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	deliveries := []*t.WebhookDelivery{}
+	for _, id := range ids {
+		found, err := s.queryWebhookDeliveries("d.id = $1", id)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, found...)
+	}
+
+	return deliveries, nil
+}
+
+func (s *PostgresStorage) UpdateWebhookDelivery(d *t.WebhookDelivery) error {
+
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt_at = $3,
+	last_error = $4, last_status = $5, locked_until = $6, delivered_at = $7 WHERE id = $8`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastError, d.LastStatus, d.LockedUntil, d.DeliveredAt, d.Id)
+
+	return err
+}
+
+// GetWebhookDeliveries returns the deliveries with status to the endpoints
+// of account, newest first.
+func (s *PostgresStorage) GetWebhookDeliveries(account int64, status string) ([]*t.WebhookDelivery, error) {
+	return s.queryWebhookDeliveries("w.acc_number = $1 AND d.status = $2 ORDER BY d.created_at DESC", account, status)
+}
+
+// RedeliverWebhook queues a delivery to one of account's endpoints again.
+func (s *PostgresStorage) RedeliverWebhook(id uuid.UUID, account int64, now time.Time) (*t.WebhookDelivery, error) {
+
+	found, err := s.queryWebhookDeliveries("d.id = $1 AND w.acc_number = $2", id, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, t.NotFound("delivery_not_found", "delivery %s not found", id)
+	}
+
+	d := found[0]
+	if d.Status == t.DeliveryPending {
+		return nil, t.Conflict("delivery_pending", "delivery %s is still queued", id)
+	}
+
+	d.Redeliver(now)
+	return d, s.UpdateWebhookDelivery(d)
+}
+
+func (s *PostgresStorage) queryWebhookDeliveries(where string, args ...any) ([]*t.WebhookDelivery, error) {
+
+	rows, err := s.db.Query(`SELECT d.id, d.event_id, d.endpoint_id, d.status, d.attempts, d.next_attempt_at,
+		d.last_error, d.last_status, d.locked_until, d.created_at, d.delivered_at,
+		e.type, e.accounts, e.data, e.created_at,
+		w.acc_number, w.url, w.secret, w.events, w.created_at
+	FROM webhook_deliveries d
+	JOIN outbox_events e ON e.id = d.event_id
+	JOIN webhook_endpoints w ON w.id = d.endpoint_id
+	WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*t.WebhookDelivery{}
+	for rows.Next() {
+		d := &t.WebhookDelivery{Event: &t.OutboxEvent{}, Endpoint: &t.WebhookEndpoint{}}
+		var accounts, data, events string
+		err := rows.Scan(
+			&d.Id, &d.EventId, &d.EndpointId, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastError, &d.LastStatus, &d.LockedUntil, &d.CreatedAt, &d.DeliveredAt,
+			&d.Event.Type, &accounts, &data, &d.Event.CreatedAt,
+			&d.Endpoint.Account, &d.Endpoint.URL, &d.Endpoint.Secret, &events, &d.Endpoint.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Event.Id = d.EventId
+		d.Event.Accounts = splitAccounts(accounts)
+		d.Event.Data = []byte(data)
+		d.Endpoint.Id = d.EndpointId
+		d.Endpoint.Events = splitEvents(events)
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func addOutboxEvent(db execer, e *t.OutboxEvent) error {
+
+	accounts := make([]string, len(e.Accounts))
+	for i, acc := range e.Accounts {
+		accounts[i] = strconv.FormatInt(acc, 10)
+	}
+
+	_, err := db.Exec(`INSERT INTO outbox_events (id, type, accounts, data, created_at) VALUES ($1,$2,$3,$4,$5)`,
+		e.Id, e.Type, strings.Join(accounts, ","), string(e.Data), e.CreatedAt)
+
+	return err
+}
+
+func scanWebhookEndpoints(rows *sql.Rows) ([]*t.WebhookEndpoint, error) {
+
+	defer rows.Close()
+
+	endpoints := []*t.WebhookEndpoint{}
+	for rows.Next() {
+		e := &t.WebhookEndpoint{}
+		var events string
+		if err := rows.Scan(&e.Id, &e.Account, &e.URL, &e.Secret, &events, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Events = splitEvents(events)
+		endpoints = append(endpoints, e)
+	}
+
+	return endpoints, rows.Err()
+}
+
+func splitEvents(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func splitAccounts(s string) []int64 {
+
+	accounts := []int64{}
+	for _, part := range strings.Split(s, ",") {
+		if acc, err := strconv.ParseInt(part, 10, 64); err == nil {
+			accounts = append(accounts, acc)
+		}
+	}
+
+	return accounts
+}
+
+func (s *MemoryStorage) CreateWebhookEndpoint(e *t.WebhookEndpoint) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *e
+	s.webhookEndpoints[e.Id] = &c
+	return nil
+}
+
+func (s *MemoryStorage) GetWebhookEndpoints(account int64) ([]*t.WebhookEndpoint, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	endpoints := []*t.WebhookEndpoint{}
+	for _, e := range s.webhookEndpoints {
+		if e.Account == account {
+			c := *e
+			endpoints = append(endpoints, &c)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt) })
+	return endpoints, nil
+}
+
+func (s *MemoryStorage) DeleteWebhookEndpoint(id uuid.UUID, account int64) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.webhookEndpoints[id]
+	if !ok || e.Account != account {
+		return t.NotFound("webhook_not_found", "webhook %s not found", id)
+	}
+
+	delete(s.webhookEndpoints, id)
+	for did, d := range s.webhookDeliveries {
+		if d.EndpointId == id {
+			delete(s.webhookDeliveries, did)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) DispatchOutboxEvents(now time.Time, limit int) (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dispatched := 0
+	for _, event := range s.outbox {
+		if dispatched == limit {
+			break
+		}
+		if s.dispatched[event.Id] {
+			continue
+		}
+
+		for _, endpoint := range s.webhookEndpoints {
+			if endpoint.Wants(event) {
+				d := t.NewWebhookDelivery(event, endpoint)
+				s.webhookDeliveries[d.Id] = d
+			}
+		}
+
+		s.dispatched[event.Id] = true
+		dispatched++
+	}
+
+	return dispatched, nil
+}
+
+func (s *MemoryStorage) ClaimDueWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]*t.WebhookDelivery, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*t.WebhookDelivery{}
+	for _, d := range s.webhookDeliveries {
+		if d.Status == t.DeliveryPending && !d.NextAttemptAt.After(now) && (d.LockedUntil == nil || d.LockedUntil.Before(now)) {
+			due = append(due, d)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	until := now.Add(lease)
+	for i, d := range due {
+		d.LockedUntil = &until
+		due[i] = s.viewDelivery(d)
+	}
+
+	return due, nil
+}
+
+func (s *MemoryStorage) UpdateWebhookDelivery(d *t.WebhookDelivery) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhookDeliveries[d.Id]; !ok {
+		return t.NotFound("delivery_not_found", "delivery %s not found", d.Id)
+	}
+
+	c := *d
+	c.Event, c.Endpoint = nil, nil
+	s.webhookDeliveries[d.Id] = &c
+	return nil
+}
+
+func (s *MemoryStorage) GetWebhookDeliveries(account int64, status string) ([]*t.WebhookDelivery, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := []*t.WebhookDelivery{}
+	for _, d := range s.webhookDeliveries {
+		if d.Status == status && s.webhookEndpoints[d.EndpointId].Account == account {
+			deliveries = append(deliveries, s.viewDelivery(d))
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries, nil
+}
+
+func (s *MemoryStorage) RedeliverWebhook(id uuid.UUID, account int64, now time.Time) (*t.WebhookDelivery, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.webhookDeliveries[id]
+	if !ok || s.webhookEndpoints[d.EndpointId].Account != account {
+		return nil, t.NotFound("delivery_not_found", "delivery %s not found", id)
+	}
+
+	if d.Status == t.DeliveryPending {
+		return nil, t.Conflict("delivery_pending", "delivery %s is still queued", id)
+	}
+
+	d.Redeliver(now)
+	return s.viewDelivery(d), nil
+}
+
+// addOutboxEvent must be called with the write lock held, as part of the
+// change the event describes.
+func (s *MemoryStorage) addOutboxEvent(e *t.OutboxEvent) {
+	s.outbox = append(s.outbox, e)
+}
+
+func (s *MemoryStorage) viewDelivery(d *t.WebhookDelivery) *t.WebhookDelivery {
+
+	c := *d
+	for _, e := range s.outbox {
+		if e.Id == d.EventId {
+			event := *e
+			c.Event = &event
+		}
+	}
+
+	endpoint := *s.webhookEndpoints[d.EndpointId]
+	c.Endpoint = &endpoint
+
+	return &c
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// repeatGenerator replays numbers, so tests can force collisions.
+type repeatGenerator struct {
+	numbers []int64
+}
+
+func (g *repeatGenerator) Next() (int64, error) {
+	if len(g.numbers) == 0 {
+		return 0, fmt.Errorf("no numbers left")
+	}
+	n := g.numbers[0]
+	g.numbers = g.numbers[1:]
+	return n, nil
+}
+
+func TestLuhnCheckDigit(t *testing.T) {
+	// 79927398713 is the textbook Luhn example
+	assert.Equal(t, 3, types.LuhnCheckDigit(7992739871))
+	assert.Equal(t, 7, types.LuhnCheckDigit(123456789))
+	assert.True(t, types.ValidAccountNumber(1234567897))
+	assert.False(t, types.ValidAccountNumber(79927398713), "too long")
+}
+
+func TestAccountNumberGenerator(t *testing.T) {
+	g := types.NewCounterGenerator()
+
+	seen := map[int64]bool{}
+	for i := 0; i < 1000; i++ {
+		n, err := g.Next()
+		require.NoError(t, err)
+		assert.Len(t, fmt.Sprint(n), types.AccountNumberLength)
+		assert.True(t, types.ValidAccountNumber(n), n)
+		assert.False(t, seen[n], "duplicate %d", n)
+		seen[n] = true
+	}
+}
+
+func TestValidAccountNumber(t *testing.T) {
+	n, err := types.NewCounterGenerator().Next()
+	require.NoError(t, err)
+
+	assert.True(t, types.ValidAccountNumber(n))
+	assert.False(t, types.ValidAccountNumber(n+1), "wrong check digit")
+	assert.False(t, types.ValidAccountNumber(0))
+	assert.False(t, types.ValidAccountNumber(-n))
+	assert.False(t, types.ValidAccountNumber(123456), "no legacy numbers were issued")
+
+	types.SetLegacyAccountNumbers(200000)
+	defer types.SetLegacyAccountNumbers(0)
+	assert.True(t, types.ValidAccountNumber(123456), "legacy numbers that were issued are accepted")
+	assert.False(t, types.ValidAccountNumber(200001), "later short numbers were never issued")
+}
+
+func TestStorageLegacyAccountNumbers(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			newTestAccount(t, s, "numbered@gobank.test")
+			legacy, err := s.LegacyAccountNumbers()
+			require.NoError(t, err)
+			assert.Zero(t, legacy)
+
+			for _, number := range []int64{42, 4711} {
+				acc, err := types.NewAccount("first", "last", fmt.Sprintf("legacy-%d@gobank.test", number), "secret")
+				require.NoError(t, err)
+				acc.AccountNumber = number
+				require.NoError(t, s.CreateAccount(acc))
+			}
+
+			legacy, err = s.LegacyAccountNumbers()
+			require.NoError(t, err)
+			assert.Equal(t, int64(4711), legacy)
+		})
+	}
+}
+
+func TestCreateAccountRetriesOnCollision(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.Init())
+
+	first := newTestAccount(t, store, "first@gobank.test")
+	free := first.AccountNumber + 10 // same body digit, still a valid number
+
+	store.SetAccountNumberGenerator(&repeatGenerator{numbers: []int64{first.AccountNumber, free}})
+
+	second := newTestAccount(t, store, "second@gobank.test")
+	assert.Equal(t, free, second.AccountNumber)
+}
+
+func TestTransferRejectsInvalidAccountNumber(t *testing.T) {
+	ts := newTestServer(t)
+
+	from := newTestAccount(t, ts.store, "from-invalid@gobank.test")
+
+	rec := ts.do(http.MethodPost, "/transfer", map[string]any{
+		"fromAccount": from.AccountNumber,
+		"toAccount":   from.AccountNumber + 1,
+		"amount":      map[string]string{"amount": "1.00", "currency": "USD"},
+	}, authHeaders(t, from))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid account number")
+}
@@ -0,0 +1,222 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mrkhay/gobank/scheduler"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountStatusTransitions(t *testing.T) {
+	now := time.Now().UTC()
+	acc := &types.Account{AccountNumber: 1234567897, Status: types.AccountActive}
+
+	assert.Equal(t, types.KindValidation, types.KindOf(acc.SetStatus(types.AccountFrozen, "", now)), "freezing needs a reason")
+	assert.Equal(t, types.KindValidation, types.KindOf(acc.SetStatus("suspended", "", now)))
+	assert.Equal(t, types.KindConflict, types.KindOf(acc.SetStatus(types.AccountActive, "", now)))
+
+	require.NoError(t, acc.SetStatus(types.AccountFrozen, "suspected fraud", now))
+	assert.Equal(t, "suspected fraud", acc.StatusReason)
+	assert.Equal(t, now, *acc.StatusChangedAt)
+	assert.Equal(t, types.KindConflict, types.KindOf(acc.CanDebit()))
+	assert.NoError(t, acc.CanCredit(), "frozen accounts still receive")
+	assert.Equal(t, types.KindConflict, types.KindOf(acc.SetStatus(types.AccountDormant, "", now)))
+
+	require.NoError(t, acc.SetStatus(types.AccountClosed, "", now))
+	assert.Equal(t, types.KindConflict, types.KindOf(acc.CanCredit()))
+	assert.Equal(t, types.KindConflict, types.KindOf(acc.SetStatus(types.AccountActive, "", now)), "closing is final")
+}
+
+func TestStorageFrozenAccounts(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "frozen@gobank.test")
+			other := newTestAccount(t, s, "frozen-other@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(acc.AccountNumber, usd(t, "50"))))
+			require.NoError(t, s.TopUpAccount(topUp(other.AccountNumber, usd(t, "50"))))
+
+			now := time.Now().UTC()
+			frozen, err := s.SetAccountStatus(acc.ID, types.AccountFrozen, "suspected fraud", now)
+			require.NoError(t, err)
+			assert.Equal(t, types.AccountFrozen, frozen.Status)
+
+			transfer := func(from, to *types.Account) error {
+				_, err := s.Transfer(&types.TransferRequest{
+					FromAccount: int(from.AccountNumber),
+					ToAccount:   int(to.AccountNumber),
+					Amount:      usd(t, "10"),
+				})
+				return err
+			}
+
+			assert.Equal(t, types.KindConflict, types.KindOf(transfer(acc, other)), "no debits while frozen")
+			assert.NoError(t, transfer(other, acc), "credits are still accepted")
+
+			hold, err := types.NewHold(&types.CreateHoldRequest{
+				Account:   acc.AccountNumber,
+				ToAccount: other.AccountNumber,
+				Amount:    usd(t, "10"),
+			}, now)
+			require.NoError(t, err)
+			assert.Equal(t, types.KindConflict, types.KindOf(s.CreateHold(hold)))
+
+			_, err = s.SetAccountStatus(acc.ID, types.AccountActive, "", now)
+			require.NoError(t, err)
+			assert.NoError(t, transfer(acc, other))
+		})
+	}
+}
+
+func TestStorageCloseAccount(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "close@gobank.test")
+			payout := newTestAccount(t, s, "close-payout@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(acc.AccountNumber, usd(t, "30"))))
+
+			now := time.Now().UTC()
+			_, err := s.CloseAccount(acc.ID, &types.CloseAccountRequest{}, now)
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "funds are left and nowhere to send them")
+
+			hold, err := types.NewHold(&types.CreateHoldRequest{
+				Account:   acc.AccountNumber,
+				ToAccount: payout.AccountNumber,
+				Amount:    usd(t, "5"),
+			}, now)
+			require.NoError(t, err)
+			require.NoError(t, s.CreateHold(hold))
+
+			req := &types.CloseAccountRequest{PayoutAccount: payout.AccountNumber, Reason: "moving abroad"}
+			_, err = s.CloseAccount(acc.ID, req, now)
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "funds on hold")
+
+			_, err = s.ReleaseHold(hold.Id, now)
+			require.NoError(t, err)
+
+			closed, err := s.CloseAccount(acc.ID, req, now)
+			require.NoError(t, err)
+			assert.Equal(t, types.AccountClosed, closed.Status)
+			assert.Equal(t, "moving abroad", closed.StatusReason)
+			assert.Equal(t, usd(t, "0"), balanceOf(t, s, acc))
+			assert.Equal(t, usd(t, "30"), balanceOf(t, s, payout))
+
+			history, err := s.GetUserTransactions(int(payout.AccountNumber))
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			assert.Equal(t, types.DescriptionClosure, history[0].Description)
+
+			assert.Equal(t, types.KindConflict, types.KindOf(s.TopUpAccount(topUp(acc.AccountNumber, usd(t, "1")))))
+			_, err = s.CloseAccount(acc.ID, req, now)
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+		})
+	}
+}
+
+func TestStorageDormancy(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			idle := newTestAccount(t, s, "dormant-idle@gobank.test")
+			busy := newTestAccount(t, s, "dormant-busy@gobank.test")
+
+			since := time.Now().UTC()
+			time.Sleep(time.Millisecond)
+			require.NoError(t, s.TopUpAccount(topUp(busy.AccountNumber, usd(t, "20"))))
+
+			now := since.Add(time.Hour)
+			dormant, err := s.MarkDormantAccounts(since, now)
+			require.NoError(t, err)
+
+			numbers := []int64{}
+			for _, acc := range dormant {
+				numbers = append(numbers, acc.AccountNumber)
+			}
+			assert.Contains(t, numbers, idle.AccountNumber)
+			assert.NotContains(t, numbers, busy.AccountNumber)
+
+			got, err := s.GetAccountByID(idle.ID)
+			require.NoError(t, err)
+			assert.Equal(t, types.AccountDormant, got.Status)
+
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(busy.AccountNumber),
+				ToAccount:   int(idle.AccountNumber),
+				Amount:      usd(t, "5"),
+			})
+			require.NoError(t, err, "dormant accounts still receive")
+
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(idle.AccountNumber),
+				ToAccount:   int(busy.AccountNumber),
+				Amount:      usd(t, "5"),
+			})
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+
+			// the holder may still close a dormant account and take the funds
+			closed, err := s.CloseAccount(idle.ID, &types.CloseAccountRequest{PayoutAccount: busy.AccountNumber}, now)
+			require.NoError(t, err)
+			assert.Equal(t, types.AccountClosed, closed.Status)
+			assert.Equal(t, usd(t, "20"), balanceOf(t, s, busy))
+		})
+	}
+}
+
+func TestDormancyJob(t *testing.T) {
+	ts := newTestServer(t)
+	newTestAccount(t, ts.store, "dormancy-job@gobank.test")
+
+	notifier := &recordingNotifier{}
+	job := scheduler.NewDormancyJob(ts.store, notifier)
+
+	require.NoError(t, job.RunDue(time.Now().UTC()))
+	assert.Empty(t, notifier.subjects, "the account is new")
+
+	require.NoError(t, job.RunDue(time.Now().UTC().AddDate(2, 0, 0)))
+	assert.Equal(t, []string{"Account dormant"}, notifier.subjects)
+}
+
+func TestAccountStatusEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	operator := newStaffAccount(t, ts.store, "status-operator@gobank.test", types.RoleOperator)
+	support := newStaffAccount(t, ts.store, "status-support@gobank.test", types.RoleSupport)
+	acc := newTestAccount(t, ts.store, "status-customer@gobank.test")
+	payout := newTestAccount(t, ts.store, "status-payout@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(acc.AccountNumber, usd(t, "10"))))
+
+	status := fmt.Sprintf("/admin/accounts/%d/status", acc.ID)
+	freeze := map[string]any{"status": types.AccountFrozen, "reason": "chargeback investigation"}
+
+	assert.Equal(t, http.StatusForbidden, ts.do("PUT", status, freeze, authHeaders(t, support)).Code)
+
+	res := ts.do("PUT", status, freeze, authHeaders(t, operator))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var got types.Account
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equal(t, types.AccountFrozen, got.Status)
+	assert.Equal(t, "chargeback investigation", got.StatusReason)
+
+	transfer := map[string]any{"fromAccount": acc.AccountNumber, "toAccount": payout.AccountNumber, "amount": usd(t, "1")}
+	assert.Equal(t, http.StatusConflict, ts.do("POST", "/transfer", transfer, authHeaders(t, acc)).Code)
+
+	res = ts.do("PUT", status, map[string]any{"status": types.AccountActive}, authHeaders(t, operator))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	path := fmt.Sprintf("/account/%d", acc.ID)
+	headers := authHeaders(t, acc)
+	assert.Equal(t, http.StatusConflict, ts.do("DELETE", path, nil, headers).Code, "funds left")
+
+	res = ts.do("DELETE", path, map[string]any{"payout_account": payout.AccountNumber}, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equal(t, types.AccountClosed, got.Status)
+
+	assert.Equal(t, http.StatusUnauthorized, ts.do("GET", path, nil, headers).Code, "closed accounts cannot sign in")
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
@@ -0,0 +1,259 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/mailer"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMailer struct {
+	sent []*mailer.Message
+}
+
+func (m *recordingMailer) Send(msg *mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenInLink = regexp.MustCompile(`token=(\S+)`)
+
+// lastToken returns the token linked in the last mail sent to email.
+func (m *recordingMailer) lastToken(t *testing.T, email string) string {
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To != email {
+			continue
+		}
+		match := tokenInLink.FindStringSubmatch(m.sent[i].Body)
+		require.NotNil(t, match, "no link in %q", m.sent[i].Body)
+		token, err := url.QueryUnescape(match[1])
+		require.NoError(t, err)
+		return token
+	}
+	t.Fatalf("no mail sent to %s", email)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	ts := newTestServer(t)
+
+	res := ts.do("POST", "/account", map[string]string{
+		"firstname": "Ada", "lastname": "Lovelace", "email": "verify@gobank.test", "password": "secret-123",
+	}, nil)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var created types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	assert.Nil(t, created.Account.EmailVerifiedAt)
+	headers := map[string]string{"x-jwt-token": created.Token}
+
+	first := ts.mail.lastToken(t, "verify@gobank.test")
+	require.Equal(t, http.StatusAccepted, ts.do("POST", "/email/verification", nil, headers).Code)
+	second := ts.mail.lastToken(t, "verify@gobank.test")
+
+	res = ts.do("POST", "/email/verify", map[string]string{"token": first}, nil)
+	assert.Equal(t, http.StatusBadRequest, res.Code, "a resend voids the earlier link")
+
+	require.Equal(t, http.StatusOK, ts.do("POST", "/email/verify", map[string]string{"token": second}, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, ts.do("POST", "/email/verify", map[string]string{"token": second}, nil).Code)
+
+	acc, err := ts.store.GetAccountByAccountNumber(created.Account.AccountNumber)
+	require.NoError(t, err)
+	assert.NotNil(t, acc.EmailVerifiedAt)
+	assert.Equal(t, http.StatusConflict, ts.do("POST", "/email/verification", nil, headers).Code)
+}
+
+// failingTokenStore cannot store account tokens.
+type failingTokenStore struct {
+	storage.Storage
+}
+
+func (failingTokenStore) CreateAccountToken(*types.AccountToken) error {
+	return fmt.Errorf("token store unavailable")
+}
+
+func TestCreateAccountWithoutVerificationEmail(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.Init())
+	server, err := api.NewApiServer(":0", failingTokenStore{store})
+	require.NoError(t, err)
+	server.SetMailer(&recordingMailer{})
+	ts := &testServer{store: store, handler: server.Handler()}
+
+	res := ts.do("POST", "/account", map[string]string{
+		"firstname": "Ada", "lastname": "Lovelace", "email": "no-mail@gobank.test", "password": "secret-123",
+	}, nil)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var created types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	_, err = store.GetAccountByAccountNumber(created.Account.AccountNumber)
+	assert.NoError(t, err)
+}
+
+func TestEmailVerificationForOldEmail(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "old-email@gobank.test")
+
+	require.Equal(t, http.StatusAccepted, ts.do("POST", "/email/verification", nil, authHeaders(t, acc)).Code)
+	token := ts.mail.lastToken(t, acc.Email)
+
+	acc.Email = "new-email@gobank.test"
+	require.NoError(t, ts.store.UpdateAccount(acc))
+
+	res := ts.do("POST", "/email/verify", map[string]string{"token": token}, nil)
+	assert.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "reset@gobank.test")
+	session := login(t, ts, acc.Email)
+	session.Token = issuedBefore(t, session.Token, time.Minute)
+
+	var unknown, known map[string]any
+	res := ts.do("POST", "/password/forgot", map[string]string{"email": "nobody@gobank.test"}, nil)
+	require.Equal(t, http.StatusAccepted, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&unknown))
+	assert.Empty(t, ts.mail.sent)
+
+	res = ts.do("POST", "/password/forgot", map[string]string{"email": acc.Email}, nil)
+	require.Equal(t, http.StatusAccepted, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&known))
+	assert.Equal(t, unknown, known, "the answer does not tell whether the email has an account")
+
+	token := ts.mail.lastToken(t, acc.Email)
+	reset := map[string]string{"token": token, "new_password": "better secret"}
+	require.Equal(t, http.StatusOK, ts.do("POST", "/password/reset", reset, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, ts.do("POST", "/password/reset", reset, nil).Code, "tokens are single use")
+
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": session.RefreshToken}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "sessions from before the reset are signed out")
+	res = ts.do("GET", "/transactions", nil, map[string]string{"x-jwt-token": session.Token})
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "so are their access tokens")
+
+	assert.Equal(t, http.StatusUnauthorized, ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil).Code)
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "better secret"}, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestForgotPasswordIgnoresCase(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "forgot-case@gobank.test")
+
+	res := ts.do("POST", "/password/forgot", map[string]string{"email": "Forgot-Case@GoBank.test"}, nil)
+	require.Equal(t, http.StatusAccepted, res.Code)
+	assert.NotEmpty(t, ts.mail.lastToken(t, acc.Email))
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "forgot-throttle@gobank.test")
+	forgot := map[string]string{"email": acc.Email}
+
+	for i := 0; i < types.EmailResetPolicy.FreeAttempts; i++ {
+		require.Equal(t, http.StatusAccepted, ts.do("POST", "/password/forgot", forgot, nil).Code)
+	}
+
+	res := ts.do("POST", "/password/forgot", forgot, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+	assert.Len(t, ts.mail.sent, types.EmailResetPolicy.FreeAttempts)
+
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	assert.Equal(t, http.StatusOK, res.Code, "reset requests do not hold back logins")
+
+	// unknown emails are held back the same way
+	nobody := map[string]string{"email": "forgot-nobody@gobank.test"}
+	for i := 0; i < types.EmailResetPolicy.FreeAttempts; i++ {
+		require.Equal(t, http.StatusAccepted, ts.do("POST", "/password/forgot", nobody, nil).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, ts.do("POST", "/password/forgot", nobody, nil).Code)
+}
+
+func TestPasswordResetAfterEmailChange(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "reset-moved@gobank.test")
+
+	require.Equal(t, http.StatusAccepted, ts.do("POST", "/password/forgot", map[string]string{"email": acc.Email}, nil).Code)
+	token := ts.mail.lastToken(t, acc.Email)
+
+	acc.Email = "elsewhere@gobank.test"
+	require.NoError(t, ts.store.UpdateAccount(acc))
+
+	res := ts.do("POST", "/password/reset", map[string]string{"token": token, "new_password": "better secret"}, nil)
+	assert.Equal(t, http.StatusConflict, res.Code)
+	assert.Equal(t, "email_changed", decodeError(t, res).Code)
+}
+
+func TestPasswordResetTokenExpires(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "expired-reset@gobank.test")
+
+	token, err := utility.RandomToken(32)
+	require.NoError(t, err)
+
+	created := time.Now().UTC().Add(-2 * types.PasswordResetTTL)
+	require.NoError(t, ts.store.CreateAccountToken(&types.AccountToken{
+		Id:            uuid.New(),
+		AccountNumber: acc.AccountNumber,
+		Purpose:       types.TokenPasswordReset,
+		Email:         acc.Email,
+		TokenHash:     utility.HashToken(token),
+		CreatedAt:     created,
+		ExpiresAt:     created.Add(types.PasswordResetTTL),
+	}))
+
+	res := ts.do("POST", "/password/reset", map[string]string{"token": token, "new_password": "too late"}, nil)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	// a verification token cannot reset a password
+	require.Equal(t, http.StatusAccepted, ts.do("POST", "/email/verification", nil, authHeaders(t, acc)).Code)
+	res = ts.do("POST", "/password/reset", map[string]string{"token": ts.mail.lastToken(t, acc.Email), "new_password": "sneaky-123"}, nil)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "change-password@gobank.test")
+	session := login(t, ts, acc.Email)
+	headers := map[string]string{"x-jwt-token": issuedBefore(t, session.Token, time.Minute)}
+
+	res := ts.do("POST", "/password/change", map[string]string{"old_password": "wrong", "new_password": "changed-123"}, headers)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	attempts, err := ts.store.GetLoginAttempts(acc.AccountNumber, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, attempts)
+	assert.Equal(t, types.LoginFailed, attempts[0].Result, "wrong old passwords count as failed logins")
+
+	res = ts.do("POST", "/password/change", map[string]string{"old_password": "secret", "new_password": "changed-123"}, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var tokens types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": session.RefreshToken}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": tokens.RefreshToken}, nil)
+	assert.Equal(t, http.StatusOK, res.Code, "the caller stays signed in")
+
+	assert.Equal(t, http.StatusUnauthorized, ts.do("GET", "/transactions", nil, headers).Code)
+	assert.Equal(t, http.StatusOK, ts.do("GET", "/transactions", nil, map[string]string{"x-jwt-token": tokens.Token}).Code)
+
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "changed-123"}, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
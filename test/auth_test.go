@@ -0,0 +1,91 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func login(t *testing.T, ts *testServer, email string) types.TokenResponse {
+	res := ts.do("POST", "/login", map[string]string{"email": email, "password": "secret"}, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var tokens types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	return tokens
+}
+
+// issuedBefore re-signs an access token as if it had been issued d ago.
+func issuedBefore(t *testing.T, token string, d time.Duration) string {
+	claims := new(utility.Claims)
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return []byte("test-secret"), nil })
+	require.NoError(t, err)
+
+	claims.IssuedAt = time.Now().Add(-d).Unix()
+	backdated, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	return backdated
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "claims@gobank.test")
+
+	token, err := utility.ValidateJWT(login(t, ts, acc.Email).Token, ts.store)
+	require.NoError(t, err)
+
+	claims := token.Claims.(*utility.Claims)
+	assert.Equal(t, "gobank", claims.Issuer)
+	assert.NotEmpty(t, claims.Id)
+	assert.Equal(t, acc.AccountNumber, claims.AccountNumber)
+	assert.InDelta(t, time.Now().Add(15*time.Minute).Unix(), claims.ExpiresAt, 5)
+
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	_, err = utility.ValidateJWT(expired, ts.store)
+	assert.Error(t, err, "expired tokens must be rejected")
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "refresh@gobank.test")
+	first := login(t, ts, acc.Email)
+
+	res := ts.do("POST", "/token/refresh", map[string]string{"refresh_token": first.RefreshToken}, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var second types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&second))
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// replaying the rotated token revokes the whole family
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": first.RefreshToken}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": second.RefreshToken}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "logout@gobank.test")
+	tokens := login(t, ts, acc.Email)
+	headers := map[string]string{"x-jwt-token": tokens.Token}
+
+	res := ts.do("POST", "/logout", map[string]string{"refresh_token": tokens.RefreshToken}, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	_, err := utility.ValidateJWT(tokens.Token, ts.store)
+	assert.Error(t, err, "the access token used to log out must be revoked")
+
+	res = ts.do("POST", "/token/refresh", map[string]string{"refresh_token": tokens.RefreshToken}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
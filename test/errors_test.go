@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// brokenStorage fails like a database that lost a table would.
+type brokenStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s *brokenStorage) GetUserTransactions(int) ([]*types.Transcation, error) {
+	return nil, fmt.Errorf(`pq: relation "transactions" does not exist`)
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) utility.ApiError {
+	var body utility.ApiError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	return body
+}
+
+func TestErrorStatusCodes(t *testing.T) {
+	ts := newTestServer(t)
+	from := newTestAccount(t, ts.store, "errors-from@gobank.test")
+	to := newTestAccount(t, ts.store, "errors-to@gobank.test")
+
+	rec := ts.do("POST", "/transfer", map[string]any{
+		"fromAccount": from.AccountNumber,
+		"toAccount":   to.AccountNumber,
+		"amount":      map[string]string{"amount": "5.00", "currency": "USD"},
+	}, authHeaders(t, from))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "insufficient_funds", decodeError(t, rec).Code)
+
+	rec = ts.do("POST", "/transfer", map[string]any{
+		"fromAccount": from.AccountNumber,
+		"toAccount":   to.AccountNumber,
+		"amount":      map[string]string{"amount": "-5.00", "currency": "USD"},
+	}, authHeaders(t, from))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid_amount", decodeError(t, rec).Code)
+
+	rec = ts.do("POST", "/login", map[string]string{"email": from.Email, "password": "wrong"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "invalid_credentials", decodeError(t, rec).Code)
+
+	rec = ts.do("POST", "/account", map[string]string{
+		"firstname": "a", "lastname": "b", "email": from.Email, "password": "secret-123",
+	}, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "email_in_use", decodeError(t, rec).Code)
+}
+
+func TestInternalErrorsAreNotLeaked(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := &brokenStorage{storage.NewMemoryStorage()}
+	require.NoError(t, store.Init())
+	acc := newTestAccount(t, store, "broken@gobank.test")
+
+	server, err := api.NewApiServer(":0", store)
+	require.NoError(t, err)
+	ts := &testServer{store: store.MemoryStorage, handler: server.Handler()}
+	headers := authHeaders(t, acc)
+	headers[utility.CorrelationIDHeader] = "req-123"
+
+	rec := ts.do("GET", "/transactions", nil, headers)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "req-123", rec.Header().Get(utility.CorrelationIDHeader))
+
+	body := decodeError(t, rec)
+	assert.Equal(t, "internal_error", body.Code)
+	assert.Equal(t, "req-123", body.CorrelationID)
+	assert.NotContains(t, body.Error, "pq:")
+}
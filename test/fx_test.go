@@ -0,0 +1,194 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func money(t *testing.T, amount, currency string) types.Money {
+	m, err := types.ParseMoney(amount, currency)
+	require.NoError(t, err)
+	return m
+}
+
+func fxRate(t *testing.T, base, quote, rate string, spread int) *types.FXRate {
+	r, err := types.NewFXRate(base, quote, &types.SetFXRateRequest{Rate: rate, Spread: spread}, time.Now().UTC())
+	require.NoError(t, err)
+	return r
+}
+
+func TestNewFXRate(t *testing.T) {
+	cases := []struct {
+		base, quote, rate string
+		spread            int
+		ok                bool
+	}{
+		{"USD", "EUR", "0.92", 50, true},
+		{"USD", "JPY", "149.5", 0, true},
+		{"USD", "USD", "1", 0, false},
+		{"USD", "XYZ", "1", 0, false},
+		{"USD", "EUR", "0", 0, false},
+		{"USD", "EUR", "-1", 0, false},
+		{"USD", "EUR", "1e3", 0, false},
+		{"USD", "EUR", "0.1234567890123", 0, false},
+		{"USD", "EUR", "0.92", 10000, false},
+		{"USD", "EUR", "0.92", -1, false},
+	}
+
+	for _, c := range cases {
+		_, err := types.NewFXRate(c.base, c.quote, &types.SetFXRateRequest{Rate: c.rate, Spread: c.spread}, time.Now())
+		if c.ok {
+			assert.NoError(t, err, c)
+		} else {
+			assert.Equal(t, types.KindValidation, types.KindOf(err), c)
+		}
+	}
+}
+
+func TestFXRateConvert(t *testing.T) {
+	now := time.Now().UTC()
+
+	rate := fxRate(t, "USD", "EUR", "0.9", 100)
+	applied, err := rate.AppliedRate()
+	require.NoError(t, err)
+	assert.Equal(t, "0.891", applied)
+
+	got, err := rate.Convert(money(t, "100", "USD"), now)
+	require.NoError(t, err)
+	assert.Equal(t, money(t, "89.10", "EUR"), got)
+
+	// rounded down to the minor unit, across different exponents
+	got, err = fxRate(t, "USD", "JPY", "149.99", 0).Convert(money(t, "0.99", "USD"), now)
+	require.NoError(t, err)
+	assert.Equal(t, money(t, "148", "JPY"), got)
+
+	got, err = fxRate(t, "JPY", "KWD", "0.00206", 0).Convert(money(t, "1000", "JPY"), now)
+	require.NoError(t, err)
+	assert.Equal(t, money(t, "2.06", "KWD"), got)
+
+	_, err = fxRate(t, "JPY", "USD", "0.0067", 0).Convert(money(t, "1", "JPY"), now)
+	assert.Equal(t, types.KindValidation, types.KindOf(err), "less than a cent")
+
+	_, err = rate.Convert(money(t, "100", "USD"), now.Add(types.MaxFXRateAge+time.Minute))
+	assert.Equal(t, types.KindConflict, types.KindOf(err), "stale rate")
+
+	_, err = rate.Convert(money(t, "100", "EUR"), now)
+	assert.Error(t, err)
+}
+
+func TestStorageFXTransfer(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "fx-from@gobank.test")
+
+			to, err := types.NewAccount("first", "last", "fx-to@gobank.test", "secret")
+			require.NoError(t, err)
+			to.Balance = types.NewMoney(0, "EUR")
+			require.NoError(t, s.CreateAccount(to))
+
+			require.NoError(t, s.TopUpAccount(topUp(from.AccountNumber, usd(t, "150"))))
+
+			transfer := &types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "100"),
+			}
+
+			_, err = s.Transfer(transfer)
+			assert.Equal(t, types.KindValidation, types.KindOf(err), "no USD/EUR rate yet")
+
+			require.NoError(t, s.SetFXRate(fxRate(t, "USD", "EUR", "0.9", 100)))
+
+			tran, err := s.Transfer(transfer)
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "100"), tran.Amount)
+			require.NotNil(t, tran.Converted)
+			assert.Equal(t, money(t, "89.10", "EUR"), *tran.Converted)
+			assert.Equal(t, "0.891", tran.FXRate)
+
+			got, err := s.GetAccountByID(to.ID)
+			require.NoError(t, err)
+			assert.Equal(t, money(t, "89.10", "EUR"), got.Balance)
+
+			// converting into the sender's own EUR sub-balance
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(from.AccountNumber),
+				Amount:      usd(t, "50"),
+				ToCurrency:  "EUR",
+			})
+			require.NoError(t, err)
+
+			got, err = s.GetAccountByID(from.ID)
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "0"), got.Balance)
+			assert.Equal(t, []types.Money{usd(t, "0"), money(t, "44.55", "EUR")}, got.Balances)
+
+			// EUR to EUR needs no rate
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      money(t, "40", "EUR"),
+			})
+			require.NoError(t, err)
+
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      money(t, "40", "EUR"),
+			})
+			assert.Equal(t, types.KindInsufficientFunds, types.KindOf(err))
+
+			entries, err := s.GetJournalEntries(int(from.AccountNumber))
+			require.NoError(t, err)
+			require.Len(t, entries, 4)
+			assert.Len(t, entries[1].Postings, 4)
+			for _, e := range entries {
+				assert.NoError(t, e.Validate())
+			}
+
+			page, err := s.ListUserTransactions(int(to.AccountNumber), &types.TransactionFilter{MinAmount: ptr(money(t, "89.10", "EUR"))})
+			require.NoError(t, err)
+			require.Len(t, page.Transactions, 1, "the receiver is matched on the converted amount")
+			assert.Equal(t, tran.Id, page.Transactions[0].Id)
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestFXRateEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	admin := newStaffAccount(t, ts.store, "fx-admin@gobank.test", types.RoleAdmin)
+	customer := newTestAccount(t, ts.store, "fx-customer@gobank.test")
+
+	body := map[string]any{"rate": "0.92", "spread_bps": 25}
+	assert.Equal(t, http.StatusForbidden, ts.do("PUT", "/admin/fx-rates/USD/EUR", body, authHeaders(t, customer)).Code)
+
+	res := ts.do("PUT", "/admin/fx-rates/usd/eur", body, authHeaders(t, admin))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, ts.do("PUT", "/admin/fx-rates/USD/EUR", map[string]any{"rate": "abc"}, authHeaders(t, admin)).Code)
+
+	res = ts.do("GET", "/fx-rates", nil, authHeaders(t, customer))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var rates []*types.FXRate
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&rates))
+	require.Len(t, rates, 1)
+	assert.Equal(t, "USD", rates[0].Base)
+	assert.Equal(t, "EUR", rates[0].Quote)
+	assert.Equal(t, "0.92", rates[0].Rate)
+	assert.Equal(t, 25, rates[0].Spread)
+
+	assert.Equal(t, http.StatusNoContent, ts.do("DELETE", "/admin/fx-rates/USD/EUR", nil, authHeaders(t, admin)).Code)
+	assert.Equal(t, http.StatusNotFound, ts.do("DELETE", "/admin/fx-rates/USD/EUR", nil, authHeaders(t, admin)).Code)
+}
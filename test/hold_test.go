@@ -0,0 +1,224 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/scheduler"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func availableOf(t *testing.T, s storage.Storage, acc *types.Account) types.Money {
+	got, err := s.GetAccountByID(acc.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.AvailableBalance)
+	return *got.AvailableBalance
+}
+
+func TestNewHold(t *testing.T) {
+	now := time.Now().UTC()
+	req := func() *types.CreateHoldRequest {
+		return &types.CreateHoldRequest{Account: 1234567897, ToAccount: 987654321*10 + int64(types.LuhnCheckDigit(987654321)), Amount: usd(t, "10")}
+	}
+
+	hold, err := types.NewHold(req(), now)
+	require.NoError(t, err)
+	assert.Equal(t, types.HoldActive, hold.Status)
+	assert.Equal(t, now.Add(types.DefaultHoldDuration), hold.ExpiresAt)
+
+	bad := []func(r *types.CreateHoldRequest){
+		func(r *types.CreateHoldRequest) { r.ToAccount = r.Account },
+		func(r *types.CreateHoldRequest) { r.Amount = usd(t, "0") },
+		func(r *types.CreateHoldRequest) { r.ExpiresAt = ptr(now.Add(-time.Minute)) },
+		func(r *types.CreateHoldRequest) { r.ExpiresAt = ptr(now.Add(types.MaxHoldDuration + time.Hour)) },
+	}
+	for i, change := range bad {
+		r := req()
+		change(r)
+		_, err := types.NewHold(r, now)
+		assert.Equal(t, types.KindValidation, types.KindOf(err), "case %d", i)
+	}
+
+	_, err = hold.Capture(ptr(usd(t, "11")), now)
+	assert.Equal(t, types.KindValidation, types.KindOf(err), "more than was held")
+
+	later := hold.ExpiresAt
+	assert.False(t, hold.Active(later))
+	_, err = hold.Capture(nil, later)
+	assert.Equal(t, types.KindConflict, types.KindOf(err))
+	assert.True(t, hold.Expire(later))
+	assert.Equal(t, types.HoldExpired, hold.Status)
+	assert.Equal(t, types.KindConflict, types.KindOf(hold.Release(later)))
+}
+
+func TestStorageHolds(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			customer := newTestAccount(t, s, "hold-customer@gobank.test")
+			merchant := newTestAccount(t, s, "hold-merchant@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(customer.AccountNumber, usd(t, "100"))))
+
+			now := time.Now().UTC()
+			place := func(amount string) (*types.Hold, error) {
+				hold, err := types.NewHold(&types.CreateHoldRequest{
+					Account:   customer.AccountNumber,
+					ToAccount: merchant.AccountNumber,
+					Amount:    usd(t, amount),
+				}, now)
+				require.NoError(t, err)
+				return hold, s.CreateHold(hold)
+			}
+
+			first, err := place("60")
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "100"), balanceOf(t, s, customer), "holds leave the balance alone")
+			assert.Equal(t, usd(t, "40"), availableOf(t, s, customer))
+
+			_, err = place("50")
+			assert.Equal(t, types.KindInsufficientFunds, types.KindOf(err))
+
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(customer.AccountNumber),
+				ToAccount:   int(merchant.AccountNumber),
+				Amount:      usd(t, "50"),
+			})
+			assert.Equal(t, types.KindInsufficientFunds, types.KindOf(err), "transfers only spend available funds")
+
+			hold, tran, err := s.CaptureHold(first.Id, ptr(usd(t, "45")), now)
+			require.NoError(t, err)
+			assert.Equal(t, types.HoldCaptured, hold.Status)
+			assert.Equal(t, tran.Id, *hold.TransactionId)
+			assert.Equal(t, usd(t, "45"), tran.Amount)
+			assert.Equal(t, first.Description, tran.Description)
+			assert.Equal(t, usd(t, "55"), balanceOf(t, s, customer))
+			assert.Equal(t, usd(t, "55"), availableOf(t, s, customer), "the rest of the hold is released")
+			assert.Equal(t, usd(t, "45"), balanceOf(t, s, merchant))
+
+			_, _, err = s.CaptureHold(first.Id, nil, now)
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "no double capture")
+
+			second, err := place("30")
+			require.NoError(t, err)
+			_, err = s.ReleaseHold(second.Id, now)
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "55"), availableOf(t, s, customer))
+
+			// a hold stops counting as soon as it runs out, before the
+			// expiry job has marked it
+			lapsed, err := types.NewHold(&types.CreateHoldRequest{
+				Account:   customer.AccountNumber,
+				ToAccount: merchant.AccountNumber,
+				Amount:    usd(t, "20"),
+			}, now.Add(-types.MaxHoldDuration))
+			require.NoError(t, err)
+			require.NoError(t, s.CreateHold(lapsed))
+			assert.Equal(t, usd(t, "55"), availableOf(t, s, customer))
+
+			n, err := s.ExpireHolds(now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			got, err := s.GetHold(lapsed.Id)
+			require.NoError(t, err)
+			assert.Equal(t, types.HoldExpired, got.Status)
+			assert.Equal(t, usd(t, "55"), availableOf(t, s, customer))
+
+			_, _, err = s.CaptureHold(lapsed.Id, nil, now)
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+
+			holds, err := s.GetHolds(merchant.AccountNumber)
+			require.NoError(t, err)
+			assert.Len(t, holds, 3)
+		})
+	}
+}
+
+func TestHoldExpirer(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	customer := newTestAccount(t, s, "expirer-customer@gobank.test")
+	merchant := newTestAccount(t, s, "expirer-merchant@gobank.test")
+	require.NoError(t, s.TopUpAccount(topUp(customer.AccountNumber, usd(t, "10"))))
+
+	now := time.Now().UTC()
+	hold, err := types.NewHold(&types.CreateHoldRequest{
+		Account:   customer.AccountNumber,
+		ToAccount: merchant.AccountNumber,
+		Amount:    usd(t, "10"),
+		ExpiresAt: ptr(now.Add(time.Hour)),
+	}, now)
+	require.NoError(t, err)
+	require.NoError(t, s.CreateHold(hold))
+
+	expirer := scheduler.NewHoldExpirer(s)
+	require.NoError(t, expirer.RunDue(now))
+	got, err := s.GetHold(hold.Id)
+	require.NoError(t, err)
+	assert.Equal(t, types.HoldActive, got.Status)
+
+	require.NoError(t, expirer.RunDue(now.Add(time.Hour)))
+	got, err = s.GetHold(hold.Id)
+	require.NoError(t, err)
+	assert.Equal(t, types.HoldExpired, got.Status)
+}
+
+func TestHoldEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	customer := newTestAccount(t, ts.store, "hold-api-customer@gobank.test")
+	merchant := newTestAccount(t, ts.store, "hold-api-merchant@gobank.test")
+	stranger := newTestAccount(t, ts.store, "hold-api-stranger@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(customer.AccountNumber, usd(t, "100"))))
+
+	body := map[string]any{
+		"account":     customer.AccountNumber,
+		"to_account":  merchant.AccountNumber,
+		"amount":      usd(t, "25"),
+		"description": "Hotel deposit",
+	}
+
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", "/holds", body, authHeaders(t, merchant)).Code,
+		"holds are placed by the account holder")
+
+	res := ts.do("POST", "/holds", body, authHeaders(t, customer))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var hold types.Hold
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&hold))
+
+	res = ts.do("GET", fmt.Sprintf("/account/%d", customer.ID), nil, authHeaders(t, customer))
+	require.Equal(t, http.StatusOK, res.Code)
+	var acc types.Account
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&acc))
+	assert.Equal(t, usd(t, "100"), acc.Balance)
+	assert.Equal(t, usd(t, "75"), *acc.AvailableBalance)
+
+	path := fmt.Sprintf("/holds/%s", hold.Id)
+	assert.Equal(t, http.StatusNotFound, ts.do("GET", path, nil, authHeaders(t, stranger)).Code)
+	assert.Equal(t, http.StatusOK, ts.do("GET", path, nil, authHeaders(t, customer)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", path+"/release", nil, authHeaders(t, customer)).Code,
+		"the account holder cannot take back what was promised")
+
+	res = ts.do("POST", path+"/capture", map[string]any{"amount": usd(t, "20")}, authHeaders(t, merchant))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var captured api.HoldResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&captured))
+	assert.Equal(t, types.HoldCaptured, captured.Hold.Status)
+	assert.Equal(t, usd(t, "20"), captured.Transaction.Amount)
+	assert.Equal(t, "Hotel deposit", captured.Transaction.Description)
+
+	assert.Equal(t, http.StatusConflict, ts.do("POST", path+"/release", nil, authHeaders(t, merchant)).Code)
+
+	res = ts.do("GET", fmt.Sprintf("/account/%d/holds", merchant.ID), nil, authHeaders(t, merchant))
+	require.Equal(t, http.StatusOK, res.Code)
+	var holds []*types.Hold
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&holds))
+	require.Len(t, holds, 1)
+	assert.Equal(t, hold.Id, holds[0].Id)
+}
@@ -0,0 +1,252 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottlePolicy(t *testing.T) {
+	p := types.EmailLoginPolicy
+	now := time.Now().UTC()
+	l := &types.LoginThrottle{Key: types.EmailThrottleKey("someone@gobank.test")}
+
+	for i := 0; i < p.FreeAttempts; i++ {
+		assert.Zero(t, l.RetryAfter(now, p), "attempt %d", i)
+		l.Fail(now, p)
+	}
+	assert.Equal(t, time.Second, l.RetryAfter(now, p))
+
+	l.Fail(now, p)
+	assert.Equal(t, 2*time.Second, l.RetryAfter(now, p), "the delay doubles")
+	assert.Zero(t, l.RetryAfter(now.Add(2*time.Second), p))
+
+	for l.Failures < p.LockoutAfter-1 {
+		l.Fail(now, p)
+	}
+	assert.Equal(t, p.MaxDelay, l.RetryAfter(now, p))
+	assert.False(t, l.Locked(now))
+
+	l.Fail(now, p)
+	assert.True(t, l.Locked(now))
+	assert.Equal(t, p.LockoutDuration, l.RetryAfter(now, p))
+
+	later := now.Add(p.Window + time.Minute)
+	assert.Zero(t, l.RetryAfter(later, p))
+	l.Fail(later, p)
+	assert.Equal(t, 1, l.Failures, "old failures are forgotten")
+}
+
+func TestLoginFailuresAreUniform(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "uniform@gobank.test")
+
+	var unknown, wrong utility.ApiError
+	res := ts.do("POST", "/login", map[string]string{"email": "nobody@gobank.test", "password": "secret"}, nil)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&unknown))
+
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "wrong"}, nil)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&wrong))
+
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Error, wrong.Error)
+}
+
+func TestLoginIgnoresEmailCase(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "login-case@gobank.test")
+
+	res := ts.do("POST", "/login", map[string]string{"email": "Login-Case@GoBank.test", "password": "wrong"}, nil)
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+	res = ts.do("POST", "/login", map[string]string{"email": "LOGIN-CASE@gobank.test", "password": "secret"}, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	res = ts.do("GET", fmt.Sprintf("/account/%d/logins", acc.ID), nil, authHeaders(t, acc))
+	require.Equal(t, http.StatusOK, res.Code)
+	var history []*types.LoginAttempt
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&history))
+	require.Len(t, history, 2, "attempts in another case belong to the account too")
+	assert.Equal(t, types.LoginSucceeded, history[0].Result)
+	assert.Equal(t, types.LoginFailed, history[1].Result)
+}
+
+func TestClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		trust     string
+		forwarded []string
+		want      string
+	}{
+		{"", []string{"203.0.113.9"}, "192.0.2.1"},
+		{"true", nil, "192.0.2.1"},
+		{"true", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"true", []string{"10.6.6.6, 203.0.113.9"}, "203.0.113.9"},
+		{"true", []string{"10.6.6.6", "203.0.113.9"}, "203.0.113.9"},
+		{"2", []string{"10.6.6.6, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"2", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"no", []string{"203.0.113.9"}, "192.0.2.1"},
+	} {
+		t.Setenv("TRUST_PROXY", tc.trust)
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, f := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		assert.Equal(t, tc.want, utility.ClientIP(r), "TRUST_PROXY=%q X-Forwarded-For=%q", tc.trust, tc.forwarded)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "lockout@gobank.test")
+	admin := newStaffAccount(t, ts.store, "lockout-admin@gobank.test", types.RoleAdmin)
+	wrong := map[string]string{"email": acc.Email, "password": "wrong"}
+
+	for i := 0; i < types.EmailLoginPolicy.FreeAttempts; i++ {
+		assert.Equal(t, http.StatusUnauthorized, ts.do("POST", "/login", wrong, nil).Code)
+	}
+
+	res := ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code, "even the right password has to wait")
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+
+	// failures from a while ago, so the delays have passed and only the
+	// lockout is left
+	key := types.EmailThrottleKey(acc.Email)
+	past := time.Now().UTC().Add(-2 * time.Minute)
+	for i := 0; i < types.EmailLoginPolicy.LockoutAfter; i++ {
+		_, err := ts.store.RecordLoginFailure(key, types.EmailLoginPolicy, past)
+		require.NoError(t, err)
+	}
+
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	require.Equal(t, http.StatusTooManyRequests, res.Code)
+	retry, err := strconv.Atoi(res.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, (13 * time.Minute).Seconds(), retry, 5)
+
+	unlock := fmt.Sprintf("/admin/accounts/%d/unlock", acc.ID)
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", unlock, nil, authHeaders(t, acc)).Code)
+	require.Equal(t, http.StatusOK, ts.do("POST", unlock, nil, authHeaders(t, admin)).Code)
+
+	login(t, ts, acc.Email)
+
+	res = ts.do("GET", fmt.Sprintf("/account/%d/logins", acc.ID), nil, authHeaders(t, acc))
+	require.Equal(t, http.StatusOK, res.Code)
+	var history []*types.LoginAttempt
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&history))
+
+	results := []string{}
+	for _, a := range history {
+		results = append(results, a.Result)
+	}
+	assert.Equal(t, []string{
+		types.LoginSucceeded, types.LoginThrottled, types.LoginThrottled,
+		types.LoginFailed, types.LoginFailed, types.LoginFailed,
+	}, results)
+	assert.Equal(t, "192.0.2.1", history[0].IP)
+
+	other := newTestAccount(t, ts.store, "lockout-other@gobank.test")
+	assert.Equal(t, http.StatusForbidden, ts.do("GET", fmt.Sprintf("/account/%d/logins", acc.ID), nil, authHeaders(t, other)).Code)
+}
+
+func TestLoginThrottledByIP(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "ip-throttle@gobank.test")
+
+	// httptest requests all come from 192.0.2.1
+	for i := 0; i < types.IPLoginPolicy.LockoutAfter; i++ {
+		_, err := ts.store.RecordLoginFailure(types.IPThrottleKey("192.0.2.1"), types.IPLoginPolicy, time.Now().UTC())
+		require.NoError(t, err)
+	}
+
+	res := ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+}
+
+// TestStorageReserveLoginAttemptConcurrently makes sure a burst of attempts
+// cannot all be let in before any of them is counted.
+func TestStorageReserveLoginAttemptConcurrently(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			key := types.EmailThrottleKey("burst-" + name + "@gobank.test")
+			p := types.EmailLoginPolicy
+			now := time.Now().UTC()
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			reserved := 0
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, ok, err := s.ReserveLoginAttempt(key, p, now)
+					assert.NoError(t, err)
+					if ok {
+						mu.Lock()
+						reserved++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, p.FreeAttempts, reserved)
+
+			require.NoError(t, s.RefundLoginAttempt(key, p))
+			counter, err := s.GetLoginThrottle(key)
+			require.NoError(t, err)
+			assert.Equal(t, p.FreeAttempts-1, counter.Failures)
+		})
+	}
+}
+
+func TestConcurrentLoginsAreThrottled(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "burst@gobank.test")
+	wrong := map[string]string{"email": acc.Email, "password": "wrong"}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- ts.do("POST", "/login", wrong, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, types.EmailLoginPolicy.FreeAttempts, counts[http.StatusUnauthorized], "passwords checked")
+	assert.Equal(t, cap(codes)-types.EmailLoginPolicy.FreeAttempts, counts[http.StatusTooManyRequests])
+}
+
+func TestLoginRefundsReservedAttempt(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "refund@gobank.test")
+
+	assert.Equal(t, http.StatusUnauthorized, ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "wrong"}, nil).Code)
+	login(t, ts, acc.Email)
+
+	counter, err := ts.store.GetLoginThrottle(types.IPThrottleKey("192.0.2.1"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, counter.Failures, "only the wrong password counts against the address")
+
+	counter, err = ts.store.GetLoginThrottle(types.EmailThrottleKey(acc.Email))
+	require.NoError(t, err)
+	assert.Zero(t, counter.Failures)
+}
@@ -0,0 +1,255 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the key of the RFC 6238 test vectors, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	code, err := utility.TOTPCode(secret, step)
+	require.NoError(t, err)
+	return code
+}
+
+func TestTOTP(t *testing.T) {
+	// the last six digits of the SHA1 vectors of RFC 6238 appendix B
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		now := time.Unix(unix, 0)
+		assert.Equal(t, want, totpCode(t, rfcSecret, utility.TOTPStep(now)), "at %d", unix)
+
+		step, ok := utility.VerifyTOTP(rfcSecret, want, now.Add(utility.TOTPPeriod))
+		assert.True(t, ok, "a step of drift is allowed")
+		assert.Equal(t, utility.TOTPStep(now), step)
+
+		_, ok = utility.VerifyTOTP(rfcSecret, want, now.Add(3*utility.TOTPPeriod))
+		assert.False(t, ok)
+	}
+
+	uri := utility.TOTPURI(rfcSecret, "someone@gobank.test")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Gobank:someone@gobank.test?"), uri)
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=Gobank")
+
+	codes, err := utility.NewRecoveryCodes(types.RecoveryCodeCount)
+	require.NoError(t, err)
+	assert.Len(t, codes, types.RecoveryCodeCount)
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	assert.Equal(t, codes[0], utility.NormalizeRecoveryCode(typed))
+}
+
+func TestStorageMFA(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "mfa-storage@gobank.test")
+			now := time.Now().UTC()
+
+			_, err := s.GetMFA(acc.AccountNumber)
+			assert.Equal(t, types.KindNotFound, types.KindOf(err))
+
+			require.NoError(t, s.SetMFASecret(&types.MFA{AccountNumber: acc.AccountNumber, Secret: rfcSecret, CreatedAt: now}))
+			require.NoError(t, s.UseTOTPStep(acc.AccountNumber, 10))
+			assert.ErrorIs(t, s.UseTOTPStep(acc.AccountNumber, 10), storage.ErrInvalidMFACode, "codes work once")
+			assert.Error(t, s.UseTOTPStep(acc.AccountNumber, 9))
+
+			require.NoError(t, s.EnableMFA(acc.AccountNumber, []string{utility.HashToken("aaaaa-bbbbb")}, now))
+			m, err := s.GetMFA(acc.AccountNumber)
+			require.NoError(t, err)
+			assert.True(t, m.Enabled())
+			assert.Equal(t, int64(10), m.LastUsedStep)
+
+			err = s.SetMFASecret(&types.MFA{AccountNumber: acc.AccountNumber, Secret: rfcSecret, CreatedAt: now})
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "an enabled enrolment is not replaced")
+
+			require.NoError(t, s.UseRecoveryCode(acc.AccountNumber, utility.HashToken("aaaaa-bbbbb"), now))
+			assert.Error(t, s.UseRecoveryCode(acc.AccountNumber, utility.HashToken("aaaaa-bbbbb"), now))
+
+			challenge := &types.MFAChallenge{
+				Id:            uuid.New(),
+				AccountNumber: acc.AccountNumber,
+				TokenHash:     utility.HashToken("challenge"),
+				CreatedAt:     now,
+				ExpiresAt:     now.Add(time.Minute),
+			}
+			require.NoError(t, s.CreateMFAChallenge(challenge))
+			for i := 0; i < types.MaxMFAAttempts-1; i++ {
+				require.NoError(t, s.FailMFAChallenge(challenge.TokenHash))
+			}
+			_, err = s.GetMFAChallenge(challenge.TokenHash, now)
+			require.NoError(t, err)
+			_, err = s.GetMFAChallenge(challenge.TokenHash, challenge.ExpiresAt)
+			assert.Equal(t, types.KindUnauthorized, types.KindOf(err), "expired")
+
+			require.NoError(t, s.UseMFAChallenge(challenge.TokenHash, now))
+			assert.Error(t, s.UseMFAChallenge(challenge.TokenHash, now), "challenges are single use")
+
+			require.NoError(t, s.DisableMFA(acc.AccountNumber))
+			_, err = s.GetMFA(acc.AccountNumber)
+			assert.Equal(t, types.KindNotFound, types.KindOf(err))
+		})
+	}
+}
+
+func TestMFALoginAndStepUp(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "mfa-login@gobank.test")
+	payee := newTestAccount(t, ts.store, "mfa-payee@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(acc.AccountNumber, usd(t, "5000"))))
+	headers := authHeaders(t, acc)
+
+	transfer := map[string]any{"fromAccount": acc.AccountNumber, "toAccount": payee.AccountNumber, "amount": usd(t, "1500")}
+	res := ts.do("POST", "/transfer", transfer, headers)
+	assert.Equal(t, http.StatusForbidden, res.Code, "large transfers need MFA")
+
+	res = ts.do("POST", "/mfa/enroll", nil, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var enrolment types.MFAEnrollResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&enrolment))
+	assert.Contains(t, enrolment.URI, enrolment.Secret)
+
+	// still pending, so logins are not challenged yet
+	login(t, ts, acc.Email)
+
+	step := utility.TOTPStep(time.Now())
+	assert.Equal(t, http.StatusUnauthorized, ts.do("POST", "/mfa/verify", map[string]string{"code": "000000"}, headers).Code)
+	res = ts.do("POST", "/mfa/verify", map[string]string{"code": totpCode(t, enrolment.Secret, step)}, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var recovery types.RecoveryCodesResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&recovery))
+	require.Len(t, recovery.RecoveryCodes, types.RecoveryCodeCount)
+
+	res = ts.do("POST", "/login", map[string]string{"email": acc.Email, "password": "secret"}, nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var challenge types.MFAChallengeResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&challenge))
+	assert.True(t, challenge.MFARequired)
+	require.NotEmpty(t, challenge.MFAToken)
+
+	res = ts.do("POST", "/login/mfa", map[string]string{"mfa_token": challenge.MFAToken, "code": totpCode(t, enrolment.Secret, step)}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "the code was already used")
+
+	res = ts.do("POST", "/login/mfa", map[string]string{"mfa_token": challenge.MFAToken, "recovery_code": recovery.RecoveryCodes[0]}, nil)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var tokens types.TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+	assert.NotEmpty(t, tokens.Token)
+
+	res = ts.do("POST", "/login/mfa", map[string]string{"mfa_token": challenge.MFAToken, "recovery_code": recovery.RecoveryCodes[1]}, nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "the challenge was used")
+
+	small := map[string]any{"fromAccount": acc.AccountNumber, "toAccount": payee.AccountNumber, "amount": usd(t, "10")}
+	assert.Equal(t, http.StatusOK, ts.do("POST", "/transfer", small, headers).Code, "small transfers need no code")
+
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", "/transfer", transfer, headers).Code)
+
+	stepUp := map[string]string{"x-jwt-token": headers["x-jwt-token"], api.StepUpHeader: totpCode(t, enrolment.Secret, step+1)}
+	res = ts.do("POST", "/transfer", transfer, stepUp)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, ts.do("POST", "/transfer", transfer, stepUp).Code, "no replay")
+
+	res = ts.do("DELETE", "/mfa", map[string]string{"recovery_code": recovery.RecoveryCodes[0]}, headers)
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "recovery codes are single use")
+	res = ts.do("DELETE", "/mfa", map[string]string{"recovery_code": recovery.RecoveryCodes[1]}, headers)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	login(t, ts, acc.Email)
+}
+
+// enableMFA turns on MFA for acc with the RFC 6238 test secret.
+func enableMFA(t *testing.T, s storage.Storage, acc *types.Account) {
+	require.NoError(t, s.SetMFASecret(&types.MFA{AccountNumber: acc.AccountNumber, Secret: rfcSecret, CreatedAt: time.Now().UTC()}))
+	require.NoError(t, s.EnableMFA(acc.AccountNumber, nil, time.Now().UTC()))
+}
+
+// TestStepUpCoversEveryPayment checks that large payments need a code
+// however they are made, not only as transfers.
+func TestStepUpCoversEveryPayment(t *testing.T) {
+	ts := newTestServer(t)
+	payer := newTestAccount(t, ts.store, "stepup-payer@gobank.test")
+	merchant := newTestAccount(t, ts.store, "stepup-merchant@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(payer.AccountNumber, usd(t, "5000"))))
+	enableMFA(t, ts.store, payer)
+
+	refused := func(method, path string, body any, acc *types.Account) {
+		t.Helper()
+		res := ts.do(method, path, body, authHeaders(t, acc))
+		require.Equal(t, http.StatusForbidden, res.Code, "%s %s: %s", method, path, res.Body.String())
+		assert.Equal(t, "mfa_required", decodeError(t, res).Code)
+	}
+
+	hold := map[string]any{"account": payer.AccountNumber, "to_account": merchant.AccountNumber, "amount": usd(t, "1500")}
+	refused("POST", "/holds", hold, payer)
+
+	headers := authHeaders(t, payer)
+	headers[api.StepUpHeader] = totpCode(t, rfcSecret, utility.TOTPStep(time.Now()))
+	res := ts.do("POST", "/holds", hold, headers)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	var placed types.Hold
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&placed))
+
+	// the payer already approved the hold, so the payee needs no MFA
+	capture := fmt.Sprintf("/holds/%s/capture", placed.Id)
+	res = ts.do("POST", capture, map[string]any{"amount": usd(t, "1200")}, authHeaders(t, merchant))
+	assert.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	orders := fmt.Sprintf("/account/%d/standing-orders", payer.ID)
+	order := map[string]any{
+		"to_account": merchant.AccountNumber,
+		"amount":     usd(t, "1500"),
+		"frequency":  types.FrequencyMonthly,
+		"start_at":   time.Now().UTC().Add(24 * time.Hour),
+	}
+	refused("POST", orders, order, payer)
+
+	order["amount"] = usd(t, "10")
+	res = ts.do("POST", orders, order, authHeaders(t, payer))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	var created types.StandingOrder
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	path := fmt.Sprintf("%s/%s", orders, created.Id)
+	refused("PATCH", path, map[string]any{"amount": usd(t, "1500")}, payer)
+	res = ts.do("PATCH", path, map[string]any{"description": "Rent"}, authHeaders(t, payer))
+	assert.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	account := fmt.Sprintf("/account/%d", payer.ID)
+	closing := map[string]any{"payout_account": merchant.AccountNumber}
+	refused("DELETE", account, closing, payer)
+
+	headers = authHeaders(t, payer)
+	headers[api.StepUpHeader] = totpCode(t, rfcSecret, utility.TOTPStep(time.Now())+1)
+	res = ts.do("DELETE", account, closing, headers)
+	assert.Equal(t, http.StatusOK, res.Code, res.Body.String())
+}
+
+func TestStepUpThresholdIsValidated(t *testing.T) {
+	for _, v := range []string{"1000.50", "1e3", "1,000", "-5"} {
+		t.Setenv("MFA_STEP_UP_THRESHOLD", v)
+		_, err := api.NewApiServer(":0", storage.NewMemoryStorage())
+		assert.Error(t, err, v)
+	}
+
+	t.Setenv("MFA_STEP_UP_THRESHOLD", "250")
+	_, err := api.NewApiServer(":0", storage.NewMemoryStorage())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrkhay/gobank/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func migrationFileNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// TestSQLiteMigrationsFollowPostgres makes sure every postgres migration
+// has a SQLite one of the same version and name, so a schema change cannot
+// reach one database and miss the other.
+func TestSQLiteMigrationsFollowPostgres(t *testing.T) {
+	postgres := migrationFileNames(t, "../storage/migrations")
+	sqlite := migrationFileNames(t, "../storage/migrations/sqlite")
+
+	assert.NotEmpty(t, postgres)
+	assert.Equal(t, postgres, sqlite)
+}
+
+// TestSQLiteMigrateDownAndUp rolls the SQLite migrations back past the
+// ledger and applies them again, which has to carry the balance over.
+func TestSQLiteMigrateDownAndUp(t *testing.T) {
+	s, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "gobank.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	require.NoError(t, s.Init())
+
+	acc := newTestAccount(t, s, "migrate@gobank.test")
+	require.NoError(t, s.TopUpAccount(topUp(acc.AccountNumber, usd(t, "25"))))
+
+	status, err := s.MigrationStatus()
+	require.NoError(t, err)
+
+	// down to 0002_money, where balances were stored on the account
+	require.NoError(t, s.MigrateDown(len(status)-2))
+	require.NoError(t, s.MigrateUp())
+
+	got, err := s.GetAccountByID(acc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, usd(t, "25"), got.Balance)
+
+	require.NoError(t, s.MigrateDown(len(status)))
+	status, err = s.MigrationStatus()
+	require.NoError(t, err)
+	for _, m := range status {
+		assert.Nil(t, m.AppliedAt, "%04d_%s", m.Version, m.Name)
+	}
+
+	require.NoError(t, s.MigrateUp())
+	newTestAccount(t, s, "migrate@gobank.test")
+}
+
+// TestSQLiteEmailUniqueNeedsDistinctEmails stops 0020_email_unique with a
+// clear message when two accounts already share an email in another case.
+func TestSQLiteEmailUniqueNeedsDistinctEmails(t *testing.T) {
+	s, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "gobank.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	require.NoError(t, s.Init())
+
+	status, err := s.MigrationStatus()
+	require.NoError(t, err)
+	steps := 0
+	for _, m := range status {
+		if m.Version >= 20 {
+			steps++
+		}
+	}
+	require.NoError(t, s.MigrateDown(steps))
+
+	newTestAccount(t, s, "twice@gobank.test")
+	newTestAccount(t, s, "Twice@GoBank.test")
+
+	err = s.MigrateUp()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0020_email_unique")
+	assert.Contains(t, err.Error(), "differ only in case")
+}
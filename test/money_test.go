@@ -0,0 +1,93 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		amount   string
+		currency string
+		minor    int64
+		ok       bool
+	}{
+		{"12.34", "USD", 1234, true},
+		{"12.3", "USD", 1230, true},
+		{"12", "USD", 1200, true},
+		{"-0.05", "USD", -5, true},
+		{"1500", "JPY", 1500, true},
+		{"1.234", "KWD", 1234, true},
+		{"12.345", "USD", 0, false},
+		{"1.5", "JPY", 0, false},
+		{"$1,234.00", "USD", 0, false},
+		{"1e3", "USD", 0, false},
+		{" 12", "USD", 0, false},
+		{"12.", "USD", 0, false},
+		{"1", "XYZ", 0, false},
+		{"99999999999999999999", "USD", 0, false},
+	}
+
+	for _, c := range cases {
+		m, err := types.ParseMoney(c.amount, c.currency)
+		if !c.ok {
+			assert.Error(t, err, c.amount)
+			continue
+		}
+		require.NoError(t, err, c.amount)
+		assert.Equal(t, c.minor, m.Amount, c.amount)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "0.05", types.NewMoney(5, "USD").String())
+	assert.Equal(t, "-12.30", types.NewMoney(-1230, "USD").String())
+	assert.Equal(t, "1500", types.NewMoney(1500, "JPY").String())
+	assert.Equal(t, "0.001", types.NewMoney(1, "KWD").String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var m types.Money
+	require.NoError(t, json.Unmarshal([]byte(`{"amount":"10.50","currency":"EUR"}`), &m))
+	assert.Equal(t, types.NewMoney(1050, "EUR"), m)
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"amount":"10.50","currency":"EUR"}`, string(b))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"amount":10.5,"currency":"EUR"}`), &m), "numbers must be rejected")
+	assert.Error(t, json.Unmarshal([]byte(`"10.50"`), &m))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount":"10.50"}`), &m))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount":"10.50","currency":"EUR","x":1}`), &m))
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	sum, err := types.NewMoney(150, "USD").Add(types.NewMoney(250, "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(400), sum.Amount)
+
+	_, err = types.NewMoney(1, "USD").Add(types.NewMoney(1, "EUR"))
+	assert.Error(t, err)
+}
+
+func TestJournalEntryMustBalance(t *testing.T) {
+	_, err := types.NewJournalEntry(types.EntryKindTransfer, "", nil,
+		types.Posting{Account: 1, Amount: types.NewMoney(-100, "USD")},
+		types.Posting{Account: 2, Amount: types.NewMoney(90, "USD")},
+	)
+	assert.Error(t, err)
+
+	_, err = types.NewJournalEntry(types.EntryKindTransfer, "", nil,
+		types.Posting{Account: 1, Amount: types.NewMoney(-100, "USD")},
+		types.Posting{Account: 2, Amount: types.NewMoney(100, "EUR")},
+	)
+	assert.Error(t, err, "postings must balance per currency")
+
+	entry, err := types.NewTransferEntry(types.EntryKindTransfer, "", nil, 1, 2, types.NewMoney(100, "USD"))
+	require.NoError(t, err)
+	assert.Len(t, entry.Postings, 2)
+}
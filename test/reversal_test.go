@@ -0,0 +1,204 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mrkhay/gobank/api"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func balanceOf(t *testing.T, s storage.Storage, acc *types.Account) types.Money {
+	got, err := s.GetAccountByID(acc.ID)
+	require.NoError(t, err)
+	return got.Balance
+}
+
+func TestStorageRefunds(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "refund-from@gobank.test")
+			to := newTestAccount(t, s, "refund-to@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+
+			tran, err := s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "40"),
+			})
+			require.NoError(t, err)
+
+			_, err = s.RefundTransaction(tran.Id, ptr(usd(t, "50")))
+			assert.Equal(t, types.KindValidation, types.KindOf(err), "more than was sent")
+
+			refund, err := s.RefundTransaction(tran.Id, ptr(usd(t, "10")))
+			require.NoError(t, err)
+			assert.Equal(t, tran.Id, *refund.OriginalId)
+			assert.Equal(t, to.AccountNumber, refund.Sen_acc.AccountNumber)
+			assert.Equal(t, from.AccountNumber, refund.Rec_acc.AccountNumber)
+
+			original, err := s.GetTransaction(tran.Id)
+			require.NoError(t, err)
+			assert.Equal(t, types.StatusPartiallyRefunded, original.Status)
+			assert.Equal(t, usd(t, "10"), *original.Refunded)
+
+			_, err = s.RefundTransaction(refund.Id, nil)
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "a refund cannot be refunded")
+
+			_, err = s.RefundTransaction(tran.Id, nil)
+			require.NoError(t, err)
+
+			original, err = s.GetTransaction(tran.Id)
+			require.NoError(t, err)
+			assert.Equal(t, types.StatusRefunded, original.Status)
+
+			_, err = s.RefundTransaction(tran.Id, nil)
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+			_, err = s.ReverseTransaction(tran.Id)
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+
+			assert.Equal(t, usd(t, "100"), balanceOf(t, s, from))
+			assert.Equal(t, usd(t, "0"), balanceOf(t, s, to))
+
+			history, err := s.GetUserTransactions(int(to.AccountNumber))
+			require.NoError(t, err)
+			assert.Len(t, history, 3)
+		})
+	}
+}
+
+func TestStorageReversals(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "reverse-from@gobank.test")
+			to := newTestAccount(t, s, "reverse-to@gobank.test")
+			third := newTestAccount(t, s, "reverse-third@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+
+			tran, err := s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "60"),
+			})
+			require.NoError(t, err)
+
+			_, err = s.RefundTransaction(tran.Id, ptr(usd(t, "15")))
+			require.NoError(t, err)
+
+			// the receiver spent most of what was left
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(to.AccountNumber),
+				ToAccount:   int(third.AccountNumber),
+				Amount:      usd(t, "40"),
+			})
+			require.NoError(t, err)
+
+			_, err = s.ReverseTransaction(tran.Id)
+			assert.Equal(t, types.KindInsufficientFunds, types.KindOf(err))
+
+			require.NoError(t, s.TopUpAccount(topUp(to.AccountNumber, usd(t, "40"))))
+
+			reversal, err := s.ReverseTransaction(tran.Id)
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "45"), reversal.Amount, "only what was not refunded yet")
+			assert.Equal(t, types.DescriptionReversal, reversal.Description)
+
+			_, err = s.ReverseTransaction(tran.Id)
+			assert.Equal(t, types.KindConflict, types.KindOf(err), "no double reversal")
+			_, err = s.ReverseTransaction(reversal.Id)
+			assert.Equal(t, types.KindConflict, types.KindOf(err))
+
+			original, err := s.GetTransaction(tran.Id)
+			require.NoError(t, err)
+			assert.Equal(t, types.StatusReversed, original.Status)
+
+			assert.Equal(t, usd(t, "100"), balanceOf(t, s, from))
+			assert.Equal(t, usd(t, "0"), balanceOf(t, s, to))
+
+			entries, err := s.GetJournalEntries(int(to.AccountNumber))
+			require.NoError(t, err)
+			assert.Equal(t, types.EntryKindReversal, entries[len(entries)-1].Kind)
+		})
+	}
+}
+
+func TestStorageRefundConverted(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "refund-fx-from@gobank.test")
+			to, err := types.NewAccount("first", "last", "refund-fx-to@gobank.test", "secret")
+			require.NoError(t, err)
+			to.Balance = types.NewMoney(0, "EUR")
+			require.NoError(t, s.CreateAccount(to))
+
+			require.NoError(t, s.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+			require.NoError(t, s.SetFXRate(fxRate(t, "USD", "EUR", "0.9", 100)))
+
+			tran, err := s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "100"),
+			})
+			require.NoError(t, err)
+
+			// rates move, but refunds are made at the original rate
+			require.NoError(t, s.SetFXRate(fxRate(t, "EUR", "USD", "2", 0)))
+
+			for _, part := range []string{"0.01", "33.33", "33.33"} {
+				refund, err := s.RefundTransaction(tran.Id, ptr(money(t, part, "EUR")))
+				require.NoError(t, err)
+				assert.Equal(t, "USD", refund.Converted.Currency)
+			}
+
+			_, err = s.RefundTransaction(tran.Id, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, usd(t, "100"), balanceOf(t, s, from), "a full refund returns exactly what was paid")
+			assert.Equal(t, money(t, "0", "EUR"), balanceOf(t, s, to))
+		})
+	}
+}
+
+func TestReversalEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	operator := newStaffAccount(t, ts.store, "reversal-operator@gobank.test", types.RoleOperator)
+	from := newTestAccount(t, ts.store, "reversal-from@gobank.test")
+	to := newTestAccount(t, ts.store, "reversal-to@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+
+	transfer := func() *types.Transcation {
+		tran, err := ts.store.Transfer(&types.TransferRequest{
+			FromAccount: int(from.AccountNumber),
+			ToAccount:   int(to.AccountNumber),
+			Amount:      usd(t, "20"),
+		})
+		require.NoError(t, err)
+		return tran
+	}
+
+	tran := transfer()
+	reverse := fmt.Sprintf("/transactions/%s/reverse", tran.Id)
+	refund := fmt.Sprintf("/transactions/%s/refund", tran.Id)
+
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", reverse, nil, authHeaders(t, from)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", refund, nil, authHeaders(t, from)).Code, "only the recipient refunds")
+
+	res := ts.do("POST", refund, map[string]any{"amount": usd(t, "5")}, authHeaders(t, to))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+
+	var body api.ReversalResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.Equal(t, types.StatusPartiallyRefunded, body.Original.Status)
+	assert.Equal(t, usd(t, "5"), body.Transaction.Amount)
+
+	res = ts.do("POST", reverse, nil, authHeaders(t, operator))
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	assert.Equal(t, http.StatusConflict, ts.do("POST", reverse, nil, authHeaders(t, operator)).Code)
+
+	assert.Equal(t, http.StatusBadRequest, ts.do("POST", "/transactions/nope/reverse", nil, authHeaders(t, operator)).Code)
+}
@@ -0,0 +1,290 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrkhay/gobank/scheduler"
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	subjects []string
+}
+
+func (n *recordingNotifier) Notify(acc *types.Account, subject, message string) error {
+	n.subjects = append(n.subjects, subject)
+	return nil
+}
+
+func newStandingOrder(t *testing.T, s storage.Storage, from, to *types.Account, req types.CreateStandingOrderRequest, now time.Time) *types.StandingOrder {
+	req.ToAccount = to.AccountNumber
+	order, err := types.NewStandingOrder(from.AccountNumber, &req, now)
+	require.NoError(t, err)
+	require.NoError(t, s.CreateStandingOrder(order))
+	return order
+}
+
+func TestStandingOrderMonthlyKeepsDay(t *testing.T) {
+	order := &types.StandingOrder{
+		Frequency: types.FrequencyMonthly,
+		StartAt:   time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC),
+	}
+
+	assert.Equal(t, time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC), order.RunAt(1))
+	assert.Equal(t, time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC), order.RunAt(2))
+	assert.Equal(t, time.Date(2027, 1, 31, 9, 0, 0, 0, time.UTC), order.RunAt(12))
+}
+
+func TestSchedulerRunsStandingOrders(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.Init())
+	from := newTestAccount(t, store, "so-from@gobank.test")
+	to := newTestAccount(t, store, "so-to@gobank.test")
+	require.NoError(t, store.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+
+	now := time.Now().UTC()
+	start := now.Add(time.Minute)
+	order := newStandingOrder(t, store, from, to, types.CreateStandingOrderRequest{
+		Amount:    usd(t, "10"),
+		Frequency: types.FrequencyDaily,
+		StartAt:   start,
+		Count:     3,
+	}, now)
+
+	notifier := &recordingNotifier{}
+	sched := scheduler.New(store, notifier)
+
+	require.NoError(t, sched.RunDue(now))
+	history, err := store.GetUserTransactions(int(to.AccountNumber))
+	require.NoError(t, err)
+	assert.Empty(t, history, "not due yet")
+
+	for day := 0; day < 5; day++ {
+		require.NoError(t, sched.RunDue(start.AddDate(0, 0, day)))
+		// running twice at the same time must not pay twice
+		require.NoError(t, sched.RunDue(start.AddDate(0, 0, day)))
+	}
+
+	history, err = store.GetUserTransactions(int(to.AccountNumber))
+	require.NoError(t, err)
+	assert.Len(t, history, 3)
+
+	got, err := store.GetStandingOrder(order.Id)
+	require.NoError(t, err)
+	assert.Equal(t, types.StandingOrderCompleted, got.Status)
+	assert.Equal(t, 3, got.RunsDone)
+
+	runs, err := store.GetStandingOrderRuns(order.Id)
+	require.NoError(t, err)
+	assert.Len(t, runs, 3)
+	assert.Equal(t, []string{"Standing order paid", "Standing order paid", "Standing order paid"}, notifier.subjects)
+}
+
+func TestSchedulerRetriesFailedStandingOrders(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.Init())
+	from := newTestAccount(t, store, "so-poor@gobank.test")
+	to := newTestAccount(t, store, "so-payee@gobank.test")
+
+	now := time.Now().UTC()
+	order := newStandingOrder(t, store, from, to, types.CreateStandingOrderRequest{
+		Amount:    usd(t, "10"),
+		Frequency: types.FrequencyOnce,
+		StartAt:   now,
+	}, now)
+
+	notifier := &recordingNotifier{}
+	sched := scheduler.New(store, notifier)
+
+	at := now
+	for attempt := 1; attempt <= types.MaxStandingOrderAttempts; attempt++ {
+		require.NoError(t, sched.RunDue(at))
+
+		got, err := store.GetStandingOrder(order.Id)
+		require.NoError(t, err)
+		assert.Equal(t, "insufficient funds", got.LastError)
+		at = got.NextRunAt
+	}
+
+	got, err := store.GetStandingOrder(order.Id)
+	require.NoError(t, err)
+	assert.Equal(t, types.StandingOrderFailed, got.Status)
+
+	runs, err := store.GetStandingOrderRuns(order.Id)
+	require.NoError(t, err)
+	assert.Len(t, runs, types.MaxStandingOrderAttempts)
+	assert.Equal(t, []string{"Standing order failed"}, notifier.subjects)
+}
+
+func TestStandingOrderEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	owner := newTestAccount(t, ts.store, "so-owner@gobank.test")
+	payee := newTestAccount(t, ts.store, "so-api-payee@gobank.test")
+
+	path := fmt.Sprintf("/account/%d/standing-orders", owner.ID)
+	res := ts.do("POST", path, map[string]any{
+		"to_account": payee.AccountNumber,
+		"amount":     map[string]string{"amount": "12.50", "currency": "USD"},
+		"frequency":  "weekly",
+		"start_at":   time.Now().UTC().Add(24 * time.Hour),
+		"count":      4,
+	}, authHeaders(t, owner))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var order types.StandingOrder
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&order))
+	assert.Equal(t, types.StandingOrderActive, order.Status)
+
+	var list []*types.StandingOrder
+	res = ts.do("GET", path, nil, authHeaders(t, owner))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	assert.Len(t, list, 1)
+
+	one := path + "/" + order.Id.String()
+	res = ts.do("PATCH", one, map[string]any{"status": "paused"}, authHeaders(t, owner))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `"status":"paused"`)
+
+	assert.Equal(t, http.StatusBadRequest, ts.do("PATCH", one, map[string]any{"status": "completed"}, authHeaders(t, owner)).Code)
+	assert.Equal(t, http.StatusOK, ts.do("DELETE", one, nil, authHeaders(t, owner)).Code)
+	assert.Equal(t, http.StatusConflict, ts.do("PATCH", one, map[string]any{"status": "active"}, authHeaders(t, owner)).Code)
+
+	// someone else's order is invisible, even through their own account path
+	theirs := fmt.Sprintf("/account/%d/standing-orders/%s", payee.ID, order.Id)
+	assert.Equal(t, http.StatusNotFound, ts.do("GET", theirs, nil, authHeaders(t, payee)).Code)
+
+	res = ts.do("POST", path, map[string]any{
+		"to_account": payee.AccountNumber,
+		"amount":     map[string]string{"amount": "1", "currency": "USD"},
+		"frequency":  "hourly",
+		"start_at":   time.Now().UTC().Add(time.Hour),
+	}, authHeaders(t, owner))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestStandingOrderInSubBalanceCurrency(t *testing.T) {
+	ts := newTestServer(t)
+	owner := newTestAccount(t, ts.store, "so-eur@gobank.test")
+	payee := newTestAccount(t, ts.store, "so-eur-payee@gobank.test")
+
+	require.NoError(t, ts.store.TopUpAccount(topUp(owner.AccountNumber, usd(t, "100"))))
+	require.NoError(t, ts.store.SetFXRate(fxRate(t, "USD", "EUR", "0.9", 0)))
+	_, err := ts.store.Transfer(&types.TransferRequest{
+		FromAccount: int(owner.AccountNumber),
+		ToAccount:   int(owner.AccountNumber),
+		Amount:      usd(t, "50"),
+		ToCurrency:  "EUR",
+	})
+	require.NoError(t, err)
+
+	path := fmt.Sprintf("/account/%d/standing-orders", owner.ID)
+	order := func(currency string) *httptest.ResponseRecorder {
+		return ts.do("POST", path, map[string]any{
+			"to_account": payee.AccountNumber,
+			"amount":     map[string]string{"amount": "5", "currency": currency},
+			"frequency":  "monthly",
+			"start_at":   time.Now().UTC().Add(time.Hour),
+		}, authHeaders(t, owner))
+	}
+
+	res := order("EUR")
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	assert.Contains(t, res.Body.String(), `"currency":"EUR"`)
+
+	res = order("GBP")
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "currency_mismatch", decodeError(t, res).Code)
+}
+
+// TestStoragePayStandingOrderRunOnce retries a run whose order was never
+// saved as paid, long after any idempotency record would have expired.
+func TestStoragePayStandingOrderRunOnce(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "so-once@gobank.test")
+			to := newTestAccount(t, s, "so-once-payee@gobank.test")
+			require.NoError(t, s.TopUpAccount(topUp(from.AccountNumber, usd(t, "100"))))
+
+			now := time.Now().UTC()
+			order := newStandingOrder(t, s, from, to, types.CreateStandingOrderRequest{
+				Amount:    usd(t, "10"),
+				Frequency: types.FrequencyDaily,
+				StartAt:   now,
+			}, now)
+
+			sched := scheduler.New(s, &recordingNotifier{})
+			require.NoError(t, sched.RunDue(now))
+
+			// the order goes back to before the run, as if saving it failed
+			saved, err := s.GetStandingOrder(order.Id)
+			require.NoError(t, err)
+			order.Version = saved.Version
+			require.NoError(t, s.UpdateStandingOrder(order))
+			later := now.Add(72 * time.Hour)
+			require.NoError(t, sched.RunDue(later))
+
+			history, err := s.GetUserTransactions(int(to.AccountNumber))
+			require.NoError(t, err)
+			assert.Len(t, history, 1)
+
+			got, err := s.GetStandingOrder(order.Id)
+			require.NoError(t, err)
+			assert.Equal(t, 1, got.RunsDone)
+
+			_, err = s.PayStandingOrderRun(&types.StandingOrderRun{
+				Id: uuid.New(), OrderId: order.Id, Run: 0, Attempt: 1, CreatedAt: later,
+			}, &types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "10"),
+			})
+			assert.ErrorIs(t, err, storage.ErrStandingOrderRunPaid)
+		})
+	}
+}
+
+// TestStorageStandingOrderVersion cancels an order while the scheduler has
+// it claimed; the scheduler's save must not bring it back.
+func TestStorageStandingOrderVersion(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "so-version@gobank.test")
+			to := newTestAccount(t, s, "so-version-payee@gobank.test")
+
+			now := time.Now().UTC()
+			newStandingOrder(t, s, from, to, types.CreateStandingOrderRequest{
+				Amount:    usd(t, "10"),
+				Frequency: types.FrequencyDaily,
+				StartAt:   now,
+			}, now)
+
+			claimed, err := s.ClaimDueStandingOrders(now, time.Minute, 10)
+			require.NoError(t, err)
+			require.Len(t, claimed, 1)
+
+			owned, err := s.GetStandingOrder(claimed[0].Id)
+			require.NoError(t, err)
+			owned.Status = types.StandingOrderCancelled
+			require.NoError(t, s.UpdateStandingOrder(owned))
+
+			claimed[0].Succeeded(now)
+			claimed[0].LockedUntil = nil
+			assert.ErrorIs(t, s.UpdateStandingOrder(claimed[0]), storage.ErrStandingOrderConflict)
+
+			got, err := s.GetStandingOrder(owned.Id)
+			require.NoError(t, err)
+			assert.Equal(t, types.StandingOrderCancelled, got.Status)
+			assert.Zero(t, got.RunsDone)
+		})
+	}
+}
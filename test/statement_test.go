@@ -0,0 +1,100 @@
+package test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statementTransaction(t *testing.T, from, to int, amount string, date time.Time) *types.Transcation {
+	tran, err := types.NewTransaction(&from, &to, usd(t, amount), "Credit", "test")
+	require.NoError(t, err)
+	tran.Date = date
+	return tran
+}
+
+func TestNewStatement(t *testing.T) {
+	acc := &types.Account{AccountNumber: 1000000018, Balance: usd(t, "0")}
+	day := func(d int) time.Time { return time.Date(2026, 9, d, 12, 0, 0, 0, time.UTC) }
+
+	history := []*types.Transcation{
+		statementTransaction(t, 2000, 1000000018, "25", day(20)),
+		statementTransaction(t, -1, 1000000018, "100", day(1)),
+		statementTransaction(t, 1000000018, 2000, "40", day(10)),
+		statementTransaction(t, 2000, 3000, "999", day(11)), // not ours
+		statementTransaction(t, 1000000018, 2000, "5", day(30)),
+	}
+
+	st, err := types.NewStatement(acc, "USD", history, day(5), day(25))
+	require.NoError(t, err)
+
+	assert.Equal(t, usd(t, "100"), st.Opening)
+	require.Len(t, st.Lines, 2)
+	assert.Equal(t, usd(t, "-40"), st.Lines[0].Amount)
+	assert.Equal(t, usd(t, "60"), st.Lines[0].Balance)
+	assert.Equal(t, int64(2000), st.Lines[0].Counterparty)
+	assert.Equal(t, usd(t, "85"), st.Lines[1].Balance)
+	assert.Equal(t, usd(t, "85"), st.Closing)
+}
+
+func TestStatementEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "statement@gobank.test")
+	other := newTestAccount(t, ts.store, "statement-other@gobank.test")
+
+	require.NoError(t, ts.store.TopUpAccount(topUp(acc.AccountNumber, usd(t, "100"))))
+	_, err := ts.store.Transfer(&types.TransferRequest{
+		FromAccount: int(acc.AccountNumber),
+		ToAccount:   int(other.AccountNumber),
+		Amount:      usd(t, "30"),
+	})
+	require.NoError(t, err)
+
+	from := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	to := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	path := fmt.Sprintf("/account/%d/statement?from=%s&to=%s", acc.ID, from, to)
+
+	res := ts.do("GET", path, nil, authHeaders(t, acc))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/csv", res.Header().Get("Content-Type"))
+
+	rows, err := csv.NewReader(res.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 5, "header, opening, two transactions, closing")
+	assert.Equal(t, "0.00", rows[1][6])
+	assert.Equal(t, "100.00", rows[2][6])
+	assert.Equal(t, "-30.00", rows[3][4])
+	assert.Equal(t, "70.00", rows[4][6])
+
+	res = ts.do("GET", path+"&format=pdf", nil, authHeaders(t, acc))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/pdf", res.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(res.Body.Bytes(), []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(res.Body.Bytes(), []byte("%%EOF\n")))
+	assert.Contains(t, res.Body.String(), "Closing balance")
+
+	assert.Equal(t, http.StatusBadRequest, ts.do("GET", path+"&format=xls", nil, authHeaders(t, acc)).Code)
+	assert.Equal(t, http.StatusForbidden, ts.do("GET", path, nil, authHeaders(t, other)).Code)
+}
+
+func TestWriteTextPDFPaginates(t *testing.T) {
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line (%d) \\ é", i)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, utility.WriteTextPDF(&buf, lines))
+
+	pdf := buf.String()
+	assert.Contains(t, pdf, "/Count 4")
+	assert.Contains(t, pdf, `(line \(7\) \\ ?) '`)
+}
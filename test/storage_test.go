@@ -0,0 +1,302 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorages returns every backend the conformance tests should run
+// against. Postgres is only included when POSTGRES_URI is set; SQLite gets
+// a fresh file for every test.
+func testStorages(t *testing.T) map[string]storage.Storage {
+	stores := map[string]storage.Storage{
+		"memory": storage.NewMemoryStorage(),
+	}
+
+	lite, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "gobank.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { lite.Close() })
+	stores["sqlite"] = lite
+
+	if os.Getenv("POSTGRES_URI") != "" {
+		pg, err := storage.NewPostgresStorage()
+		require.NoError(t, err)
+		stores["postgres"] = pg
+	}
+
+	for _, s := range stores {
+		require.NoError(t, s.Init())
+	}
+
+	return stores
+}
+
+func newTestAccount(t *testing.T, s storage.Storage, email string) *types.Account {
+	acc, err := types.NewAccount("first", "last", email, "secret")
+	require.NoError(t, err)
+	require.NoError(t, s.CreateAccount(acc))
+	return acc
+}
+
+func usd(t *testing.T, amount string) types.Money {
+	m, err := types.ParseMoney(amount, "USD")
+	require.NoError(t, err)
+	return m
+}
+
+func topUp(account int64, amount types.Money) *types.TopUpRequest {
+	return &types.TopUpRequest{Account: int(account), Amount: amount}
+}
+
+func TestStorageAccounts(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "accounts@gobank.test")
+
+			got, err := s.GetAccountByID(acc.ID)
+			require.NoError(t, err)
+			assert.Equal(t, acc.AccountNumber, got.AccountNumber)
+			assert.Equal(t, acc.Email, got.Email)
+
+			num, err := s.GetAccountByNumber(int(acc.AccountNumber))
+			require.NoError(t, err)
+			assert.Equal(t, int(acc.AccountNumber), *num)
+
+			exists, err := s.CheckIfEmailExists(acc.Email)
+			require.NoError(t, err)
+			assert.True(t, exists)
+
+			_, err = s.GetAccountByPasswordAndEmail(&types.LoginRequest{Email: acc.Email, Pasword: "secret"})
+			assert.NoError(t, err)
+			_, err = s.GetAccountByPasswordAndEmail(&types.LoginRequest{Email: acc.Email, Pasword: "wrong"})
+			assert.Error(t, err)
+
+			closed, err := s.CloseAccount(acc.ID, &types.CloseAccountRequest{}, time.Now().UTC())
+			require.NoError(t, err)
+			assert.Equal(t, types.AccountClosed, closed.Status)
+			got, err = s.GetAccountByID(acc.ID)
+			require.NoError(t, err, "closed accounts are kept")
+			assert.Equal(t, types.AccountClosed, got.Status)
+		})
+	}
+}
+
+// TestStorageEmailUnique checks the storage itself keeps emails unique in
+// any case, for requests that race past the handlers' checks.
+func TestStorageEmailUnique(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "unique@gobank.test")
+			other := newTestAccount(t, s, "other-unique@gobank.test")
+
+			dup, err := types.NewAccount("first", "last", "Unique@GoBank.test", "secret")
+			require.NoError(t, err)
+			err = s.CreateAccount(dup)
+			assert.ErrorIs(t, err, storage.ErrEmailInUse)
+
+			exists, err := s.CheckIfEmailExists("UNIQUE@gobank.test")
+			require.NoError(t, err)
+			assert.True(t, exists)
+
+			other.Email = "unique@GOBANK.test"
+			err = s.UpdateAccount(other)
+			assert.ErrorIs(t, err, storage.ErrEmailInUse)
+
+			acc.Email = "Unique@gobank.test"
+			require.NoError(t, s.UpdateAccount(acc))
+		})
+	}
+}
+
+func TestStorageGetAccountByEmail(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "Mixed.Case@GoBank.test")
+
+			got, err := s.GetAccountByEmail("mixed.case@gobank.test")
+			require.NoError(t, err)
+			assert.Equal(t, acc.AccountNumber, got.AccountNumber)
+			assert.Equal(t, "Mixed.Case@GoBank.test", got.Email)
+
+			_, err = s.GetAccountByEmail("nobody@gobank.test")
+			assert.Equal(t, types.KindNotFound, types.KindOf(err))
+		})
+	}
+}
+
+func TestStorageTransfer(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			from := newTestAccount(t, s, "from@gobank.test")
+			to := newTestAccount(t, s, "to@gobank.test")
+
+			require.NoError(t, s.TopUpAccount(&types.TopUpRequest{Account: int(from.AccountNumber), Amount: usd(t, "100")}))
+
+			tran, err := s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "40"),
+			})
+			require.NoError(t, err)
+			assert.Equal(t, from.AccountNumber, tran.Sen_acc.AccountNumber)
+			assert.Equal(t, to.AccountNumber, tran.Rec_acc.AccountNumber)
+
+			_, err = s.Transfer(&types.TransferRequest{
+				FromAccount: int(from.AccountNumber),
+				ToAccount:   int(to.AccountNumber),
+				Amount:      usd(t, "1000"),
+			})
+			assert.Error(t, err, "transfer above the balance must fail")
+
+			history, err := s.GetUserTransactions(int(to.AccountNumber))
+			require.NoError(t, err)
+			assert.Len(t, history, 1)
+
+			got, err := s.GetAccountByID(from.ID)
+			require.NoError(t, err)
+			assert.Equal(t, usd(t, "60"), got.Balance)
+
+			entries, err := s.GetJournalEntries(int(from.AccountNumber))
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			assert.Equal(t, types.EntryKindTopUp, entries[0].Kind)
+			assert.Equal(t, types.SystemAccountTopUp, entries[0].Postings[0].Account)
+			for _, e := range entries {
+				assert.NoError(t, e.Validate())
+			}
+		})
+	}
+}
+
+// TestStorageConcurrentTransfers moves money back and forth between a few
+// accounts from many goroutines at once. Whatever interleaving happens, no
+// balance may go negative and the total must be unchanged.
+func TestStorageConcurrentTransfers(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			accounts := make([]*types.Account, 4)
+			for i := range accounts {
+				accounts[i] = newTestAccount(t, s, fmt.Sprintf("stress-%d@gobank.test", i))
+				require.NoError(t, s.TopUpAccount(topUp(accounts[i].AccountNumber, usd(t, "100"))))
+			}
+
+			amount := usd(t, "7.5")
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 400)
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						from := accounts[(w+i)%len(accounts)]
+						to := accounts[(w+i+1+w%2)%len(accounts)]
+						// odd workers send the other way round
+						if w%2 == 1 {
+							from, to = to, from
+						}
+
+						_, err := s.Transfer(&types.TransferRequest{
+							FromAccount: int(from.AccountNumber),
+							ToAccount:   int(to.AccountNumber),
+							Amount:      amount,
+						})
+						if err != nil && types.KindOf(err) != types.KindInsufficientFunds {
+							errs <- err
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+
+			total := usd(t, "0")
+			for _, acc := range accounts {
+				got, err := s.GetAccountByID(acc.ID)
+				require.NoError(t, err)
+				assert.False(t, got.Balance.IsNegative(), "account %d is overdrawn", acc.AccountNumber)
+
+				total, err = total.Add(got.Balance)
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, usd(t, "400"), total)
+		})
+	}
+}
+
+func TestStorageTransactionPages(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			acc := newTestAccount(t, s, "pages@gobank.test")
+			other := newTestAccount(t, s, "pages-other@gobank.test")
+
+			for i := 0; i < 5; i++ {
+				require.NoError(t, s.TopUpAccount(topUp(acc.AccountNumber, usd(t, fmt.Sprint(10+i)))))
+			}
+			_, err := s.Transfer(&types.TransferRequest{
+				FromAccount: int(acc.AccountNumber),
+				ToAccount:   int(other.AccountNumber),
+				Amount:      usd(t, "3"),
+			})
+			require.NoError(t, err)
+
+			// walk all pages and check nothing is skipped or repeated
+			seen := map[string]bool{}
+			filter := &types.TransactionFilter{Limit: 2}
+			pages := 0
+			for {
+				page, err := s.ListUserTransactions(int(acc.AccountNumber), filter)
+				require.NoError(t, err)
+				pages++
+
+				for i, tran := range page.Transactions {
+					assert.False(t, seen[tran.Id.String()])
+					seen[tran.Id.String()] = true
+					if i > 0 {
+						assert.False(t, tran.Date.After(page.Transactions[i-1].Date), "newest first")
+					}
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				filter.After, err = types.DecodeTransactionCursor(page.NextCursor)
+				require.NoError(t, err)
+			}
+			assert.Len(t, seen, 6)
+			assert.Equal(t, 3, pages)
+
+			sent, err := s.ListUserTransactions(int(acc.AccountNumber), &types.TransactionFilter{Direction: types.DirectionSent})
+			require.NoError(t, err)
+			require.Len(t, sent.Transactions, 1)
+			assert.Equal(t, other.AccountNumber, sent.Transactions[0].Rec_acc.AccountNumber)
+
+			min, max := usd(t, "11"), usd(t, "13")
+			ranged, err := s.ListUserTransactions(int(acc.AccountNumber), &types.TransactionFilter{MinAmount: &min, MaxAmount: &max})
+			require.NoError(t, err)
+			assert.Len(t, ranged.Transactions, 3)
+
+			withOther, err := s.ListUserTransactions(int(other.AccountNumber), &types.TransactionFilter{Counterparty: acc.AccountNumber})
+			require.NoError(t, err)
+			assert.Len(t, withOther.Transactions, 1)
+
+			future, err := s.ListUserTransactions(int(acc.AccountNumber), &types.TransactionFilter{From: time.Now().Add(time.Hour)})
+			require.NoError(t, err)
+			assert.Empty(t, future.Transactions)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	types "github.com/mrkhay/gobank/type"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	account := int(100000000*10 + types.LuhnCheckDigit(100000000))
+	cases := []struct {
+		name  string
+		req   any
+		codes map[string]string
+	}{
+		{"valid account", &types.CreateAccountRequest{FirstName: "Ada", LastName: "Lovelace", Email: "ada@gobank.test", Password: "engine-1843"}, nil},
+		{"missing fields", &types.CreateAccountRequest{FirstName: " ", Email: "ada@gobank.test", Password: "engine-1843"},
+			map[string]string{"firstname": "missing_field", "lastname": "missing_field"}},
+		{"bad email and password", &types.CreateAccountRequest{FirstName: "Ada", LastName: "Lovelace", Email: "Ada <ada@gobank.test>", Password: "12345678"},
+			map[string]string{"email": "invalid_email", "password": "weak_password"}},
+		{"name too long", &types.CreateAccountRequest{FirstName: string(make([]byte, 51)), LastName: "Lovelace", Email: "ada@gobank.test", Password: "engine-1843", Currency: "XYZ"},
+			map[string]string{"firstname": "too_large", "currency": "invalid_currency"}},
+		{"negative top up", &types.TopUpRequest{Account: account, Amount: types.NewMoney(-100, "USD")},
+			map[string]string{"amount": "invalid_amount"}},
+		{"zero transfer", &types.TransferRequest{FromAccount: account, ToAccount: account/10*10 + (account+1)%10, Amount: types.NewMoney(0, "USD")},
+			map[string]string{"toAccount": "invalid_account_number", "amount": "invalid_amount"}},
+		{"left out pointers", &types.UpdateAccountRequest{Version: 1}, nil},
+		{"blank pointer", &types.UpdateAccountRequest{LastName: ptr(""), Version: 0},
+			map[string]string{"lastname": "missing_field", "version": "too_small"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := types.Validate(c.req)
+			if c.codes == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			e, ok := types.AsError(err)
+			require.True(t, ok, "%v", err)
+			assert.Equal(t, types.KindValidation, e.Kind)
+
+			codes := map[string]string{}
+			for _, f := range e.Fields {
+				codes[f.Field] = f.Code
+			}
+			assert.Equal(t, c.codes, codes)
+		})
+	}
+}
+
+func TestFieldErrorsInResponse(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do("POST", "/account", map[string]string{"firstname": "Ada", "email": "not an email", "password": "short"}, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	body := decodeError(t, rec)
+	assert.Equal(t, "invalid_fields", body.Code)
+	assert.Equal(t, []types.FieldError{
+		{Field: "lastname", Code: "missing_field", Message: "lastname is required"},
+		{Field: "email", Code: "invalid_email", Message: "email must be a valid email address"},
+		{Field: "password", Code: "weak_password", Message: "password must be at least 8 characters"},
+	}, body.Fields)
+
+	accounts, err := ts.store.GetAccounts()
+	require.NoError(t, err)
+	assert.Empty(t, accounts)
+}
+
+func TestSelfTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	acc := newTestAccount(t, ts.store, "self-transfer@gobank.test")
+	require.NoError(t, ts.store.TopUpAccount(topUp(acc.AccountNumber, usd(t, "100"))))
+	require.NoError(t, ts.store.SetFXRate(fxRate(t, "USD", "EUR", "0.9", 0)))
+
+	transfer := map[string]any{
+		"fromAccount": acc.AccountNumber,
+		"toAccount":   acc.AccountNumber,
+		"amount":      map[string]string{"amount": "10.00", "currency": "USD"},
+	}
+
+	rec := ts.do("POST", "/transfer", transfer, authHeaders(t, acc))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "self_transfer", decodeError(t, rec).Code)
+
+	transfer["toCurrency"] = "EUR"
+	rec = ts.do("POST", "/transfer", transfer, authHeaders(t, acc))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	got, err := ts.store.GetAccountByID(acc.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "9.00", got.BalanceIn("EUR").String())
+}
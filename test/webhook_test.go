@@ -0,0 +1,257 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrkhay/gobank/scheduler"
+	types "github.com/mrkhay/gobank/type"
+	"github.com/mrkhay/gobank/utility"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver is a partner endpoint that records what it is sent and
+// answers with status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rcv.received = append(rcv.received, r)
+	rcv.bodies = append(rcv.bodies, body)
+	w.WriteHeader(rcv.status)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"type":"transfer.completed"}`)
+	sig := utility.SignWebhook("secret", now, body)
+
+	assert.NoError(t, utility.VerifyWebhookSignature("secret", sig, body, now, time.Minute))
+	assert.Error(t, utility.VerifyWebhookSignature("other", sig, body, now, time.Minute))
+	assert.Error(t, utility.VerifyWebhookSignature("secret", sig, []byte(`{}`), now, time.Minute))
+	assert.Error(t, utility.VerifyWebhookSignature("secret", sig, body, now.Add(time.Hour), time.Minute), "replayed")
+}
+
+// partnerClient trusts partner's certificate and sends every request to
+// it, whatever host the URL names.
+func partnerClient(partner *httptest.Server) *http.Client {
+	client := partner.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, partner.Listener.Addr().String())
+	}
+	return client
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	ts := newTestServer(t)
+	rcv := &webhookReceiver{status: http.StatusOK}
+	partner := httptest.NewTLSServer(rcv)
+	defer partner.Close()
+
+	from := newTestAccount(t, ts.store, "hook-from@gobank.test")
+	to := newTestAccount(t, ts.store, "hook-to@gobank.test")
+
+	res := ts.do("POST", fmt.Sprintf("/account/%d/webhooks", from.ID), map[string]any{
+		"url":    "https://example.com/hooks",
+		"events": []string{types.EventTransferCompleted},
+	}, authHeaders(t, from))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var endpoint types.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&endpoint))
+	require.NotEmpty(t, endpoint.Secret)
+
+	require.NoError(t, ts.store.TopUpAccount(topUp(from.AccountNumber, usd(t, "50"))))
+	_, err := ts.store.Transfer(&types.TransferRequest{
+		FromAccount: int(from.AccountNumber),
+		ToAccount:   int(to.AccountNumber),
+		Amount:      usd(t, "20"),
+	})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	dispatcher := scheduler.NewWebhookDispatcher(ts.store, partnerClient(partner))
+	require.NoError(t, dispatcher.RunDue(now))
+	require.NoError(t, dispatcher.RunDue(now))
+
+	// only the transfer, not the top up, and only once
+	require.Len(t, rcv.received, 1)
+	req, body := rcv.received[0], rcv.bodies[0]
+	assert.Equal(t, types.EventTransferCompleted, req.Header.Get("X-Gobank-Event"))
+	assert.NoError(t, utility.VerifyWebhookSignature(endpoint.Secret, req.Header.Get(utility.WebhookSignatureHeader), body, now, time.Minute))
+
+	var event struct {
+		Type string                 `json:"type"`
+		Data types.TransactionEvent `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, to.AccountNumber, event.Data.To)
+	assert.Equal(t, usd(t, "20"), event.Data.Amount)
+
+	res = ts.do("GET", fmt.Sprintf("/account/%d/webhooks", from.ID), nil, authHeaders(t, from))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.NotContains(t, res.Body.String(), endpoint.Secret)
+}
+
+func TestWebhookRetriesAndDeadLetters(t *testing.T) {
+	ts := newTestServer(t)
+	rcv := &webhookReceiver{status: http.StatusInternalServerError}
+	partner := httptest.NewServer(rcv)
+	defer partner.Close()
+
+	admin := newStaffAccount(t, ts.store, "hook-admin@gobank.test", types.RoleAdmin)
+	customer := newTestAccount(t, ts.store, "hook-customer@gobank.test")
+
+	assert.Equal(t, http.StatusForbidden, ts.do("POST", "/admin/webhooks", map[string]any{"url": partner.URL}, authHeaders(t, customer)).Code)
+	res := ts.do("POST", "/admin/webhooks", map[string]any{"url": partner.URL, "events": []string{types.EventAccountCreated}}, authHeaders(t, admin))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	var endpoint types.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&endpoint))
+
+	newTestAccount(t, ts.store, "hook-new@gobank.test")
+
+	dispatcher := scheduler.NewWebhookDispatcher(ts.store, partner.Client())
+	at := time.Now().UTC()
+	for i := 0; i < types.MaxWebhookAttempts; i++ {
+		require.NoError(t, dispatcher.RunDue(at))
+		at = at.Add(7 * time.Hour)
+	}
+	assert.Len(t, rcv.received, types.MaxWebhookAttempts)
+
+	// deliveries are signed when they are sent, not for the time they were due
+	last := len(rcv.received) - 1
+	assert.NoError(t, utility.VerifyWebhookSignature(endpoint.Secret, rcv.received[last].Header.Get(utility.WebhookSignatureHeader), rcv.bodies[last], time.Now(), time.Minute))
+
+	var dead []*types.WebhookDelivery
+	res = ts.do("GET", "/admin/webhooks/dead-letters", nil, authHeaders(t, admin))
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&dead))
+	require.Len(t, dead, 1)
+	assert.Equal(t, types.MaxWebhookAttempts, dead[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, dead[0].LastStatus)
+
+	// customers cannot see or redeliver tenant deliveries
+	redeliver := fmt.Sprintf("/webhooks/deliveries/%s/redeliver", dead[0].Id)
+	assert.Equal(t, http.StatusNotFound, ts.do("POST", fmt.Sprintf("/account/%d", customer.ID)+redeliver, nil, authHeaders(t, customer)).Code)
+
+	res = ts.do("POST", "/admin"+redeliver, nil, authHeaders(t, admin))
+	require.Equal(t, http.StatusAccepted, res.Code, res.Body.String())
+	assert.Equal(t, http.StatusConflict, ts.do("POST", "/admin"+redeliver, nil, authHeaders(t, admin)).Code)
+
+	rcv.status = http.StatusNoContent
+	require.NoError(t, dispatcher.RunDue(time.Now().UTC()))
+	assert.Len(t, rcv.received, types.MaxWebhookAttempts+1)
+
+	dead, err := ts.store.GetWebhookDeliveries(0, types.DeliveryDead)
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestWebhookEndpointAddresses(t *testing.T) {
+	ts := newTestServer(t)
+	customer := newTestAccount(t, ts.store, "hook-urls@gobank.test")
+	path := fmt.Sprintf("/account/%d/webhooks", customer.ID)
+
+	for _, url := range []string{
+		"http://example.com/hooks",
+		"https://localhost/hooks",
+		"https://api.localhost/hooks",
+		"https://127.0.0.1/hooks",
+		"https://10.1.2.3/hooks",
+		"https://192.168.0.1/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hooks",
+		"https://[fd00::1]/hooks",
+		"https://0.0.0.0/hooks",
+	} {
+		res := ts.do("POST", path, map[string]any{"url": url}, authHeaders(t, customer))
+		require.Equal(t, http.StatusBadRequest, res.Code, url)
+		assert.Equal(t, "invalid_url", decodeError(t, res).Code, url)
+	}
+
+	res := ts.do("POST", path, map[string]any{"url": "https://93.184.216.34/hooks"}, authHeaders(t, customer))
+	assert.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+}
+
+// TestWebhookRefusesPrivateAddressWhenDialling covers a customer endpoint
+// whose name resolves to an internal address after it was registered.
+func TestWebhookRefusesPrivateAddressWhenDialling(t *testing.T) {
+	ts := newTestServer(t)
+	rcv := &webhookReceiver{status: http.StatusOK}
+	partner := httptest.NewTLSServer(rcv)
+	defer partner.Close()
+
+	from := newTestAccount(t, ts.store, "hook-rebind@gobank.test")
+	to := newTestAccount(t, ts.store, "hook-rebind-to@gobank.test")
+
+	_, port, err := net.SplitHostPort(partner.Listener.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, ts.store.CreateWebhookEndpoint(&types.WebhookEndpoint{
+		Id:        uuid.New(),
+		Account:   from.AccountNumber,
+		URL:       "https://localhost:" + port + "/hooks",
+		Secret:    "secret",
+		Events:    []string{types.EventTransferCompleted},
+		CreatedAt: time.Now().UTC(),
+	}))
+
+	require.NoError(t, ts.store.TopUpAccount(topUp(from.AccountNumber, usd(t, "50"))))
+	_, err = ts.store.Transfer(&types.TransferRequest{
+		FromAccount: int(from.AccountNumber),
+		ToAccount:   int(to.AccountNumber),
+		Amount:      usd(t, "20"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, scheduler.NewWebhookDispatcher(ts.store, nil).RunDue(time.Now().UTC()))
+	assert.Empty(t, rcv.received)
+
+	pending, err := ts.store.GetWebhookDeliveries(from.AccountNumber, types.DeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Contains(t, pending[0].LastError, "not public")
+}
+
+func TestWebhookDoesNotFollowRedirects(t *testing.T) {
+	ts := newTestServer(t)
+	rcv := &webhookReceiver{status: http.StatusOK}
+	target := httptest.NewServer(rcv)
+	defer target.Close()
+
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+
+	admin := newStaffAccount(t, ts.store, "hook-redirect@gobank.test", types.RoleAdmin)
+	res := ts.do("POST", "/admin/webhooks", map[string]any{"url": redirect.URL, "events": []string{types.EventAccountCreated}}, authHeaders(t, admin))
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+
+	newTestAccount(t, ts.store, "hook-redirect-new@gobank.test")
+
+	require.NoError(t, scheduler.NewWebhookDispatcher(ts.store, nil).RunDue(time.Now().UTC()))
+	assert.Empty(t, rcv.received)
+
+	pending, err := ts.store.GetWebhookDeliveries(0, types.DeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, http.StatusFound, pending[0].LastStatus)
+}
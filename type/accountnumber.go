@@ -0,0 +1,117 @@
+package types
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Account numbers are AccountNumberLength digits: a fixed length body
+// followed by a Luhn check digit, so most typos are caught before a
+// transfer ever reaches the database.
+const (
+	AccountNumberLength = 10
+
+	// MinAccountNumber is the smallest number with a check digit; any
+	// smaller one was handed out before check digits existed.
+	MinAccountNumber = accountNumberBase * 10
+
+	accountNumberBase = 100_000_000 // smallest 9 digit body
+	accountNumberMax  = 999_999_999
+)
+
+// AccountNumberGenerator hands out new, never before used account numbers.
+type AccountNumberGenerator interface {
+	Next() (int64, error)
+}
+
+// SequenceGenerator turns the values of a monotonically increasing
+// sequence (1, 2, 3, ...) into check digit protected account numbers.
+type SequenceGenerator struct {
+	next func() (int64, error)
+}
+
+func NewSequenceGenerator(next func() (int64, error)) *SequenceGenerator {
+	return &SequenceGenerator{next: next}
+}
+
+func (g *SequenceGenerator) Next() (int64, error) {
+
+	seq, err := g.next()
+	if err != nil {
+		return 0, err
+	}
+
+	body := accountNumberBase + seq
+	if seq < 0 || body > accountNumberMax {
+		return 0, fmt.Errorf("account number sequence exhausted")
+	}
+
+	return body*10 + int64(LuhnCheckDigit(body)), nil
+}
+
+// NewCounterGenerator returns a generator backed by an in-process counter,
+// for tests and local use.
+func NewCounterGenerator() *SequenceGenerator {
+	var mu sync.Mutex
+	var seq int64
+
+	return NewSequenceGenerator(func() (int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		seq++
+		return seq, nil
+	})
+}
+
+// LuhnCheckDigit returns the digit that makes body followed by it pass the
+// Luhn check.
+func LuhnCheckDigit(body int64) int {
+
+	sum := 0
+	double := true
+	for n := body; n > 0; n /= 10 {
+		d := int(n % 10)
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return (10 - sum%10) % 10
+}
+
+// legacyAccountNumbers is the highest number handed out before check
+// digits existed, see SetLegacyAccountNumbers.
+var legacyAccountNumbers atomic.Int64
+
+// SetLegacyAccountNumbers makes ValidAccountNumber accept the numbers up to
+// max, which were handed out before check digits existed. Only the storage
+// knows max; until it is set no such number is valid.
+func SetLegacyAccountNumbers(max int64) {
+	legacyAccountNumbers.Store(max)
+}
+
+// ValidAccountNumber reports whether n is a well formed account number.
+// Numbers shorter than AccountNumberLength are only valid if they were
+// handed out before check digits existed.
+func ValidAccountNumber(n int64) bool {
+
+	if n <= 0 {
+		return false
+	}
+
+	if n < MinAccountNumber {
+		return n <= legacyAccountNumbers.Load()
+	}
+
+	if n > accountNumberMax*10+9 {
+		return false
+	}
+
+	return LuhnCheckDigit(n/10) == int(n%10)
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"time"
+)
+
+const (
+	AccountActive  = "active"
+	AccountFrozen  = "frozen"
+	AccountDormant = "dormant"
+	AccountClosed  = "closed"
+)
+
+// DescriptionClosure is the description of the transfers paying out what
+// is left on an account being closed.
+const DescriptionClosure = "Account Closure"
+
+// accountTransitions lists the statuses each status can move to. Closed
+// is final.
+var accountTransitions = map[string][]string{
+	AccountActive:  {AccountFrozen, AccountDormant, AccountClosed},
+	AccountFrozen:  {AccountActive, AccountClosed},
+	AccountDormant: {AccountActive, AccountFrozen, AccountClosed},
+}
+
+// AccountStatusRequest moves an account to Status. Freezing needs a
+// reason; closing an account that still holds funds needs a
+// PayoutAccount to send them to.
+type AccountStatusRequest struct {
+	Status        string `json:"status" validate:"required"`
+	Reason        string `json:"reason" validate:"max=200"`
+	PayoutAccount int64  `json:"payout_account"`
+}
+
+// CloseAccountRequest is the optional body of DELETE /account/{id}.
+type CloseAccountRequest struct {
+	Reason        string `json:"reason" validate:"max=200"`
+	PayoutAccount int64  `json:"payout_account"`
+}
+
+// SetStatus moves the account to status if the state machine allows it.
+func (a *Account) SetStatus(status, reason string, now time.Time) error {
+
+	if _, ok := accountTransitions[status]; !ok && status != AccountClosed {
+		return Validation("invalid_status", "status must be %s, %s, %s or %s", AccountActive, AccountFrozen, AccountDormant, AccountClosed)
+	}
+
+	if status == AccountFrozen && reason == "" {
+		return Validation("reason_required", "a reason is required to freeze an account")
+	}
+
+	allowed := false
+	for _, next := range accountTransitions[a.Status] {
+		allowed = allowed || next == status
+	}
+
+	if !allowed {
+		return Conflict("invalid_status_transition", "account %d cannot go from %s to %s", a.AccountNumber, a.Status, status)
+	}
+
+	a.Status = status
+	a.StatusReason = reason
+	a.StatusChangedAt = &now
+	return nil
+}
+
+// CanDebit reports why money cannot be taken out of the account, if it
+// cannot.
+func (a *Account) CanDebit() error {
+
+	switch a.Status {
+	case AccountFrozen:
+		return Conflict("account_frozen", "account %d is frozen", a.AccountNumber)
+	case AccountDormant:
+		return Conflict("account_dormant", "account %d is dormant, contact support to reactivate it", a.AccountNumber)
+	}
+
+	return a.CanCredit()
+}
+
+// CanCredit reports why money cannot be paid into the account, if it
+// cannot. Only closed accounts refuse credits.
+func (a *Account) CanCredit() error {
+
+	if a.Status == AccountClosed {
+		return Conflict("account_closed", "account %d is closed", a.AccountNumber)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of an AccountToken.
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+// Lifetimes of the tokens mailed to account holders.
+const (
+	EmailVerificationTTL = 48 * time.Hour
+	PasswordResetTTL     = time.Hour
+)
+
+// AccountToken is a single use token mailed to an account holder to
+// verify their email or reset their password. Only a hash is stored. Email
+// is the address the token was sent to, so a verification does not carry
+// over to an email changed in the meantime.
+type AccountToken struct {
+	Id            uuid.UUID
+	AccountNumber int64
+	Purpose       string
+	Email         string
+	TokenHash     string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// Valid reports whether the token can still be used for purpose at now.
+func (tok *AccountToken) Valid(purpose string, now time.Time) bool {
+	return tok.Purpose == purpose && tok.UsedAt == nil && now.Before(tok.ExpiresAt)
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password"`
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies a domain error. The HTTP layer maps each kind to a
+// status code; errors without a kind are treated as internal.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindValidation
+	KindNotFound
+	KindConflict
+	KindInsufficientFunds
+	KindUnauthorized
+	KindForbidden
+	KindMethodNotAllowed
+	KindTooManyRequests
+)
+
+// Error is a domain error that is safe to show to clients. Code is a stable
+// machine readable identifier, Message is meant for humans. Validation
+// errors from Validate also say which fields were wrong.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind ErrorKind, code string, format string, a ...any) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func Validation(code string, format string, a ...any) *Error {
+	return newError(KindValidation, code, format, a...)
+}
+
+func NotFound(code string, format string, a ...any) *Error {
+	return newError(KindNotFound, code, format, a...)
+}
+
+func Conflict(code string, format string, a ...any) *Error {
+	return newError(KindConflict, code, format, a...)
+}
+
+func InsufficientFunds(format string, a ...any) *Error {
+	return newError(KindInsufficientFunds, "insufficient_funds", format, a...)
+}
+
+func Unauthorized(code string, format string, a ...any) *Error {
+	return newError(KindUnauthorized, code, format, a...)
+}
+
+func Forbidden(code string, format string, a ...any) *Error {
+	return newError(KindForbidden, code, format, a...)
+}
+
+func MethodNotAllowed(method string) *Error {
+	return newError(KindMethodNotAllowed, "method_not_allowed", "method %s not allowed", method)
+}
+
+func TooManyRequests(code string, format string, a ...any) *Error {
+	return newError(KindTooManyRequests, code, format, a...)
+}
+
+// AsError returns the domain error in err's chain, if there is one.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// KindOf returns the kind of the domain error in err's chain, or
+// KindInternal if there is none.
+func KindOf(err error) ErrorKind {
+	if e, ok := AsError(err); ok {
+		return e.Kind
+	}
+	return KindInternal
+}
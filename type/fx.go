@@ -0,0 +1,143 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxFXRateAge is how old a rate may get before conversions at it are
+// refused.
+const MaxFXRateAge = 24 * time.Hour
+
+// spreads are in basis points, so a spread of 10000 would take everything
+const maxSpread = 10000
+
+var ratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,12})?$`)
+
+// FXRate converts Base into Quote: one unit of Base is worth Rate units of
+// Quote at the mid market. Customers are charged Spread basis points on
+// top of that.
+type FXRate struct {
+	Base      string    `json:"base"`
+	Quote     string    `json:"quote"`
+	Rate      string    `json:"rate"`
+	Spread    int       `json:"spread_bps"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SetFXRateRequest struct {
+	Rate   string `json:"rate" validate:"required"`
+	Spread int    `json:"spread_bps"`
+}
+
+func NewFXRate(base, quote string, req *SetFXRateRequest, now time.Time) (*FXRate, error) {
+
+	for _, currency := range []string{base, quote} {
+		if _, err := CurrencyExponent(currency); err != nil {
+			return nil, Validation("invalid_currency", "%v", err)
+		}
+	}
+
+	if base == quote {
+		return nil, Validation("invalid_currency", "base and quote currency must differ")
+	}
+
+	rate, ok := new(big.Rat).SetString(req.Rate)
+	if !ratePattern.MatchString(req.Rate) || !ok || rate.Sign() <= 0 {
+		return nil, Validation("invalid_rate", "rate must be a positive decimal with at most 12 decimal places")
+	}
+
+	if req.Spread < 0 || req.Spread >= maxSpread {
+		return nil, Validation("invalid_spread", "spread_bps must be between 0 and %d", maxSpread-1)
+	}
+
+	return &FXRate{
+		Base:      base,
+		Quote:     quote,
+		Rate:      req.Rate,
+		Spread:    req.Spread,
+		UpdatedAt: now,
+	}, nil
+}
+
+// applied is the mid rate less the spread.
+func (r *FXRate) applied() (*big.Rat, error) {
+
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s/%s rate %q", r.Base, r.Quote, r.Rate)
+	}
+
+	return rate.Mul(rate, big.NewRat(int64(maxSpread-r.Spread), maxSpread)), nil
+}
+
+// AppliedRate is the rate customers convert at, as an exact decimal.
+func (r *FXRate) AppliedRate() (string, error) {
+
+	applied, err := r.applied()
+	if err != nil {
+		return "", err
+	}
+
+	// a 12 digit rate times a 4 digit spread has at most 16 digits
+	return formatRate(applied), nil
+}
+
+// formatRate formats rate with up to 16 decimal places and no trailing
+// zeros.
+func formatRate(rate *big.Rat) string {
+	s := strings.TrimRight(rate.FloatString(16), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Convert returns amount in the quote currency at the applied rate,
+// rounded down to the quote currency's minor unit.
+func (r *FXRate) Convert(amount Money, now time.Time) (Money, error) {
+
+	if amount.Currency != r.Base {
+		return Money{}, fmt.Errorf("cannot convert %s at the %s/%s rate", amount.Currency, r.Base, r.Quote)
+	}
+
+	if now.Sub(r.UpdatedAt) > MaxFXRateAge {
+		return Money{}, Conflict("stale_fx_rate", "the %s/%s rate is out of date, try again later", r.Base, r.Quote)
+	}
+
+	baseExp, err := CurrencyExponent(r.Base)
+	if err != nil {
+		return Money{}, err
+	}
+
+	quoteExp, err := CurrencyExponent(r.Quote)
+	if err != nil {
+		return Money{}, err
+	}
+
+	applied, err := r.applied()
+	if err != nil {
+		return Money{}, err
+	}
+
+	// minor units of base -> major units of base -> major units of quote
+	// -> minor units of quote
+	v := new(big.Rat).SetInt64(amount.Amount)
+	v.Mul(v, applied)
+	v.Mul(v, new(big.Rat).SetFrac(pow10(quoteExp), pow10(baseExp)))
+
+	minor := new(big.Int).Quo(v.Num(), v.Denom())
+	if !minor.IsInt64() {
+		return Money{}, Validation("invalid_amount", "amount is too large to convert")
+	}
+
+	if minor.Sign() <= 0 {
+		return Money{}, Validation("invalid_amount", "amount is too small to convert to %s", r.Quote)
+	}
+
+	return NewMoney(minor.Int64(), r.Quote), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
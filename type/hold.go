@@ -0,0 +1,167 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldReleased = "released"
+	HoldExpired  = "expired"
+)
+
+// Holds without an expiry of their own lapse after DefaultHoldDuration; no
+// hold may be placed for longer than MaxHoldDuration.
+const (
+	DefaultHoldDuration = 7 * 24 * time.Hour
+	MaxHoldDuration     = 30 * 24 * time.Hour
+)
+
+// Hold reserves Amount on Account for a payment to ToAccount that has
+// been authorised but not made yet. While it is active the funds stay in
+// the account's balance but not in its available balance. Capturing it
+// makes the payment, for up to the amount held, and releasing it or
+// letting it expire gives the funds back.
+type Hold struct {
+	Id            uuid.UUID  `json:"id"`
+	Account       int64      `json:"account"`
+	ToAccount     int64      `json:"to_account"`
+	Amount        Money      `json:"amount"`
+	Captured      *Money     `json:"captured_amount,omitempty"`
+	TransactionId *uuid.UUID `json:"transaction_id,omitempty"`
+	Description   string     `json:"description"`
+	Status        string     `json:"status"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type CreateHoldRequest struct {
+	Account     int64      `json:"account" validate:"account"`
+	ToAccount   int64      `json:"to_account" validate:"account"`
+	Amount      Money      `json:"amount" validate:"positive"`
+	Description string     `json:"description" validate:"max=80"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// CaptureHoldRequest captures Amount of a hold, or all of it when Amount
+// is not given. Whatever is not captured is released.
+type CaptureHoldRequest struct {
+	Amount *Money `json:"amount" validate:"positive"`
+}
+
+func NewHold(req *CreateHoldRequest, now time.Time) (*Hold, error) {
+
+	if !ValidAccountNumber(req.Account) || !ValidAccountNumber(req.ToAccount) {
+		return nil, Validation("invalid_account_number", "invalid account number")
+	}
+
+	if req.Account == req.ToAccount {
+		return nil, Validation("invalid_account_number", "to_account must be another account")
+	}
+
+	if err := req.Amount.Validate(); err != nil || !req.Amount.IsPositive() {
+		return nil, Validation("invalid_amount", "amount must be greater than zero")
+	}
+
+	description := req.Description
+	if description == "" {
+		description = "Payment"
+	}
+
+	if len(description) > 80 {
+		return nil, Validation("invalid_description", "description must be at most 80 characters")
+	}
+
+	expiresAt := now.Add(DefaultHoldDuration)
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.UTC()
+	}
+
+	if !expiresAt.After(now) || expiresAt.After(now.Add(MaxHoldDuration)) {
+		return nil, Validation("invalid_expires_at", "expires_at must be in the future and at most %d days away", int(MaxHoldDuration.Hours()/24))
+	}
+
+	return &Hold{
+		Id:          uuid.New(),
+		Account:     req.Account,
+		ToAccount:   req.ToAccount,
+		Amount:      req.Amount,
+		Description: description,
+		Status:      HoldActive,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// Active reports whether the hold still holds its funds at now. A hold
+// counts as expired from ExpiresAt on, even before Expire has been called.
+func (h *Hold) Active(now time.Time) bool {
+	return h.Status == HoldActive && now.Before(h.ExpiresAt)
+}
+
+// Expire marks an active hold that has run out as expired and reports
+// whether it did so.
+func (h *Hold) Expire(now time.Time) bool {
+
+	if h.Status != HoldActive || h.Active(now) {
+		return false
+	}
+
+	h.Status = HoldExpired
+	h.UpdatedAt = now
+	return true
+}
+
+// Capture marks amount of the hold, or all of it if amount is nil, as
+// captured and returns the amount to transfer.
+func (h *Hold) Capture(amount *Money, now time.Time) (Money, error) {
+
+	if err := h.checkActive(now); err != nil {
+		return Money{}, err
+	}
+
+	captured := h.Amount
+	if amount != nil {
+		if err := amount.Validate(); err != nil || !amount.IsPositive() || amount.Currency != h.Amount.Currency {
+			return Money{}, Validation("invalid_amount", "amount must be a positive amount in %s", h.Amount.Currency)
+		}
+		if amount.Amount > h.Amount.Amount {
+			return Money{}, Validation("invalid_amount", "at most %s %s can be captured", h.Amount, h.Amount.Currency)
+		}
+		captured = *amount
+	}
+
+	h.Status = HoldCaptured
+	h.Captured = &captured
+	h.UpdatedAt = now
+	return captured, nil
+}
+
+func (h *Hold) Release(now time.Time) error {
+
+	if err := h.checkActive(now); err != nil {
+		return err
+	}
+
+	h.Status = HoldReleased
+	h.UpdatedAt = now
+	return nil
+}
+
+func (h *Hold) checkActive(now time.Time) error {
+
+	if h.Status == HoldActive && !h.Active(now) {
+		return Conflict("hold_expired", "hold %s expired at %s", h.Id, h.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if h.Status != HoldActive {
+		return Conflict("hold_not_active", "hold %s is %s", h.Id, h.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// IdempotencyRecord remembers the outcome of a request sent with an
+// Idempotency-Key header so a retry can be answered without running the
+// request again. A record without a status code is still in flight.
+type IdempotencyRecord struct {
+	Key         string
+	Scope       string
+	Fingerprint string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
@@ -0,0 +1,128 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// System accounts are internal ledger accounts that customer money comes
+// from or goes to. They use negative numbers so they never collide with
+// customer account numbers, and unlike customer accounts they may carry a
+// negative balance.
+const (
+	SystemAccountTopUp    int64 = -1
+	SystemAccountFees     int64 = -2
+	SystemAccountSuspense int64 = -3
+	SystemAccountFX       int64 = -4
+)
+
+const (
+	AccountKindCustomer = "customer"
+	AccountKindSystem   = "system"
+)
+
+const (
+	EntryKindTransfer = "transfer"
+	EntryKindTopUp    = "topup"
+	EntryKindOpening  = "opening"
+	EntryKindReversal = "reversal"
+	EntryKindRefund   = "refund"
+)
+
+// Posting is one line of a journal entry. A positive amount credits the
+// account (raises its balance), a negative amount debits it.
+type Posting struct {
+	Account int64 `json:"acc_number"`
+	Amount  Money `json:"amount"`
+}
+
+// JournalEntry groups the postings of a single business event. The
+// postings of an entry always sum to zero in every currency, so money is
+// only ever moved and never created or destroyed.
+type JournalEntry struct {
+	Id            uuid.UUID  `json:"entry_id"`
+	TransactionId *uuid.UUID `json:"transaction_id,omitempty"`
+	Kind          string     `json:"kind"`
+	Description   string     `json:"description"`
+	Postings      []Posting  `json:"postings"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+func NewJournalEntry(kind, description string, transactionId *uuid.UUID, postings ...Posting) (*JournalEntry, error) {
+
+	entry := &JournalEntry{
+		Id:            uuid.New(),
+		TransactionId: transactionId,
+		Kind:          kind,
+		Description:   description,
+		Postings:      postings,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// NewTransferEntry debits from and credits to with amount.
+func NewTransferEntry(kind, description string, transactionId *uuid.UUID, from, to int64, amount Money) (*JournalEntry, error) {
+	return NewJournalEntry(kind, description, transactionId,
+		Posting{Account: from, Amount: amount.Neg()},
+		Posting{Account: to, Amount: amount},
+	)
+}
+
+// NewConversionEntry debits from with debit and credits to with credit, the
+// same value in another currency. The FX system account buys the one and
+// sells the other, so the entry balances in both currencies.
+func NewConversionEntry(kind, description string, transactionId *uuid.UUID, from, to int64, debit, credit Money) (*JournalEntry, error) {
+	return NewJournalEntry(kind, description, transactionId,
+		Posting{Account: from, Amount: debit.Neg()},
+		Posting{Account: SystemAccountFX, Amount: debit},
+		Posting{Account: SystemAccountFX, Amount: credit.Neg()},
+		Posting{Account: to, Amount: credit},
+	)
+}
+
+// Validate checks that the entry has at least two non-zero postings and
+// that they balance in every currency.
+func (e *JournalEntry) Validate() error {
+
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("journal entry needs at least two postings")
+	}
+
+	sums := map[string]Money{}
+	for _, p := range e.Postings {
+		if err := p.Amount.Validate(); err != nil {
+			return err
+		}
+
+		if p.Amount.IsZero() {
+			return fmt.Errorf("journal entry has a zero posting for account %d", p.Account)
+		}
+
+		sum, ok := sums[p.Amount.Currency]
+		if !ok {
+			sum = NewMoney(0, p.Amount.Currency)
+		}
+
+		sum, err := sum.Add(p.Amount)
+		if err != nil {
+			return err
+		}
+		sums[p.Amount.Currency] = sum
+	}
+
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("journal entry does not balance: %s %s left over", sum, currency)
+		}
+	}
+
+	return nil
+}
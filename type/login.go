@@ -0,0 +1,168 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Login attempt results kept in the login history.
+const (
+	LoginSucceeded   = "succeeded"
+	LoginMFARequired = "mfa_required"
+	LoginFailed      = "failed"
+	LoginThrottled   = "throttled"
+)
+
+// LoginAttempt is one entry of the login history. AccountNumber is set by
+// the storage when the email belongs to an account, so failed attempts
+// against a real account show up in its history too.
+type LoginAttempt struct {
+	Id            uuid.UUID `json:"id"`
+	AccountNumber *int64    `json:"-"`
+	Email         string    `json:"-"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	Result        string    `json:"result"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ThrottlePolicy says how hard to slow down guessing on one key. After
+// FreeAttempts failures every attempt has to wait twice as long as the one
+// before, up to MaxDelay, and after LockoutAfter failures the key is locked
+// for LockoutDuration. Failures are forgotten after Window without any.
+type ThrottlePolicy struct {
+	FreeAttempts    int
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// EmailLoginPolicy guards a single account. IPLoginPolicy is looser since
+// many people can share an address behind a NAT, but still stops one
+// client from trying password after password across accounts.
+var (
+	EmailLoginPolicy = ThrottlePolicy{
+		FreeAttempts:    3,
+		MaxDelay:        30 * time.Second,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	IPLoginPolicy = ThrottlePolicy{
+		FreeAttempts:    20,
+		MaxDelay:        30 * time.Second,
+		LockoutAfter:    100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+// EmailResetPolicy and IPResetPolicy hold back password reset requests,
+// which are counted apart from logins so asking for resets cannot lock an
+// account out. Every request counts, as each one sends a mail.
+var (
+	EmailResetPolicy = ThrottlePolicy{
+		FreeAttempts:    3,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+	IPResetPolicy = ThrottlePolicy{
+		FreeAttempts:    20,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+)
+
+// LoginThrottle counts the recent login failures for a key such as
+// "email:someone@example.com" or "ip:192.0.2.1".
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// EmailThrottleKey and IPThrottleKey name the counters of a login.
+func EmailThrottleKey(email string) string {
+	return "email:" + email
+}
+
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ResetThrottleKey and ResetIPThrottleKey name the counters of a password
+// reset request.
+func ResetThrottleKey(email string) string {
+	return "reset:email:" + email
+}
+
+func ResetIPThrottleKey(ip string) string {
+	return "reset:ip:" + ip
+}
+
+// Fail counts a failed login at now.
+func (l *LoginThrottle) Fail(now time.Time, p ThrottlePolicy) {
+
+	if now.Sub(l.LastFailureAt) > p.Window {
+		l.Failures = 0
+		l.LockedUntil = nil
+	}
+
+	l.Failures++
+	l.LastFailureAt = now
+
+	if l.Failures >= p.LockoutAfter {
+		until := now.Add(p.LockoutDuration)
+		l.LockedUntil = &until
+	}
+}
+
+// Refund takes back a failure that was counted before the attempt was
+// checked, once it turned out to succeed.
+func (l *LoginThrottle) Refund(p ThrottlePolicy) {
+
+	if l.Failures > 0 {
+		l.Failures--
+	}
+
+	if l.Failures < p.LockoutAfter {
+		l.LockedUntil = nil
+	}
+}
+
+// RetryAfter is how long the next attempt has to wait from now, zero if
+// it may go ahead.
+func (l *LoginThrottle) RetryAfter(now time.Time, p ThrottlePolicy) time.Duration {
+
+	if l.LockedUntil != nil && l.LockedUntil.After(now) {
+		return l.LockedUntil.Sub(now)
+	}
+
+	if l.Failures < p.FreeAttempts || now.Sub(l.LastFailureAt) > p.Window {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	if n := l.Failures - p.FreeAttempts; n < 16 && time.Second<<n < p.MaxDelay {
+		delay = time.Second << n
+	}
+
+	if wait := l.LastFailureAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// Locked reports whether the key is locked out at now, as opposed to
+// only being slowed down.
+func (l *LoginThrottle) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && l.LockedUntil.After(now)
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes an enrolment gets.
+	RecoveryCodeCount = 10
+	// MaxMFAAttempts is how many wrong codes a login challenge takes
+	// before it stops working.
+	MaxMFAAttempts = 5
+)
+
+// MFA is the TOTP enrolment of an account. It is pending until the first
+// code is confirmed, which sets EnabledAt. LastUsedStep is the time step
+// of the last code accepted, so no code can be used twice.
+type MFA struct {
+	AccountNumber int64
+	Secret        string
+	EnabledAt     *time.Time
+	LastUsedStep  int64
+	CreatedAt     time.Time
+}
+
+func (m *MFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
+
+// MFAChallenge is the first half of a login to an account with MFA
+// enabled: the password was right and the second factor is still owed.
+// Only a hash of the token is stored.
+type MFAChallenge struct {
+	Id            uuid.UUID
+	AccountNumber int64
+	TokenHash     string
+	Attempts      int
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// Valid reports whether the challenge can still be completed at now.
+func (c *MFAChallenge) Valid(now time.Time) bool {
+	return c.UsedAt == nil && now.Before(c.ExpiresAt) && c.Attempts < MaxMFAAttempts
+}
+
+// MFAEnrollResponse carries a new secret, both raw for typing in and as an
+// otpauth URI for a QR code.
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFACodeRequest proves possession of the second factor with either a
+// code from the authenticator app or a recovery code.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// MFAChallengeResponse is returned by /login instead of tokens when the
+// account has MFA enabled; the token is exchanged at /login/mfa.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// RecoveryCodesResponse shows the recovery codes of an enrolment. They are
+// only ever shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,202 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for accounts opened without an explicit currency.
+const DefaultCurrency = "USD"
+
+// currencyExponents holds the number of minor unit digits of every
+// supported ISO 4217 currency.
+var currencyExponents = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"NGN": 2,
+	"GHS": 2,
+	"KES": 2,
+	"ZAR": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Money is an exact amount of a currency, kept as an integer number of
+// minor units (cents for USD) so arithmetic never rounds.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: currency}
+}
+
+// CurrencyExponent returns the number of decimal places used by currency.
+func CurrencyExponent(currency string) (int, error) {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exp, nil
+}
+
+// Currencies lists the supported currencies in alphabetical order.
+func Currencies() []string {
+
+	currencies := make([]string, 0, len(currencyExponents))
+	for currency := range currencyExponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	return currencies
+}
+
+// ParseMoney parses a plain decimal string such as "12.50" in the given
+// currency. Exponents, thousands separators, currency symbols and more
+// fraction digits than the currency allows are all rejected.
+func ParseMoney(amount, currency string) (Money, error) {
+
+	exp, err := CurrencyExponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if !decimalPattern.MatchString(amount) {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+
+	whole, frac, _ := strings.Cut(amount, ".")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", amount, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q is out of range", amount)
+	}
+
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal without the currency code.
+func (m Money) String() string {
+
+	exp := currencyExponents[m.Currency]
+
+	sign := ""
+	u := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		u = uint64(-(m.Amount + 1)) + 1
+	}
+
+	digits := strconv.FormatUint(u, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("currency mismatch %s and %s", m.Currency, o.Currency)
+	}
+
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) || (o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o. Both must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("amount overflow")
+	}
+	return m.Add(o.Neg())
+}
+
+// Validate checks that the currency is supported.
+func (m Money) Validate() error {
+	_, err := CurrencyExponent(m.Currency)
+	return err
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.String(), Currency: m.Currency})
+}
+
+// UnmarshalJSON accepts only {"amount":"12.34","currency":"USD"}. Amounts
+// must be strings so they never pass through a float64.
+func (m *Money) UnmarshalJSON(b []byte) error {
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	var v moneyJSON
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf(`money must look like {"amount":"12.34","currency":"USD"}: %v`, err)
+	}
+
+	if v.Amount == "" || v.Currency == "" {
+		return fmt.Errorf("money requires both amount and currency")
+	}
+
+	parsed, err := ParseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
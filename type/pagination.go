@@ -0,0 +1,139 @@
+package types
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// TransactionFilter narrows down and pages an account's transaction
+// history. Zero values mean no restriction.
+type TransactionFilter struct {
+	Limit        int
+	After        *TransactionCursor
+	From         time.Time // inclusive
+	To           time.Time // exclusive
+	MinAmount    *Money
+	MaxAmount    *Money
+	Direction    string
+	Status       string
+	Counterparty int64
+}
+
+// TransactionPage is one page of history, newest first. NextCursor is
+// empty on the last page.
+type TransactionPage struct {
+	Transactions []*Transcation `json:"transactions"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+}
+
+// TransactionCursor points at the last transaction of a page. History is
+// ordered by date and then id, both descending, so the pair is a stable
+// position even when transactions share a timestamp.
+type TransactionCursor struct {
+	Date time.Time
+	Id   uuid.UUID
+}
+
+// Before reports whether tran comes after the cursor in history order.
+func (c *TransactionCursor) Before(tran *Transcation) bool {
+
+	if !tran.Date.Equal(c.Date) {
+		return tran.Date.Before(c.Date)
+	}
+
+	return tran.Id.String() < c.Id.String()
+}
+
+func (c *TransactionCursor) Encode() string {
+	raw := c.Date.UTC().Format(time.RFC3339Nano) + "|" + c.Id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeTransactionCursor(s string) (*TransactionCursor, error) {
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+
+	date, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+
+	c := &TransactionCursor{}
+	if c.Date, err = time.Parse(time.RFC3339Nano, date); err != nil {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+
+	if c.Id, err = uuid.Parse(id); err != nil {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+
+	return c, nil
+}
+
+// Match reports whether tran, seen from account, passes the filter. The
+// cursor and limit are not taken into account.
+func (f *TransactionFilter) Match(account int64, tran *Transcation) bool {
+
+	sent := tran.Sen_acc.AccountNumber == account
+	received := tran.Rec_acc.AccountNumber == account
+
+	switch {
+	case !sent && !received:
+		return false
+	case f.Direction == DirectionSent && !sent:
+		return false
+	case f.Direction == DirectionReceived && !received:
+		return false
+	case !f.From.IsZero() && tran.Date.Before(f.From):
+		return false
+	case !f.To.IsZero() && !tran.Date.Before(f.To):
+		return false
+	case f.Status != "" && tran.Status != f.Status:
+		return false
+	case (f.MinAmount != nil || f.MaxAmount != nil) &&
+		!(sent && f.inRange(tran.Amount)) && !(received && f.inRange(tran.Credited())):
+		return false
+	}
+
+	if f.Counterparty != 0 {
+		other := tran.Rec_acc.AccountNumber
+		if !sent {
+			other = tran.Sen_acc.AccountNumber
+		}
+		return other == f.Counterparty
+	}
+
+	return true
+}
+
+// inRange reports whether amount is in the currency of the amount filters
+// and between them. Converted transfers are matched on the leg the account
+// took part in.
+func (f *TransactionFilter) inRange(amount Money) bool {
+
+	if f.MinAmount != nil && (amount.Currency != f.MinAmount.Currency || amount.Amount < f.MinAmount.Amount) {
+		return false
+	}
+
+	if f.MaxAmount != nil && (amount.Currency != f.MaxAmount.Currency || amount.Amount > f.MaxAmount.Amount) {
+		return false
+	}
+
+	return true
+}
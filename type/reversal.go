@@ -0,0 +1,148 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	StatusReversed          = "reversed"
+	StatusRefunded          = "refunded"
+	StatusPartiallyRefunded = "partially_refunded"
+)
+
+const (
+	DescriptionReversal = "Reversal"
+	DescriptionRefund   = "Refund"
+)
+
+// RefundRequest gives back part of a received transfer. Amount is in the
+// currency the recipient was credited in and defaults to everything not
+// refunded yet.
+type RefundRequest struct {
+	Amount *Money `json:"amount" validate:"positive"`
+}
+
+// Remaining is the part of the credited amount not given back yet.
+func (tr *Transcation) Remaining() Money {
+
+	remaining := tr.Credited()
+	if tr.Refunded != nil {
+		remaining.Amount -= tr.Refunded.Amount
+	}
+
+	return remaining
+}
+
+// Compensate builds the transaction that gives amount of tran's credited
+// leg back from the receiver to the sender, with its journal entry, and
+// updates tran's status and refunded amount to match. A reversal always
+// gives back everything that is left, so amount must be nil for it.
+//
+// Converted transfers are given back at the rate they were made at, so a
+// full refund returns exactly what the sender paid.
+func Compensate(tran *Transcation, amount *Money, reversal bool) (*Transcation, *JournalEntry, error) {
+
+	switch {
+	case tran.OriginalId != nil:
+		return nil, nil, Conflict("not_reversible", "reversals and refunds cannot be undone")
+	case tran.Status == StatusReversed:
+		return nil, nil, Conflict("already_reversed", "transaction %s was already reversed", tran.Id)
+	case tran.Status == StatusRefunded:
+		return nil, nil, Conflict("already_refunded", "transaction %s was already refunded in full", tran.Id)
+	case !reversal && tran.Sen_acc.AccountNumber < 0:
+		return nil, nil, Validation("not_refundable", "only transfers between customers can be refunded")
+	}
+
+	remaining := tran.Remaining()
+	back := remaining
+	if amount != nil {
+		if err := amount.Validate(); err != nil || !amount.IsPositive() || amount.Currency != remaining.Currency {
+			return nil, nil, Validation("invalid_amount", "amount must be a positive amount in %s", remaining.Currency)
+		}
+		if amount.Amount > remaining.Amount {
+			return nil, nil, Validation("invalid_amount", "at most %s %s can still be refunded", remaining, remaining.Currency)
+		}
+		back = *amount
+	}
+
+	refunded := NewMoney(0, remaining.Currency)
+	if tran.Refunded != nil {
+		refunded = *tran.Refunded
+	}
+
+	description, kind := DescriptionRefund, EntryKindRefund
+	if reversal {
+		description, kind = DescriptionReversal, EntryKindReversal
+	}
+
+	from, to := int(tran.Rec_acc.AccountNumber), int(tran.Sen_acc.AccountNumber)
+	comp, err := NewTransaction(&from, &to, back, "Credit", description)
+	if err != nil {
+		return nil, nil, err
+	}
+	comp.OriginalId = &tran.Id
+
+	var entry *JournalEntry
+	if tran.Converted == nil {
+		entry, err = NewTransferEntry(kind, description, &comp.Id, tran.Rec_acc.AccountNumber, tran.Sen_acc.AccountNumber, back)
+	} else {
+		returned := NewMoney(returnedSoFar(tran, refunded.Amount+back.Amount)-returnedSoFar(tran, refunded.Amount), tran.Amount.Currency)
+		if !returned.IsPositive() {
+			return nil, nil, Validation("invalid_amount", "amount is too small to refund")
+		}
+
+		comp.Converted = &returned
+		comp.FXRate = formatRate(rateOf(back, returned))
+		entry, err = NewConversionEntry(kind, description, &comp.Id, tran.Rec_acc.AccountNumber, tran.Sen_acc.AccountNumber, back, returned)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refunded.Amount += back.Amount
+	tran.Refunded = &refunded
+
+	switch {
+	case reversal:
+		tran.Status = StatusReversed
+	case refunded.Amount == tran.Credited().Amount:
+		tran.Status = StatusRefunded
+	default:
+		tran.Status = StatusPartiallyRefunded
+	}
+
+	return comp, entry, nil
+}
+
+// returnedSoFar is how much of the sender's original amount is owed back
+// once credited minor units of the converted amount have been refunded.
+// Working from the running total keeps rounding from ever adding up to
+// more, or less, than the original amount.
+func returnedSoFar(tran *Transcation, credited int64) int64 {
+	v := new(big.Int).Mul(big.NewInt(credited), big.NewInt(tran.Amount.Amount))
+	return v.Quo(v, big.NewInt(tran.Converted.Amount)).Int64()
+}
+
+// rateOf is the rate to units of b per unit of a.
+func rateOf(a, b Money) *big.Rat {
+
+	aExp, _ := CurrencyExponent(a.Currency)
+	bExp, _ := CurrencyExponent(b.Currency)
+
+	rate := new(big.Rat).SetFrac(big.NewInt(b.Amount), big.NewInt(a.Amount))
+	return rate.Mul(rate, new(big.Rat).SetFrac(pow10(aExp), pow10(bExp)))
+}
+
+// CompensationEvent returns the webhook event for a reversal or refund.
+func CompensationEvent(comp *Transcation) (*OutboxEvent, error) {
+
+	switch comp.Description {
+	case DescriptionReversal:
+		return NewTransactionEvent(EventTransactionReversed, comp)
+	case DescriptionRefund:
+		return NewTransactionEvent(EventTransactionRefunded, comp)
+	}
+
+	return nil, fmt.Errorf("transaction %s is neither a reversal nor a refund", comp.Id)
+}
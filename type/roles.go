@@ -0,0 +1,42 @@
+package types
+
+type Permission string
+
+const (
+	PermViewAccounts     Permission = "accounts:view"
+	PermViewTransactions Permission = "transactions:view"
+	PermTopUp            Permission = "accounts:topup"
+	PermReverse          Permission = "transactions:reverse"
+	PermManageRoles      Permission = "roles:manage"
+	PermManageWebhooks   Permission = "webhooks:manage"
+	PermManageFXRates    Permission = "fx:manage"
+	PermManageHolds      Permission = "holds:manage"
+	PermManageAccounts   Permission = "accounts:manage"
+)
+
+// rolePermissions lists what each role may do on top of managing its own
+// account, which every role can.
+var rolePermissions = map[string][]Permission{
+	RoleCustomer: {},
+	RoleSupport:  {PermViewAccounts, PermViewTransactions},
+	RoleOperator: {PermViewAccounts, PermViewTransactions, PermTopUp, PermReverse, PermManageHolds, PermManageAccounts},
+	RoleAdmin:    {PermViewAccounts, PermViewTransactions, PermTopUp, PermReverse, PermManageRoles, PermManageWebhooks, PermManageFXRates, PermManageHolds, PermManageAccounts},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
@@ -0,0 +1,212 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+const (
+	StandingOrderActive    = "active"
+	StandingOrderPaused    = "paused"
+	StandingOrderCompleted = "completed"
+	StandingOrderFailed    = "failed"
+	StandingOrderCancelled = "cancelled"
+)
+
+// A failed run is retried MaxStandingOrderAttempts times in total, waiting
+// StandingOrderRetryDelay times the attempt number in between. After that
+// a one-off order fails and a recurring one skips to its next date.
+const (
+	MaxStandingOrderAttempts = 3
+	StandingOrderRetryDelay  = time.Hour
+)
+
+// StandingOrder is a transfer that is made once at a future date or
+// repeatedly on a schedule. Run n (counting from 0) is due at StartAt plus
+// n periods; monthly runs keep StartAt's day, clamped to the end of
+// shorter months.
+type StandingOrder struct {
+	Id          uuid.UUID  `json:"id"`
+	Account     int64      `json:"account"`
+	ToAccount   int64      `json:"to_account"`
+	Amount      Money      `json:"amount"`
+	Description string     `json:"description"`
+	Frequency   string     `json:"frequency"`
+	StartAt     time.Time  `json:"start_at"`
+	EndDate     *time.Time `json:"end_date,omitempty"`
+	MaxRuns     int        `json:"max_runs,omitempty"`
+	RunsDone    int        `json:"runs_done"`
+	NextRunAt   time.Time  `json:"next_run_at"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	Status      string     `json:"status"`
+	LockedUntil *time.Time `json:"-"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// StandingOrderRun records one attempt at executing a standing order.
+type StandingOrderRun struct {
+	Id            uuid.UUID  `json:"id"`
+	OrderId       uuid.UUID  `json:"order_id"`
+	Run           int        `json:"run"`
+	Attempt       int        `json:"attempt"`
+	TransactionId *uuid.UUID `json:"transaction_id,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CreateStandingOrderRequest struct {
+	ToAccount   int64      `json:"to_account" validate:"account"`
+	Amount      Money      `json:"amount" validate:"positive"`
+	Description string     `json:"description" validate:"max=80"`
+	Frequency   string     `json:"frequency" validate:"required"`
+	StartAt     time.Time  `json:"start_at"`
+	EndDate     *time.Time `json:"end_date"`
+	Count       int        `json:"count" validate:"min=0"`
+}
+
+// UpdateStandingOrderRequest changes the fields that are set. Status can
+// only move between active and paused; cancelling is done with DELETE.
+type UpdateStandingOrderRequest struct {
+	Amount      *Money     `json:"amount" validate:"positive"`
+	Description *string    `json:"description" validate:"max=80"`
+	EndDate     *time.Time `json:"end_date"`
+	Count       *int       `json:"count" validate:"min=0"`
+	Status      *string    `json:"status"`
+}
+
+func NewStandingOrder(account int64, req *CreateStandingOrderRequest, now time.Time) (*StandingOrder, error) {
+
+	if !ValidAccountNumber(req.ToAccount) || req.ToAccount == account {
+		return nil, Validation("invalid_account_number", "to_account must be another valid account number")
+	}
+
+	if err := req.Amount.Validate(); err != nil || !req.Amount.IsPositive() {
+		return nil, Validation("invalid_amount", "amount must be greater than zero")
+	}
+
+	switch req.Frequency {
+	case FrequencyOnce, FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return nil, Validation("invalid_frequency", "frequency must be once, daily, weekly or monthly")
+	}
+
+	if req.StartAt.IsZero() || req.StartAt.Before(now.Add(-time.Minute)) {
+		return nil, Validation("invalid_start_at", "start_at must be in the future")
+	}
+
+	if req.Count < 0 {
+		return nil, Validation("invalid_count", "count cannot be negative")
+	}
+
+	if req.EndDate != nil && req.EndDate.Before(req.StartAt) {
+		return nil, Validation("invalid_end_date", "end_date must not be before start_at")
+	}
+
+	description := req.Description
+	if description == "" {
+		description = "Standing Order"
+	}
+
+	return &StandingOrder{
+		Id:          uuid.New(),
+		Account:     account,
+		ToAccount:   req.ToAccount,
+		Amount:      req.Amount,
+		Description: description,
+		Frequency:   req.Frequency,
+		StartAt:     req.StartAt.UTC(),
+		EndDate:     req.EndDate,
+		MaxRuns:     req.Count,
+		NextRunAt:   req.StartAt.UTC(),
+		Status:      StandingOrderActive,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// RunAt returns when run n of the order is due.
+func (o *StandingOrder) RunAt(n int) time.Time {
+
+	switch o.Frequency {
+	case FrequencyDaily:
+		return o.StartAt.AddDate(0, 0, n)
+	case FrequencyWeekly:
+		return o.StartAt.AddDate(0, 0, 7*n)
+	case FrequencyMonthly:
+		// AddDate would roll 31 January over into March
+		first := time.Date(o.StartAt.Year(), o.StartAt.Month()+time.Month(n), 1,
+			o.StartAt.Hour(), o.StartAt.Minute(), o.StartAt.Second(), o.StartAt.Nanosecond(), time.UTC)
+		day := o.StartAt.Day()
+		if last := first.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return first.AddDate(0, 0, day-1)
+	}
+
+	return o.StartAt
+}
+
+// Succeeded moves the order on to its next run, completing it when there
+// is none left.
+func (o *StandingOrder) Succeeded(now time.Time) {
+	o.RunsDone++
+	o.Attempts = 0
+	o.LastError = ""
+	o.advance(now)
+}
+
+// Failed records a failed attempt at the current run. It returns true if
+// the run was given up on, rather than scheduled for another attempt.
+func (o *StandingOrder) Failed(now time.Time, reason string) bool {
+
+	o.Attempts++
+	o.LastError = reason
+	o.UpdatedAt = now
+
+	if o.Attempts < MaxStandingOrderAttempts {
+		o.NextRunAt = now.Add(time.Duration(o.Attempts) * StandingOrderRetryDelay)
+		return false
+	}
+
+	o.Attempts = 0
+	if o.Frequency == FrequencyOnce {
+		o.Status = StandingOrderFailed
+		return true
+	}
+
+	// the missed run still counts towards the order's count
+	o.RunsDone++
+	o.advance(now)
+	return true
+}
+
+func (o *StandingOrder) advance(now time.Time) {
+
+	o.UpdatedAt = now
+	next := o.RunAt(o.RunsDone)
+
+	if o.Frequency == FrequencyOnce ||
+		(o.MaxRuns > 0 && o.RunsDone >= o.MaxRuns) ||
+		(o.EndDate != nil && next.After(*o.EndDate)) {
+		o.Status = StandingOrderCompleted
+		return
+	}
+
+	o.NextRunAt = next
+}
+
+// Finished reports whether the order will never run again.
+func (o *StandingOrder) Finished() bool {
+	return o.Status == StandingOrderCompleted || o.Status == StandingOrderFailed || o.Status == StandingOrderCancelled
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// Statement is an account's activity over [From, To) with the balance
+// before, after and at every transaction.
+type Statement struct {
+	Account *Account        `json:"account"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Opening Money           `json:"opening_balance"`
+	Closing Money           `json:"closing_balance"`
+	Lines   []StatementLine `json:"lines"`
+}
+
+// StatementLine is one transaction seen from the statement's account.
+// Amount is negative for money leaving the account.
+type StatementLine struct {
+	Transaction  *Transcation `json:"transaction"`
+	Counterparty int64        `json:"counterparty"`
+	Amount       Money        `json:"amount"`
+	Balance      Money        `json:"balance"`
+}
+
+// NewStatement builds the statement of acc's sub-balance in currency from
+// its full transaction history. Transactions before from make up the
+// opening balance; those at or after to are left out.
+func NewStatement(acc *Account, currency string, history []*Transcation, from, to time.Time) (*Statement, error) {
+
+	sorted := append([]*Transcation(nil), history...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	st := &Statement{
+		Account: acc,
+		From:    from,
+		To:      to,
+		Opening: NewMoney(0, currency),
+		Lines:   []StatementLine{},
+	}
+
+	for _, tran := range sorted {
+
+		if !tran.Date.Before(to) {
+			continue
+		}
+
+		amount, ok := tran.Change(acc.AccountNumber, currency)
+		if !ok {
+			continue
+		}
+
+		counterparty := tran.Rec_acc.AccountNumber
+		if counterparty == acc.AccountNumber {
+			counterparty = tran.Sen_acc.AccountNumber
+		}
+
+		if tran.Date.Before(from) {
+			opening, err := st.Opening.Add(amount)
+			if err != nil {
+				return nil, err
+			}
+			st.Opening = opening
+			continue
+		}
+
+		balance := st.Opening
+		if n := len(st.Lines); n > 0 {
+			balance = st.Lines[n-1].Balance
+		}
+
+		balance, err := balance.Add(amount)
+		if err != nil {
+			return nil, err
+		}
+
+		st.Lines = append(st.Lines, StatementLine{
+			Transaction:  tran,
+			Counterparty: counterparty,
+			Amount:       amount,
+			Balance:      balance,
+		})
+	}
+
+	st.Closing = st.Opening
+	if n := len(st.Lines); n > 0 {
+		st.Closing = st.Lines[n-1].Balance
+	}
+
+	return st, nil
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is the server side record of a refresh token. Only a hash
+// of the token is stored. Every refresh replaces the token with a new one
+// of the same family; presenting a replaced token again revokes the whole
+// family, since it means the token was stolen.
+type RefreshToken struct {
+	Id            uuid.UUID
+	FamilyId      uuid.UUID
+	AccountNumber int64
+	TokenHash     string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	RevokedAt     *time.Time
+	ReplacedBy    *uuid.UUID
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenResponse is returned by every endpoint that logs a user in.
+type TokenResponse struct {
+	Account      *Account `json:"account,omitempty"`
+	Token        string   `json:"token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+}
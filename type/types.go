@@ -1,24 +1,36 @@
 package types
 
 import (
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
 type CreateAccountRequest struct {
-	FirstName string `json:"firstname"`
-	LastName  string `json:"lastname"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
+	FirstName string `json:"firstname" validate:"required,max=50"`
+	LastName  string `json:"lastname" validate:"required,max=50"`
+	Email     string `json:"email" validate:"required,max=50,email"`
+	Password  string `json:"password" validate:"required,password"`
+	// Currency is the account's own currency, USD when left out.
+	Currency string `json:"currency" validate:"currency"`
 }
 
+// TransferRequest moves Amount out of the sender's sub-balance in that
+// currency. The receiver is credited in ToCurrency, which defaults to the
+// receiver's own currency; the amount is converted when they differ.
 type TransferRequest struct {
-	ToAccount   int       `json:"toAccount"`
-	FromAccount int       `json:"fromAccount"`
-	Amount      string    `json:"amount"`
+	ToAccount   int       `json:"toAccount" validate:"account"`
+	FromAccount int       `json:"fromAccount" validate:"account"`
+	Amount      Money     `json:"amount" validate:"positive"`
+	ToCurrency  string    `json:"toCurrency,omitempty" validate:"currency"`
 	Date        time.Time `json:"date"`
 }
 
@@ -28,40 +40,73 @@ type TranscationDetails struct {
 }
 
 type TopUpRequest struct {
-	Account int    `json:"acc_number"`
-	Amount  string `json:"amount"`
+	Account int   `json:"acc_number" validate:"account"`
+	Amount  Money `json:"amount" validate:"positive"`
+}
+
+// UpdateAccountRequest changes the profile fields that are set. Version
+// must be the version the client last read; the update is refused if the
+// account has changed since.
+type UpdateAccountRequest struct {
+	FirstName *string `json:"firstname" validate:"required,max=50"`
+	LastName  *string `json:"lastname" validate:"required,max=50"`
+	Email     *string `json:"email" validate:"required,max=50,email"`
+	// Password is needed to change the email, which password resets are
+	// sent to.
+	Password *string `json:"password"`
+	Version  int     `json:"version" validate:"min=1"`
 }
 
 type LoginRequest struct {
-	Email   string `json:"email"`
-	Pasword string `json:"password"`
+	Email   string `json:"email" validate:"required"`
+	Pasword string `json:"password" validate:"required"`
 }
 
 // Account represents a account object.
 // swagger:model
 type Account struct {
-	ID                int       `json:"-"`
-	FirstName         string    `json:"firstname"`
-	LastName          string    `json:"lastname"`
-	AccountNumber     int64     `json:"acc_number"`
-	Email             string    `json:"email"`
-	EncryptedPassword string    `json:"-"`
-	Balance           string    `json:"balance"`
-	CreatedAt         time.Time `json:"createdAt"`
+	ID                int        `json:"-"`
+	FirstName         string     `json:"firstname"`
+	LastName          string     `json:"lastname"`
+	AccountNumber     int64      `json:"acc_number"`
+	Email             string     `json:"email"`
+	EncryptedPassword string     `json:"-"`
+	Balance           Money      `json:"balance"`
+	AvailableBalance  *Money     `json:"available_balance,omitempty"`
+	Balances          []Money    `json:"balances,omitempty"`
+	Held              []Money    `json:"-"`
+	Kind              string     `json:"-"`
+	Version           int        `json:"version"`
+	Role              string     `json:"role"`
+	Status            string     `json:"status,omitempty"`
+	StatusReason      string     `json:"status_reason,omitempty"`
+	StatusChangedAt   *time.Time `json:"status_changed_at,omitempty"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at,omitempty"`
+	PasswordChangedAt *time.Time `json:"-"`
+	CreatedAt         time.Time  `json:"createdAt"`
 }
 
+// Transcation moves Amount out of the sender. When currencies were
+// converted the receiver was credited Converted instead, at FXRate units
+// of Converted per unit of Amount. Reversals and refunds are transactions
+// of their own that point back at the transaction they undo through
+// OriginalId; Refunded tracks how much of it has been given back so far.
 type Transcation struct {
-	Id          uuid.UUID `json:"transaction_id"`
-	Sen_acc     Account   `json:"sen_acc"`
-	Rec_acc     Account   `json:"rec_acc"`
-	Amount      string    `json:"amount"`
-	Status      string    `json:"status"`
-	Description string    `json:"description"`
-	Date        time.Time `json:"createdAt"`
+	Id          uuid.UUID  `json:"transaction_id"`
+	Sen_acc     Account    `json:"sen_acc"`
+	Rec_acc     Account    `json:"rec_acc"`
+	Amount      Money      `json:"amount"`
+	Converted   *Money     `json:"converted_amount,omitempty"`
+	FXRate      string     `json:"fx_rate,omitempty"`
+	Refunded    *Money     `json:"refunded_amount,omitempty"`
+	OriginalId  *uuid.UUID `json:"original_transaction_id,omitempty"`
+	Status      string     `json:"status"`
+	Description string     `json:"description"`
+	Date        time.Time  `json:"createdAt"`
 }
 
 func NewAccount(firstName, lastName, email, password string) (*Account, error) {
-	encow, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	encow, err := HashPassword(password)
 
 	if err != nil {
 		return nil, err
@@ -71,14 +116,23 @@ func NewAccount(firstName, lastName, email, password string) (*Account, error) {
 		FirstName:         firstName,
 		LastName:          lastName,
 		Email:             email,
-		Balance:           "0",
-		AccountNumber:     int64(rand.Intn(100000)),
+		Balance:           NewMoney(0, DefaultCurrency),
+		Kind:              AccountKindCustomer,
+		Version:           1,
+		Role:              RoleCustomer,
+		Status:            AccountActive,
 		CreatedAt:         time.Now().UTC(),
-		EncryptedPassword: string(encow),
+		EncryptedPassword: encow,
 	}, nil
 }
 
-func NewTransaction(s, r *int, amount, status, description string) (*Transcation, error) {
+// HashPassword returns the bcrypt hash stored in place of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func NewTransaction(s, r *int, amount Money, status, description string) (*Transcation, error) {
 
 	uuid := uuid.New()
 	sender := &Account{
@@ -98,3 +152,81 @@ func NewTransaction(s, r *int, amount, status, description string) (*Transcation
 		Date:        time.Now().UTC(),
 	}, nil
 }
+
+// BalanceIn returns the account's sub-balance in currency, which is zero
+// for currencies it has never held.
+func (a *Account) BalanceIn(currency string) Money {
+
+	if a.Balance.Currency == currency {
+		return a.Balance
+	}
+
+	for _, b := range a.Balances {
+		if b.Currency == currency {
+			return b
+		}
+	}
+
+	return NewMoney(0, currency)
+}
+
+// HasCurrency reports whether the account has a sub-balance in currency.
+// Its own currency always counts, even before anything was paid in.
+func (a *Account) HasCurrency(currency string) bool {
+
+	if a.Balance.Currency == currency {
+		return true
+	}
+
+	for _, b := range a.Balances {
+		if b.Currency == currency {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AvailableIn is the balance in currency less the funds held on it, which
+// is what can still be spent.
+func (a *Account) AvailableIn(currency string) Money {
+
+	available := a.BalanceIn(currency)
+	for _, h := range a.Held {
+		if h.Currency == currency {
+			available.Amount -= h.Amount
+		}
+	}
+
+	return available
+}
+
+// Credited is the amount the receiver got.
+func (tr *Transcation) Credited() Money {
+
+	if tr.Converted != nil {
+		return *tr.Converted
+	}
+
+	return tr.Amount
+}
+
+// Change returns how much tran moved account's sub-balance in currency,
+// and false if it did not touch that sub-balance at all.
+func (tr *Transcation) Change(account int64, currency string) (Money, bool) {
+
+	change := NewMoney(0, currency)
+	touched := false
+
+	if tr.Sen_acc.AccountNumber == account && tr.Amount.Currency == currency {
+		change.Amount -= tr.Amount.Amount
+		touched = true
+	}
+
+	if credited := tr.Credited(); tr.Rec_acc.AccountNumber == account && credited.Currency == currency {
+		change.Amount += credited.Amount
+		touched = true
+	}
+
+	return change, touched
+}
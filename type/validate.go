@@ -0,0 +1,206 @@
+package types
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError says what is wrong with one field of a request. Field is the
+// JSON name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Password limits. bcrypt ignores everything after 72 bytes.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// Validate checks the fields of the struct v points to against the rules
+// in their `validate` tags, separated by commas:
+//
+//	required  not blank or zero
+//	min=n     at least n characters, or at least n for numbers
+//	max=n     at most n characters, or at most n for numbers
+//	email     a bare email address
+//	password  long enough and not only letters or only digits
+//	currency  a supported currency
+//	account   a valid account number
+//	positive  an amount of a supported currency greater than zero
+//
+// Rules other than required are skipped for empty strings. Nil pointers
+// are optional fields that were left out and are not checked at all; on a
+// pointer, required means not blank when given. All fields are checked, and
+// the error lists every one that failed.
+func Validate(v any) error {
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []FieldError
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+
+		tag := rt.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		name := jsonName(rt.Field(i))
+		if fe := validateField(name, rv.Field(i), strings.Split(tag, ",")); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+
+	switch len(fields) {
+	case 0:
+		return nil
+	case 1:
+		return &Error{Kind: KindValidation, Code: fields[0].Code, Message: fields[0].Message, Fields: fields}
+	}
+
+	messages := make([]string, len(fields))
+	for i, fe := range fields {
+		messages[i] = fe.Message
+	}
+
+	return &Error{Kind: KindValidation, Code: "invalid_fields", Message: strings.Join(messages, "; "), Fields: fields}
+}
+
+func jsonName(f reflect.StructField) string {
+
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+
+	return name
+}
+
+// validateField returns the first rule that value breaks.
+func validateField(name string, value reflect.Value, rules []string) *FieldError {
+
+	fail := func(code, format string, a ...any) *FieldError {
+		return &FieldError{Field: name, Code: code, Message: name + " " + fmt.Sprintf(format, a...)}
+	}
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	for _, rule := range rules {
+
+		rule, arg, _ := strings.Cut(rule, "=")
+
+		if rule == "required" {
+			if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+				return fail("missing_field", "is required")
+			}
+			continue
+		}
+
+		if value.Kind() == reflect.String && value.String() == "" {
+			return nil
+		}
+
+		switch rule {
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: bad %s rule on %s", rule, name))
+			}
+
+			size, unit := int64(0), " characters"
+			switch value.Kind() {
+			case reflect.String:
+				size = int64(utf8.RuneCountInString(value.String()))
+			case reflect.Slice:
+				size, unit = int64(value.Len()), " items"
+			case reflect.Int, reflect.Int64:
+				size, unit = value.Int(), ""
+			}
+
+			if rule == "min" && size < int64(n) {
+				return fail("too_small", "must be at least %d%s", n, unit)
+			}
+			if rule == "max" && size > int64(n) {
+				return fail("too_large", "must be at most %d%s", n, unit)
+			}
+
+		case "email":
+			addr, err := mail.ParseAddress(value.String())
+			if err != nil || addr.Address != value.String() {
+				return fail("invalid_email", "must be a valid email address")
+			}
+
+		case "password":
+			if fe := checkPassword(value.String()); fe != "" {
+				return fail("weak_password", "%s", fe)
+			}
+
+		case "currency":
+			if _, err := CurrencyExponent(value.String()); err != nil {
+				return fail("invalid_currency", "%v", err)
+			}
+
+		case "account":
+			if !ValidAccountNumber(value.Int()) {
+				return fail("invalid_account_number", "is an invalid account number")
+			}
+
+		case "positive":
+			m := value.Interface().(Money)
+			if err := m.Validate(); err != nil {
+				return fail("invalid_amount", "%v", err)
+			}
+			if !m.IsPositive() {
+				return fail("invalid_amount", "must be greater than zero")
+			}
+
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+	}
+
+	return nil
+}
+
+// checkPassword explains what is wrong with password, or returns "".
+func checkPassword(password string) string {
+
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Sprintf("must be at least %d characters", MinPasswordLength)
+	}
+
+	if len(password) > MaxPasswordLength {
+		return fmt.Sprintf("must be at most %d bytes", MaxPasswordLength)
+	}
+
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+
+	if !letters || !others {
+		return "must mix letters with digits or symbols"
+	}
+
+	return ""
+}
@@ -0,0 +1,316 @@
+package types
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventTransferCompleted   = "transfer.completed"
+	EventTopUpCompleted      = "topup.completed"
+	EventAccountCreated      = "account.created"
+	EventAccountClosed       = "account.closed"
+	EventAccountStatus       = "account.status_changed"
+	EventTransactionReversed = "transaction.reversed"
+	EventTransactionRefunded = "transaction.refunded"
+)
+
+var WebhookEvents = []string{EventTransferCompleted, EventTopUpCompleted, EventAccountCreated, EventAccountClosed,
+	EventAccountStatus, EventTransactionReversed, EventTransactionRefunded}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// A delivery is attempted MaxWebhookAttempts times, waiting twice as long
+// after every failure starting at WebhookRetryDelay, before it is moved to
+// the dead letter list.
+const (
+	MaxWebhookAttempts = 8
+	WebhookRetryDelay  = 30 * time.Second
+	maxWebhookDelay    = 6 * time.Hour
+)
+
+// WebhookEndpoint receives the events of one account, or of every account
+// when Account is 0 (a tenant wide endpoint registered by an admin). An
+// empty Events list subscribes to everything.
+type WebhookEndpoint struct {
+	Id        uuid.UUID `json:"id"`
+	Account   int64     `json:"account,omitempty"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OutboxEvent is written in the same database transaction as the change
+// it describes, so an event exists if and only if the change committed.
+type OutboxEvent struct {
+	Id        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	Accounts  []int64         `json:"-"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WebhookDelivery is one event on its way to one endpoint.
+type WebhookDelivery struct {
+	Id            uuid.UUID        `json:"id"`
+	EventId       uuid.UUID        `json:"event_id"`
+	EndpointId    uuid.UUID        `json:"endpoint_id"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt time.Time        `json:"next_attempt_at"`
+	LastError     string           `json:"last_error,omitempty"`
+	LastStatus    int              `json:"last_status,omitempty"`
+	LockedUntil   *time.Time       `json:"-"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	Event         *OutboxEvent     `json:"event,omitempty"`
+	Endpoint      *WebhookEndpoint `json:"-"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" validate:"required"`
+	Events []string `json:"events"`
+}
+
+type TransactionEvent struct {
+	TransactionId uuid.UUID  `json:"transaction_id"`
+	From          int64      `json:"from_account"`
+	To            int64      `json:"to_account"`
+	Amount        Money      `json:"amount"`
+	Converted     *Money     `json:"converted_amount,omitempty"`
+	FXRate        string     `json:"fx_rate,omitempty"`
+	OriginalId    *uuid.UUID `json:"original_transaction_id,omitempty"`
+	Description   string     `json:"description"`
+	Date          time.Time  `json:"date"`
+}
+
+type AccountEvent struct {
+	Id            int    `json:"id"`
+	AccountNumber int64  `json:"account_number"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	Status        string `json:"status,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+func NewWebhookEndpoint(account int64, req *CreateWebhookRequest, secret string, now time.Time) (*WebhookEndpoint, error) {
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return nil, Validation("invalid_url", "url must be an absolute http or https URL")
+	}
+
+	// customers must not get the bank to call into its own network. Names
+	// are checked again when they are dialled, as they may resolve to
+	// something else by then.
+	if account != 0 {
+		if u.Scheme != "https" {
+			return nil, Validation("invalid_url", "url must be an https URL")
+		}
+
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		ip := net.ParseIP(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && !IsPublicIP(ip)) {
+			return nil, Validation("invalid_url", "url must not point to a local or private address")
+		}
+	}
+
+	for _, e := range req.Events {
+		if !validEvent(e) {
+			return nil, Validation("invalid_event", "unknown event %q", e)
+		}
+	}
+
+	events := req.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	return &WebhookEndpoint{
+		Id:        uuid.New(),
+		Account:   account,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: now,
+	}, nil
+}
+
+// nonPublicNets are the ranges IsPublicIP refuses on top of loopback,
+// link-local and private addresses: shared and reserved ranges, and NAT64
+// which can reach any of the others.
+var nonPublicNets = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsPublicIP reports whether ip is a public unicast address, one that a
+// customer's webhook may be sent to.
+func IsPublicIP(ip net.IP) bool {
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validEvent(e string) bool {
+	for _, known := range WebhookEvents {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Wants reports whether the endpoint is subscribed to event.
+func (e *WebhookEndpoint) Wants(event *OutboxEvent) bool {
+
+	// events still waiting in the outbox when the endpoint was registered
+	// are not sent to it
+	if event.CreatedAt.Before(e.CreatedAt) {
+		return false
+	}
+
+	if e.Account != 0 {
+		found := false
+		for _, acc := range event.Accounts {
+			found = found || acc == e.Account
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(e.Events) == 0 {
+		return true
+	}
+
+	for _, typ := range e.Events {
+		if typ == event.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewOutboxEvent(typ string, data any, now time.Time, accounts ...int64) (*OutboxEvent, error) {
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{
+		Id:        uuid.New(),
+		Type:      typ,
+		Accounts:  accounts,
+		Data:      raw,
+		CreatedAt: now,
+	}, nil
+}
+
+// NewTransactionEvent describes a committed transfer or top up.
+func NewTransactionEvent(typ string, tran *Transcation) (*OutboxEvent, error) {
+
+	data := TransactionEvent{
+		TransactionId: tran.Id,
+		From:          tran.Sen_acc.AccountNumber,
+		To:            tran.Rec_acc.AccountNumber,
+		Amount:        tran.Amount,
+		Converted:     tran.Converted,
+		FXRate:        tran.FXRate,
+		OriginalId:    tran.OriginalId,
+		Description:   tran.Description,
+		Date:          tran.Date,
+	}
+
+	return NewOutboxEvent(typ, data, tran.Date, tran.Sen_acc.AccountNumber, tran.Rec_acc.AccountNumber)
+}
+
+func NewAccountEvent(typ string, acc *Account, now time.Time) (*OutboxEvent, error) {
+
+	data := AccountEvent{
+		Id:            acc.ID,
+		AccountNumber: acc.AccountNumber,
+		FirstName:     acc.FirstName,
+		LastName:      acc.LastName,
+		Email:         acc.Email,
+		Status:        acc.Status,
+		Reason:        acc.StatusReason,
+	}
+
+	return NewOutboxEvent(typ, data, now, acc.AccountNumber)
+}
+
+func NewWebhookDelivery(event *OutboxEvent, endpoint *WebhookEndpoint) *WebhookDelivery {
+	return &WebhookDelivery{
+		Id:            uuid.New(),
+		EventId:       event.Id,
+		EndpointId:    endpoint.Id,
+		Status:        DeliveryPending,
+		NextAttemptAt: event.CreatedAt,
+		CreatedAt:     event.CreatedAt,
+	}
+}
+
+// Delivered marks a successful attempt.
+func (d *WebhookDelivery) Delivered(now time.Time, status int) {
+	d.Attempts++
+	d.Status = DeliveryDelivered
+	d.LastStatus = status
+	d.LastError = ""
+	d.DeliveredAt = &now
+}
+
+// Failed records a failed attempt and schedules the next one, or moves the
+// delivery to the dead letter list once it has run out of attempts.
+func (d *WebhookDelivery) Failed(now time.Time, status int, reason string) {
+
+	d.Attempts++
+	d.LastStatus = status
+	d.LastError = reason
+
+	if d.Attempts >= MaxWebhookAttempts {
+		d.Status = DeliveryDead
+		return
+	}
+
+	delay := WebhookRetryDelay << (d.Attempts - 1)
+	if delay > maxWebhookDelay {
+		delay = maxWebhookDelay
+	}
+	d.NextAttemptAt = now.Add(delay)
+}
+
+// Redeliver puts a delivery back in the queue with a fresh set of
+// attempts.
+func (d *WebhookDelivery) Redeliver(now time.Time) {
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	d.DeliveredAt = nil
+}
@@ -0,0 +1,86 @@
+package utility
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mrkhay/gobank/storage"
+	types "github.com/mrkhay/gobank/type"
+)
+
+type contextKey int
+
+const (
+	accountKey contextKey = iota
+	claimsKey
+	correlationKey
+)
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, types.Forbidden("forbidden", "forbidden"))
+}
+
+// WithAuth validates the x-jwt-token header, loads the account it was
+// issued for and stores it in the request context for the handler.
+func WithAuth(handlerFunc http.HandlerFunc, s storage.Storage) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		token, err := ValidateJWT(r.Header.Get("x-jwt-token"), s)
+		if err != nil || !token.Valid {
+			permissionDenied(w, r)
+			return
+		}
+
+		claims := token.Claims.(*Claims)
+		account, err := s.GetAccountByAccountNumber(claims.AccountNumber)
+		if err != nil || account.Status == types.AccountClosed {
+			permissionDenied(w, r)
+			return
+		}
+
+		// changing the password signs out every token issued before it;
+		// tokens only carry whole seconds, as do the ones issued with it
+		if account.PasswordChangedAt != nil && claims.IssuedAt < account.PasswordChangedAt.Unix() {
+			permissionDenied(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accountKey, account)
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+// AccountFromContext returns the account authenticated by WithAuth.
+func AccountFromContext(ctx context.Context) (*types.Account, bool) {
+	account, ok := ctx.Value(accountKey).(*types.Account)
+	return account, ok
+}
+
+// ClaimsFromContext returns the access token claims verified by WithAuth.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// RequirePermission only lets callers whose role grants perm through. It
+// must be wrapped by WithAuth.
+func RequirePermission(handlerFunc http.HandlerFunc, perm types.Permission) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		account, ok := AccountFromContext(r.Context())
+		if !ok {
+			permissionDenied(w, r)
+			return
+		}
+
+		if !types.HasPermission(account.Role, perm) {
+			forbidden(w, r)
+			return
+		}
+
+		handlerFunc(w, r)
+	}
+}
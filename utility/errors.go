@@ -0,0 +1,70 @@
+package utility
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	types "github.com/mrkhay/gobank/type"
+)
+
+const CorrelationIDHeader = "X-Request-ID"
+
+var validCorrelationID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var errorStatus = map[types.ErrorKind]int{
+	types.KindValidation:        http.StatusBadRequest,
+	types.KindNotFound:          http.StatusNotFound,
+	types.KindConflict:          http.StatusConflict,
+	types.KindInsufficientFunds: http.StatusUnprocessableEntity,
+	types.KindUnauthorized:      http.StatusUnauthorized,
+	types.KindForbidden:         http.StatusForbidden,
+	types.KindMethodNotAllowed:  http.StatusMethodNotAllowed,
+	types.KindTooManyRequests:   http.StatusTooManyRequests,
+}
+
+// WithCorrelationID tags every request with an id, taken from the
+// X-Request-ID header when the client sent a sane one, and echoes it back
+// so clients can quote it when reporting a failure.
+func WithCorrelationID(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id := r.Header.Get(CorrelationIDHeader)
+		if !validCorrelationID.MatchString(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(CorrelationIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), correlationKey, id)))
+	})
+}
+
+// CorrelationIDFromContext returns the id assigned by WithCorrelationID.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey).(string)
+	return id
+}
+
+// WriteError sends err to the client. Domain errors are mapped to their
+// status and code; anything else is logged and reported as an internal
+// error without its details.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+
+	id := CorrelationIDFromContext(r.Context())
+
+	e, ok := types.AsError(err)
+	if !ok || e.Kind == types.KindInternal {
+		log.Printf("[%s] %s %s: %v", id, r.Method, r.URL.Path, err)
+		WriteJson(w, http.StatusInternalServerError, ApiError{
+			Error:         "internal server error",
+			Code:          "internal_error",
+			CorrelationID: id,
+		})
+		return
+	}
+
+	WriteJson(w, errorStatus[e.Kind], ApiError{Error: e.Message, Code: e.Code, Fields: e.Fields, CorrelationID: id})
+}
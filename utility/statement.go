@@ -0,0 +1,86 @@
+package utility
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	types "github.com/mrkhay/gobank/type"
+)
+
+const statementDate = "2006-01-02"
+
+// WriteStatementCSV writes st as CSV, one row per transaction between an
+// opening and a closing balance row.
+func WriteStatementCSV(w io.Writer, st *types.Statement) error {
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "transaction_id", "description", "counterparty", "amount", "currency", "balance"})
+	cw.Write([]string{st.From.Format(statementDate), "", "Opening balance", "", "", st.Opening.Currency, st.Opening.String()})
+
+	for _, line := range st.Lines {
+		cw.Write([]string{
+			line.Transaction.Date.Format(time.RFC3339),
+			line.Transaction.Id.String(),
+			line.Transaction.Description,
+			counterparty(line),
+			line.Amount.String(),
+			line.Amount.Currency,
+			line.Balance.String(),
+		})
+	}
+
+	cw.Write([]string{st.To.Format(statementDate), "", "Closing balance", "", "", st.Closing.Currency, st.Closing.String()})
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// WriteStatementPDF renders st as a plain, monospaced PDF document.
+func WriteStatementPDF(w io.Writer, st *types.Statement) error {
+
+	row := "%-10s  %-24s  %-12s  %14s  %14s"
+	lines := []string{
+		"GOBANK ACCOUNT STATEMENT",
+		"",
+		fmt.Sprintf("Account holder:  %s %s", st.Account.FirstName, st.Account.LastName),
+		fmt.Sprintf("Account number:  %d", st.Account.AccountNumber),
+		fmt.Sprintf("Currency:        %s", st.Opening.Currency),
+		fmt.Sprintf("Period:          %s to %s", st.From.Format(statementDate), st.To.Format(statementDate)),
+		"",
+		fmt.Sprintf(row, "Date", "Description", "Counterparty", "Amount", "Balance"),
+		fmt.Sprintf(row, "", "Opening balance", "", "", st.Opening),
+	}
+
+	for _, line := range st.Lines {
+		lines = append(lines, fmt.Sprintf(row,
+			line.Transaction.Date.Format(statementDate),
+			truncate(line.Transaction.Description, 24),
+			counterparty(line),
+			line.Amount,
+			line.Balance,
+		))
+	}
+
+	lines = append(lines, fmt.Sprintf(row, "", "Closing balance", "", "", st.Closing))
+
+	return WriteTextPDF(w, lines)
+}
+
+// counterparty hides the internal numbers of system accounts such as the
+// top up account.
+func counterparty(line types.StatementLine) string {
+	if line.Counterparty <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(line.Counterparty, 10)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,77 @@
+package utility
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	types "github.com/mrkhay/gobank/type"
+)
+
+// Claims are the claims carried by every access token. The standard
+// claims provide exp, iat, iss, sub (the account number) and jti. Role is
+// informational for clients; authorization always uses the role stored
+// with the account so a demotion takes effect immediately.
+type Claims struct {
+	AccountNumber int64  `json:"accountnumber"`
+	Role          string `json:"role"`
+	jwt.StandardClaims
+}
+
+func jwtIssuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "gobank"
+}
+
+// AccessTokenTTL is how long an access token stays valid, JWT_ACCESS_TTL.
+func AccessTokenTTL() time.Duration {
+	return GetEnvDuration("JWT_ACCESS_TTL", 15*time.Minute)
+}
+
+// RefreshTokenTTL is how long a refresh token stays valid, JWT_REFRESH_TTL.
+func RefreshTokenTTL() time.Duration {
+	return GetEnvDuration("JWT_REFRESH_TTL", 30*24*time.Hour)
+}
+
+// HashToken returns the hex SHA-256 of an opaque token. Only hashes are
+// ever stored, so a leaked table cannot be used to log in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomToken returns a URL safe random string with n bytes of entropy.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewRefreshToken creates a refresh token for account. The plain token is
+// returned to the client, the record is what gets stored. The family is
+// left empty for a rotation to fill in.
+func NewRefreshToken(account int64) (string, *types.RefreshToken, error) {
+
+	plain, err := RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	return plain, &types.RefreshToken{
+		Id:            uuid.New(),
+		AccountNumber: account,
+		TokenHash:     HashToken(plain),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(RefreshTokenTTL()),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package utility
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as RFC 6238 recommends them and authenticator apps
+// expect by default: HMAC-SHA1, six digits, thirty second steps.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+
+	// totpSkew is how many steps either side of the current one are
+	// accepted, to allow for clock drift and slow typing.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func mfaIssuer() string {
+	if iss := os.Getenv("MFA_ISSUER"); iss != "" {
+		return iss
+	}
+	return "Gobank"
+}
+
+// NewTOTPSecret returns a random 160 bit secret in base32, the form
+// authenticator apps take it in.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth URI for secret that authenticator apps scan
+// from a QR code.
+func TOTPURI(secret, account string) string {
+
+	issuer := mfaIssuer()
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPStep is the number of the time step at now.
+func TOTPStep(now time.Time) int64 {
+	return now.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode is the code of secret for step.
+func TOTPCode(secret string, step int64) (string, error) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%1000000), nil
+}
+
+// VerifyTOTP checks code against secret at now and returns the step it
+// belongs to. Callers must make sure a step is only accepted once.
+func VerifyTOTP(secret, code string, now time.Time) (int64, bool) {
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		want, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes returns n single use codes such as "k3m9x-q7p2r" for
+// signing in without the authenticator app.
+func NewRecoveryCodes(n int) ([]string, error) {
+
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode undoes the changes people make when typing a
+// recovery code back in, so it hashes like the original.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -3,18 +3,25 @@ package utility
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/mrkhay/gobank/storage"
 	types "github.com/mrkhay/gobank/type"
 )
 
 type ApiError struct {
-	Error string `json:"error"`
+	Error         string             `json:"error"`
+	Code          string             `json:"code,omitempty"`
+	Fields        []types.FieldError `json:"fields,omitempty"`
+	CorrelationID string             `json:"correlation_id,omitempty"`
 }
 
 func WriteJson(w http.ResponseWriter, status int, v any) error {
@@ -24,57 +31,39 @@ func WriteJson(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	WriteJson(w, http.StatusBadGateway, ApiError{Error: "permission denied"})
-
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, types.Unauthorized("unauthorized", "permission denied"))
 }
 
+// WithJWTAuth authenticates the caller and additionally requires the
+// {id} path variable to be the caller's own account.
 func WithJWTAuth(handlerFunc http.HandlerFunc, s storage.Storage) http.HandlerFunc {
 
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		tokenString := r.Header.Get("x-jwt-token")
-		token, err := ValidateJWT(tokenString)
-
-		if err != nil {
-			permissionDenied(w)
-			return
-		}
-
-		if !token.Valid {
-			permissionDenied(w)
-			return
-		}
+	return WithAuth(func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := GetId(r)
 		if err != nil {
-			permissionDenied(w)
+			WriteError(w, r, err)
 			return
 		}
 
-		account, err := s.GetAccountByID(userID)
-
-		if err != nil {
-			permissionDenied(w)
+		account, _ := AccountFromContext(r.Context())
+		if account.ID != userID {
+			forbidden(w, r)
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		if float64(account.AccountNumber) != claims["accountnumber"] {
-			permissionDenied(w)
-			return
-
-		}
-
 		handlerFunc(w, r)
 
-	}
+	}, s)
 }
 
-func ValidateJWT(tokenString string) (*jwt.Token, error) {
+// ValidateJWT parses an access token and checks its signature, expiry,
+// issuer and that it has not been revoked.
+func ValidateJWT(tokenString string, s storage.Storage) (*jwt.Token, error) {
 	secreat := os.Getenv("JWT_SECRET")
 
-	return jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(t *jwt.Token) (interface{}, error) {
 
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
@@ -83,6 +72,93 @@ func ValidateJWT(tokenString string) (*jwt.Token, error) {
 		return []byte(secreat), nil
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*Claims)
+	if !claims.VerifyIssuer(jwtIssuer(), true) || claims.Id == "" || claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	revoked, err := s.IsAccessTokenRevoked(claims.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
+	return token, nil
+
+}
+
+// GetEnvDuration reads a duration such as "15m" from the environment,
+// falling back to def when it is unset or invalid.
+func GetEnvDuration(name string, def time.Duration) time.Duration {
+
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is
+// only believed when TRUST_PROXY says how many proxies in front of the
+// server append to it, "true" meaning one. Any client can send the header,
+// so only the entries added by those proxies count: the client is the one
+// the outermost of them saw, counting from the right.
+func ClientIP(r *http.Request) string {
+
+	if hops := trustedProxyHops(); hops > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					forwarded = append(forwarded, addr)
+				}
+			}
+		}
+
+		if len(forwarded) > hops {
+			return forwarded[len(forwarded)-hops]
+		}
+		if len(forwarded) > 0 {
+			return forwarded[0]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// trustedProxyHops reads TRUST_PROXY: "true" for one proxy, a count for a
+// chain of them, anything else for none.
+func trustedProxyHops() int {
+
+	v := os.Getenv("TRUST_PROXY")
+	if v == "true" {
+		return 1
+	}
+
+	hops, err := strconv.Atoi(v)
+	if err != nil || hops < 0 {
+		return 0
+	}
+
+	return hops
 }
 
 func GetId(r *http.Request) (int, error) {
@@ -91,19 +167,28 @@ func GetId(r *http.Request) (int, error) {
 	id, err := strconv.Atoi(idstr)
 
 	if err != nil {
-		return 0, fmt.Errorf("invalid id given %s", idstr)
+		return 0, types.Validation("invalid_id", "invalid id given %s", idstr)
 	}
 
 	return id, nil
 }
 
+// CreateJWT issues a short lived access token for account.
 func CreateJWT(account *types.Account) (string, error) {
 	secreat := os.Getenv("JWT_SECRET")
-	// create claims
+	now := time.Now().UTC()
 
-	claims := &jwt.MapClaims{
-		"expiresAt":     15000,
-		"accountnumber": account.AccountNumber,
+	// create claims
+	claims := &Claims{
+		AccountNumber: account.AccountNumber,
+		Role:          account.Role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   strconv.FormatInt(account.AccountNumber, 10),
+			Issuer:    jwtIssuer(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -0,0 +1,103 @@
+package utility
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	types "github.com/mrkhay/gobank/type"
+)
+
+const WebhookSignatureHeader = "X-Gobank-Signature"
+
+// SignWebhook returns the signature header value for body sent at
+// timestamp: "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". The
+// timestamp is signed too so a captured request cannot be replayed later.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + webhookMAC(secret, t, body)
+}
+
+// VerifyWebhookSignature checks a signature header made by SignWebhook and
+// rejects it if it is older than tolerance.
+func VerifyWebhookSignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			v1 = v
+		}
+	}
+
+	sec, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || v1 == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	if !hmac.Equal([]byte(v1), []byte(webhookMAC(secret, t, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+func webhookMAC(secret, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookClient returns the client webhooks are sent with. It never
+// follows redirects, and unless allowPrivate is set it refuses to connect
+// to anything but a public address. The check is made on the address
+// actually dialled, so a name that resolves to an internal one, now or
+// after the endpoint was registered, is refused too.
+func NewWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !types.IsPublicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if !allowPrivate {
+		// a proxy would do the dialling and bypass the check above
+		transport.Proxy = nil
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}